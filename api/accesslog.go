@@ -0,0 +1,356 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mateuszdyminski/go-template/redact"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	traceparent = http.CanonicalHeaderKey("traceparent")
+	referer     = http.CanonicalHeaderKey("Referer")
+)
+
+// Access log formats.
+const (
+	AccessLogJSON     = "json"
+	AccessLogCombined = "combined"
+	AccessLogLogfmt   = "logfmt"
+)
+
+// AccessLogConfig - access log settings.
+type AccessLogConfig struct {
+	Format string
+	// SampleRate - share of successful requests logged, from 0 to 1
+	SampleRate float64
+	// SlowThreshold - requests taking longer are always logged, 0 disables it
+	SlowThreshold time.Duration
+	// Output - "stdout", "stderr" or file path. Empty output means the application logger for
+	// JSON format and stdout for the others.
+	Output string
+	// Redactor masks secrets in URI and referer, default names are used when nil
+	Redactor *redact.Redactor
+}
+
+// AccessLogger logs every request at Info level once it's finished. Successful requests are
+// sampled, errors (status >= 400) and slow requests are always logged.
+type AccessLogger struct {
+	cfg AccessLogConfig
+
+	l   *zap.Logger // JSON format
+	mu  sync.Mutex
+	out io.Writer // other formats
+
+	sample func() float64
+}
+
+func NewAccessLogger(l *zap.Logger, cfg AccessLogConfig) (*AccessLogger, error) {
+	if cfg.Redactor == nil {
+		cfg.Redactor = redact.New(redact.Config{})
+	}
+	a := &AccessLogger{cfg: cfg, sample: rand.Float64}
+
+	var out zapcore.WriteSyncer
+	switch cfg.Output {
+	case "":
+		if cfg.Format == AccessLogJSON {
+			a.l = l.Named("access")
+			return a, nil
+		}
+		out = os.Stdout
+	case "stdout":
+		out = os.Stdout
+	case "stderr":
+		out = os.Stderr
+	default:
+		f, err := os.OpenFile(cfg.Output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't open access log file")
+		}
+		out = f
+	}
+
+	switch cfg.Format {
+	case AccessLogJSON:
+		enc := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+		a.l = zap.New(redact.NewCore(zapcore.NewCore(enc, out, zap.InfoLevel), cfg.Redactor)).Named("access")
+	case AccessLogCombined, AccessLogLogfmt:
+		a.out = out
+	default:
+		return nil, errors.Errorf("unknown access log format %q", cfg.Format)
+	}
+
+	return a, nil
+}
+
+// accessLogState - data of the request collected by inner middlewares and handlers.
+type accessLogState struct {
+	mu        sync.Mutex
+	principal *Principal
+	fields    []zap.Field
+}
+
+// AddAccessLogField adds field to the access log entry of the request.
+func AddAccessLogField(ctx context.Context, key string, value interface{}) {
+	if s, ok := ctx.Value(accessLogKey).(*accessLogState); ok {
+		s.mu.Lock()
+		s.fields = append(s.fields, zap.Any(key, value))
+		s.mu.Unlock()
+	}
+}
+
+func setAccessLogPrincipal(ctx context.Context, p *Principal) {
+	if s, ok := ctx.Value(accessLogKey).(*accessLogState); ok {
+		s.mu.Lock()
+		s.principal = p
+		s.mu.Unlock()
+	}
+}
+
+// accessLogEntry - single line of the access log.
+type accessLogEntry struct {
+	time      time.Time
+	requestID string
+	remote    string
+	method    string
+	uri       string
+	route     string
+	proto     string
+	status    int
+	bytesIn   int64
+	bytesOut  int64
+	took      time.Duration
+	userAgent string
+	referer   string
+	principal *Principal
+	traceID   string
+	spanID    string
+	fields    []zap.Field
+}
+
+func (a *AccessLogger) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		begin := time.Now()
+		state := &accessLogState{}
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
+		interceptor := &interceptor{ResponseWriter: w, statusCode: http.StatusOK}
+
+		next.ServeHTTP(interceptor, r.WithContext(context.WithValue(r.Context(), accessLogKey, state)))
+
+		took := time.Since(begin)
+		status := interceptor.statusCode
+		if !a.shouldLog(status, took) {
+			return
+		}
+
+		e := accessLogEntry{
+			time:      begin,
+			requestID: GetReqID(r.Context()),
+			remote:    getRealIP(r),
+			method:    r.Method,
+			uri:       a.cfg.Redactor.URI(r.RequestURI),
+			proto:     r.Proto,
+			status:    status,
+			bytesIn:   body.n,
+			bytesOut:  interceptor.bytes,
+			took:      took,
+			userAgent: r.UserAgent(),
+			referer:   a.cfg.Redactor.URI(r.Header.Get(referer)),
+		}
+		if e.bytesIn == 0 && r.ContentLength > 0 {
+			// body not read by the handler
+			e.bytesIn = r.ContentLength
+		}
+		if route := mux.CurrentRoute(r); route != nil {
+			e.route, _ = route.GetPathTemplate()
+		}
+		e.traceID, e.spanID = parseTraceparent(r.Header.Get(traceparent))
+		state.mu.Lock()
+		e.principal, e.fields = state.principal, state.fields
+		state.mu.Unlock()
+
+		a.write(&e)
+	})
+}
+
+func (a *AccessLogger) shouldLog(status int, took time.Duration) bool {
+	if status >= 400 || (a.cfg.SlowThreshold > 0 && took >= a.cfg.SlowThreshold) {
+		return true
+	}
+	return a.cfg.SampleRate >= 1 || a.sample() < a.cfg.SampleRate
+}
+
+func (a *AccessLogger) write(e *accessLogEntry) {
+	switch a.cfg.Format {
+	case AccessLogJSON:
+		fields := []zap.Field{
+			zap.String("requestId", e.requestID),
+			zap.String("remote", e.remote),
+			zap.String("method", e.method),
+			zap.String("uri", e.uri),
+			zap.String("route", e.route),
+			zap.String("proto", e.proto),
+			zap.Int("status", e.status),
+			zap.Int64("bytesIn", e.bytesIn),
+			zap.Int64("bytesOut", e.bytesOut),
+			zap.Float64("tookMs", float64(e.took)/float64(time.Millisecond)),
+			zap.String("userAgent", e.userAgent),
+			zap.String("referer", e.referer),
+		}
+		if e.principal != nil {
+			fields = append(fields, zap.String("principal", e.principal.ID), zap.String("principalType", e.principal.Type))
+		}
+		if e.traceID != "" {
+			fields = append(fields, zap.String("traceId", e.traceID), zap.String("spanId", e.spanID))
+		}
+		a.l.Info("access", append(fields, e.fields...)...)
+	case AccessLogCombined:
+		a.writeLine(combinedLine(e))
+	case AccessLogLogfmt:
+		a.writeLine(logfmtLine(e, a.cfg.Redactor))
+	}
+}
+
+func (a *AccessLogger) writeLine(line string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	io.WriteString(a.out, line)
+}
+
+// combinedLine formats entry in Apache combined log format.
+func combinedLine(e *accessLogEntry) string {
+	user := "-"
+	if e.principal != nil && e.principal.ID != "" {
+		user = strings.Replace(e.principal.ID, " ", "_", -1)
+	}
+	bytesOut := "-"
+	if e.bytesOut > 0 {
+		bytesOut = strconv.FormatInt(e.bytesOut, 10)
+	}
+	return fmt.Sprintf("%s - %s [%s] %s %d %s %s %s\n",
+		e.remote, user, e.time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(e.method+" "+e.uri+" "+e.proto), e.status, bytesOut,
+		strconv.Quote(dashIfEmpty(e.referer)), strconv.Quote(dashIfEmpty(e.userAgent)))
+}
+
+// logfmtLine formats entry as key=value pairs, secrets in the fields added by handlers are masked.
+func logfmtLine(e *accessLogEntry, r *redact.Redactor) string {
+	var b strings.Builder
+	kv := func(k, v string) {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		if v == "" || strings.ContainsAny(v, " =\"\t\n") {
+			v = strconv.Quote(v)
+		}
+		b.WriteString(v)
+	}
+
+	kv("ts", e.time.UTC().Format(time.RFC3339Nano))
+	kv("level", "info")
+	kv("msg", "access")
+	kv("requestId", e.requestID)
+	kv("remote", e.remote)
+	kv("method", e.method)
+	kv("uri", e.uri)
+	kv("route", e.route)
+	kv("proto", e.proto)
+	kv("status", strconv.Itoa(e.status))
+	kv("bytesIn", strconv.FormatInt(e.bytesIn, 10))
+	kv("bytesOut", strconv.FormatInt(e.bytesOut, 10))
+	kv("tookMs", strconv.FormatFloat(float64(e.took)/float64(time.Millisecond), 'f', 3, 64))
+	kv("userAgent", e.userAgent)
+	if e.principal != nil {
+		kv("principal", e.principal.ID)
+		kv("principalType", e.principal.Type)
+	}
+	if e.traceID != "" {
+		kv("traceId", e.traceID)
+		kv("spanId", e.spanID)
+	}
+	for _, f := range e.fields {
+		if r.Field(f.Key) {
+			kv(f.Key, redact.Mask)
+			continue
+		}
+		kv(f.Key, r.String(fieldValue(f)))
+	}
+	b.WriteByte('\n')
+
+	return b.String()
+}
+
+// fieldValue returns strings as they are and other values as JSON, the way the JSON format shows them.
+func fieldValue(f zap.Field) string {
+	enc := zapcore.NewMapObjectEncoder()
+	f.AddTo(enc)
+	v := enc.Fields[f.Key]
+	if s, ok := v.(string); ok {
+		return s
+	}
+	if b, err := json.Marshal(v); err == nil {
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
+
+// parseTraceparent returns trace and parent span IDs of W3C Trace Context header, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+func parseTraceparent(h string) (string, string) {
+	parts := strings.Split(h, "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || len(parts[2]) != 16 || !isHex(parts[1]) || !isHex(parts[2]) {
+		return "", ""
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", ""
+	}
+	return parts[1], parts[2]
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// countingReader counts bytes of the request body read by the handler.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func Test_AccessLogger_ShouldLogRequestDetails(t *testing.T) {
+	// given
+	core, logs := observer.New(zap.InfoLevel)
+	a, err := NewAccessLogger(zap.New(core), AccessLogConfig{Format: AccessLogJSON, SampleRate: 1})
+	require.NoError(t, err)
+
+	r := mux.NewRouter()
+	r.Use(RequestIDMiddleware, a.Handler)
+	r.HandleFunc("/api/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		WithPrincipal(r.Context(), &Principal{ID: "u1", Type: PrincipalJWT})
+		AddAccessLogField(r.Context(), "items", 3)
+		w.WriteHeader(http.StatusCreated)
+		w.Write(append(body, body...))
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/items/42?x=1&access_token=s3cr3t", strings.NewReader("hello"))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	// when
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	// then
+	require.Equal(t, 1, logs.Len())
+	entry := logs.All()[0]
+	assert.Equal(t, "access", entry.Message)
+	assert.Equal(t, zap.InfoLevel, entry.Level)
+	fields := entry.ContextMap()
+	assert.Equal(t, "/api/items/{id}", fields["route"])
+	assert.Equal(t, "/api/items/42?x=1&access_token=[REDACTED]", fields["uri"])
+	assert.Equal(t, int64(201), fields["status"])
+	assert.Equal(t, int64(5), fields["bytesIn"])
+	assert.Equal(t, int64(10), fields["bytesOut"])
+	assert.Equal(t, "u1", fields["principal"])
+	assert.Equal(t, "jwt", fields["principalType"])
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", fields["traceId"])
+	assert.Equal(t, "00f067aa0ba902b7", fields["spanId"])
+	assert.Equal(t, int64(3), fields["items"])
+	assert.NotEmpty(t, fields["requestId"])
+}
+
+func Test_AccessLogger_ShouldSampleOnlySuccessfulFastRequests(t *testing.T) {
+	// given
+	core, logs := observer.New(zap.InfoLevel)
+	a, err := NewAccessLogger(zap.New(core), AccessLogConfig{Format: AccessLogJSON, SampleRate: 0.1, SlowThreshold: 20 * time.Millisecond})
+	require.NoError(t, err)
+	a.sample = func() float64 { return 0.5 }
+
+	tests := []struct {
+		name   string
+		status int
+		delay  time.Duration
+		logged bool
+	}{
+		{name: "sampled out", status: http.StatusOK, logged: false},
+		{name: "client error", status: http.StatusNotFound, logged: true},
+		{name: "server error", status: http.StatusInternalServerError, logged: true},
+		{name: "slow request", status: http.StatusOK, delay: 30 * time.Millisecond, logged: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := logs.Len()
+
+			// when
+			a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tt.delay)
+				w.WriteHeader(tt.status)
+			})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/version", nil))
+
+			// then
+			assert.Equal(t, tt.logged, logs.Len() > before)
+		})
+	}
+}
+
+func Test_AccessLogger_ShouldWriteTextFormats(t *testing.T) {
+	tests := []struct {
+		format   string
+		expected []string
+	}{
+		{format: AccessLogCombined, expected: []string{
+			`192.0.2.1 - u1 [`, `] "GET /api/version?a=b HTTP/1.1" 200 2 "https://example.com/" "curl/7.64"` + "\n",
+		}},
+		{format: AccessLogLogfmt, expected: []string{
+			"level=info msg=access ", " remote=192.0.2.1 method=GET uri=\"/api/version?a=b\" route=\"\" proto=HTTP/1.1 status=200 bytesIn=0 bytesOut=2 ",
+			` userAgent=curl/7.64 principal=u1 principalType=apikey items=3 apiToken=[REDACTED] requestBody="{\"password\":[REDACTED]}"` + "\n",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			// given
+			a, err := NewAccessLogger(zap.NewNop(), AccessLogConfig{Format: tt.format, SampleRate: 1})
+			require.NoError(t, err)
+			out := &bytes.Buffer{}
+			a.out = out
+
+			req := httptest.NewRequest(http.MethodGet, "/api/version?a=b", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			req.Header.Set("User-Agent", "curl/7.64")
+			req.Header.Set("Referer", "https://example.com/")
+
+			// when
+			a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				WithPrincipal(r.Context(), &Principal{ID: "u1", Type: PrincipalAPIKey})
+				AddAccessLogField(r.Context(), "items", 3)
+				AddAccessLogField(r.Context(), "apiToken", "s3cr3t")
+				AddAccessLogField(r.Context(), "requestBody", map[string]string{"password": "s3cr3t"})
+				w.Write([]byte("ok"))
+			})).ServeHTTP(httptest.NewRecorder(), req)
+
+			// then
+			for _, e := range tt.expected {
+				assert.Contains(t, out.String(), e)
+			}
+			assert.NotContains(t, out.String(), "s3cr3t")
+		})
+	}
+}
+
+func Test_parseTraceparent_ShouldRejectInvalidHeaders(t *testing.T) {
+	for _, h := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+	} {
+		traceID, spanID := parseTraceparent(h)
+		assert.Empty(t, traceID, h)
+		assert.Empty(t, spanID, h)
+	}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func Test_AdminAuthenticator_ShouldAuthenticateAdminEndpointsOnly(t *testing.T) {
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		status        int
+		principal     *Principal
+	}{
+		{name: "admin endpoint with token", path: "/admin/audit", authorization: "Bearer s3cr3t", status: http.StatusOK,
+			principal: &Principal{ID: PrincipalAdmin, Type: PrincipalAdmin}},
+		{name: "admin endpoint without token", path: "/admin/audit", status: http.StatusUnauthorized},
+		{name: "admin endpoint with wrong token", path: "/admin/audit", authorization: "Bearer s3cr3", status: http.StatusUnauthorized},
+		{name: "other endpoint", path: "/debug/pprof/", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			a := NewAdminAuthenticator(zap.NewNop(), "/admin/", "s3cr3t", NewAuthMetrics(prometheus.NewRegistry()))
+			var principal *Principal
+			h := a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				principal = PrincipalFrom(r.Context())
+			}))
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+
+			// when
+			h.ServeHTTP(w, r)
+
+			// then
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.principal, principal)
+		})
+	}
+}
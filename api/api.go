@@ -37,27 +37,33 @@ var BuildTime = "unknown"
 var StartTime = time.Now().UTC()
 
 // ApiHandler general handler responsible for providing information about app itself.
-// Available handlers: Versionz, Readyz, Healthz
+// Available handlers: Versionz, Readyz, Healthz, Whoami
 type ApiHandler interface {
 	Versionz(http.ResponseWriter, *http.Request)
 	Readyz(http.ResponseWriter, *http.Request)
 	Healthz(http.ResponseWriter, *http.Request)
+	Whoami(http.ResponseWriter, *http.Request)
 }
 
 type apiHandler struct {
 	l       *zap.SugaredLogger
 	repo    app.Repository
+	drainer *Drainer
 	healthy int32
 }
 
-func NewAPIHandler(ctx context.Context, l *zap.Logger, repo app.Repository) ApiHandler {
-	a := &apiHandler{l: l.Sugar(), repo: repo, healthy: 1}
+func NewAPIHandler(ctx context.Context, l *zap.Logger, repo app.Repository, drainer *Drainer) ApiHandler {
+	a := &apiHandler{l: l.Sugar(), repo: repo, drainer: drainer, healthy: 1}
 	go a.watchSignals(ctx)
 	return a
 }
 
+// watchSignals marks the service as unhealthy when shutdown or draining starts.
 func (a *apiHandler) watchSignals(ctx context.Context) {
-	<-ctx.Done()
+	select {
+	case <-ctx.Done():
+	case <-a.drainer.Draining():
+	}
 	atomic.StoreInt32(&a.healthy, 0)
 }
 
@@ -69,6 +75,7 @@ func (a *apiHandler) watchSignals(ctx context.Context) {
 // @Router /api/version [get]
 // @Failure 500 {object} api.HTTPError
 // @Success 200 {object} api.VersionResp
+// @Success 304 "client's copy matches If-None-Match"
 func (a *apiHandler) Versionz(w http.ResponseWriter, r *http.Request) {
 	resp := VersionResp{
 		AppName:        AppName,
@@ -80,17 +87,19 @@ func (a *apiHandler) Versionz(w http.ResponseWriter, r *http.Request) {
 		LastCommitTime: LastCommitTime,
 	}
 
-	MustWriteJSON(a.l, w, r, resp, http.StatusOK)
+	// version changes only with the build
+	MustWriteJSON(a.l, w, r, resp, http.StatusOK, WithETag())
 }
 
 // Healthz godoc
 // @Summary Application health information
-// @Description returns information whether application is up and running as well as information whether connection to DB is up. Endpoint returns http status 500 when there no connection to DB or 503 when service starts shutdown process
+// @Description returns information whether application is up and running as well as information whether connection to DB is up and the state of DB circuit breaker. Endpoint returns http status 500 when there no connection to DB or 503 when service starts shutdown process
 // @Tags API
 // @Produce json
 // @Router /api/health [get]
 // @Failure 500 {object} api.HealthResp
 // @Failure 503 {object} api.HTTPError
+// @Failure 504 {object} api.HTTPError
 // @Success 200 {object} api.HealthResp
 func (a *apiHandler) Healthz(w http.ResponseWriter, r *http.Request) {
 	if atomic.LoadInt32(&a.healthy) != 1 {
@@ -98,15 +107,15 @@ func (a *apiHandler) Healthz(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
-
 	resp := HealthResp{Uptime: time.Since(StartTime).String()}
-	ok, err := a.repo.OK(ctx)
+	ok, err := a.repo.OK(r.Context())
 	if err != nil {
 		resp.DBError = err.Error()
 	}
 	resp.DBStatus = strconv.FormatBool(ok)
+	if cs, isBreaker := a.repo.(app.CircuitStater); isBreaker {
+		resp.DBCircuit = cs.CircuitState()
+	}
 
 	if ok {
 		MustWriteJSON(a.l, w, r, resp, http.StatusOK)
@@ -146,8 +155,29 @@ type VersionResp struct {
 
 // HealthResp - struct represents response for /health endpoint.
 type HealthResp struct {
-	Msg      string `json:"msg,omitempty"`
-	Uptime   string `json:"uptime,omitempty"`
-	DBStatus string `json:"dbStatus,omitempty"`
-	DBError  string `json:"dbError,omitempty"`
+	Msg       string `json:"msg,omitempty"`
+	Uptime    string `json:"uptime,omitempty"`
+	DBStatus  string `json:"dbStatus,omitempty"`
+	DBError   string `json:"dbError,omitempty"`
+	DBCircuit string `json:"dbCircuit,omitempty"`
+}
+
+// Whoami godoc
+// @Summary Authenticated principal information
+// @Description returns the caller identity with its scopes and roles. Endpoint returns http status 401 for anonymous or invalid credentials
+// @Tags API
+// @Produce json
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /api/v1/whoami [get]
+// @Failure 401 {object} api.HTTPError
+// @Success 200 {object} api.Principal
+func (a *apiHandler) Whoami(w http.ResponseWriter, r *http.Request) {
+	p := PrincipalFrom(r.Context())
+	if p == nil {
+		WriteErrJSON(a.l, w, r, errors.New("authentication required"), http.StatusUnauthorized)
+		return
+	}
+
+	MustWriteJSON(a.l, w, r, p, http.StatusOK)
 }
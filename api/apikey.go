@@ -0,0 +1,395 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mateuszdyminski/go-template/app"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// last-used timestamp is written at most once per interval per key
+	apiKeyTouchInterval = time.Minute
+	// max number of cached keys, unknown prefixes are cached too
+	apiKeyCacheSize = 10000
+)
+
+// GenerateAPIKey returns new key of the owner and its token in "<prefix>.<secret>" form.
+// The token is never stored - it must be handed to the client right away.
+func GenerateAPIKey(owner string, scopes []string, createdAt time.Time, expiresAt *time.Time) (string, app.APIKey, error) {
+	prefix := make([]byte, 6)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(prefix); err != nil {
+		return "", app.APIKey{}, errors.Wrap(err, "can't generate api key")
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", app.APIKey{}, errors.Wrap(err, "can't generate api key")
+	}
+
+	encodedSecret := base64.RawURLEncoding.EncodeToString(secret)
+	key := app.APIKey{
+		Prefix:    hex.EncodeToString(prefix),
+		Hash:      hashAPIKeySecret(encodedSecret),
+		Owner:     owner,
+		Scopes:    scopes,
+		CreatedAt: createdAt,
+		ExpiresAt: expiresAt,
+	}
+
+	return key.Prefix + "." + encodedSecret, key, nil
+}
+
+func hashAPIKeySecret(secret string) []byte {
+	h := sha256.Sum256([]byte(secret))
+	return h[:]
+}
+
+// APIKeyAuthenticator authenticates requests with X-API-Key header. Keys are cached for the TTL,
+// so revocation made by other replicas takes effect after the TTL at the latest. Requests without
+// the header pass anonymously.
+type APIKeyAuthenticator struct {
+	l     *zap.SugaredLogger
+	store app.APIKeyStore
+	ttl   time.Duration
+	m     *AuthMetrics
+	now   func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]*apiKeyEntry
+}
+
+type apiKeyEntry struct {
+	key       *app.APIKey // nil when the key doesn't exist
+	expiresAt time.Time
+	touchedAt time.Time
+}
+
+func NewAPIKeyAuthenticator(l *zap.Logger, store app.APIKeyStore, ttl time.Duration, m *AuthMetrics) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{
+		l:     l.Sugar(),
+		store: store,
+		ttl:   ttl,
+		m:     m,
+		now:   time.Now,
+		cache: make(map[string]*apiKeyEntry),
+	}
+}
+
+func (a *APIKeyAuthenticator) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(xAPIKey)
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := a.authenticate(r.Context(), token)
+		if err != nil {
+			if errors.Cause(err) == errInvalidAPIKey {
+				WriteErrJSON(a.l, w, r, err, http.StatusUnauthorized)
+			} else {
+				WriteErrJSON(a.l, w, r, errors.Wrap(err, "can't verify api key"), http.StatusServiceUnavailable)
+			}
+			return
+		}
+
+		ctx := WithPrincipal(r.Context(), &Principal{
+			ID:     key.Owner,
+			Type:   PrincipalAPIKey,
+			Scopes: key.Scopes,
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+var errInvalidAPIKey = errors.New("invalid api key")
+
+func (a *APIKeyAuthenticator) authenticate(ctx context.Context, token string) (*app.APIKey, error) {
+	reject := func(reason string) (*app.APIKey, error) {
+		a.m.Failures.WithLabelValues(PrincipalAPIKey, reason).Inc()
+		return nil, errInvalidAPIKey
+	}
+
+	i := strings.IndexByte(token, '.')
+	if i == -1 {
+		return reject("malformed")
+	}
+	prefix, secret := token[:i], token[i+1:]
+
+	entry, err := a.lookup(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	key := entry.key
+	switch {
+	case key == nil:
+		return reject("unknown")
+	case subtle.ConstantTimeCompare(hashAPIKeySecret(secret), key.Hash) != 1:
+		return reject("invalid_secret")
+	case key.RevokedAt != nil:
+		return reject("revoked")
+	case !key.Active(a.now()):
+		return reject("expired")
+	}
+
+	a.touch(entry)
+
+	return key, nil
+}
+
+// lookup returns cached entry of the prefix, loading it from the store when missing or stale.
+func (a *APIKeyAuthenticator) lookup(ctx context.Context, prefix string) (*apiKeyEntry, error) {
+	now := a.now()
+
+	a.mu.Lock()
+	entry, ok := a.cache[prefix]
+	a.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry, nil
+	}
+
+	key, err := a.store.Get(ctx, prefix)
+	if err != nil && err != app.ErrAPIKeyNotFound {
+		return nil, err
+	}
+
+	entry = &apiKeyEntry{key: key, expiresAt: now.Add(a.ttl)}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.cache) >= apiKeyCacheSize {
+		a.evictExpired(now)
+	}
+	if len(a.cache) < apiKeyCacheSize {
+		a.cache[prefix] = entry
+	}
+
+	return entry, nil
+}
+
+// evictExpired removes stale entries, must be called with the lock held.
+func (a *APIKeyAuthenticator) evictExpired(now time.Time) {
+	for prefix, e := range a.cache {
+		if !now.Before(e.expiresAt) {
+			delete(a.cache, prefix)
+		}
+	}
+}
+
+// touch records the key usage in the background, throttled to one write per interval.
+func (a *APIKeyAuthenticator) touch(entry *apiKeyEntry) {
+	now := a.now()
+
+	a.mu.Lock()
+	if now.Sub(entry.touchedAt) < apiKeyTouchInterval {
+		a.mu.Unlock()
+		return
+	}
+	entry.touchedAt = now
+	a.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := a.store.Touch(ctx, entry.key.Prefix, now); err != nil {
+			a.l.Warnw("can't record api key usage", "prefix", entry.key.Prefix, "err", err)
+		}
+	}()
+}
+
+// Invalidate drops the cached key, so changes made by this replica take effect immediately.
+func (a *APIKeyAuthenticator) Invalidate(prefix string) {
+	a.mu.Lock()
+	delete(a.cache, prefix)
+	a.mu.Unlock()
+}
+
+// APIKeyHandler - admin endpoints managing API keys.
+type APIKeyHandler struct {
+	l     *zap.SugaredLogger
+	store app.APIKeyStore
+	auth  *APIKeyAuthenticator
+	now   func() time.Time
+}
+
+func NewAPIKeyHandler(l *zap.Logger, store app.APIKeyStore, auth *APIKeyAuthenticator) *APIKeyHandler {
+	return &APIKeyHandler{l: l.Sugar(), store: store, auth: auth, now: time.Now}
+}
+
+// CreateAPIKeyReq - request creating API key.
+type CreateAPIKeyReq struct {
+	Owner      string   `json:"owner" validate:"required,max=200"`
+	Scopes     []string `json:"scopes"`
+	TTLSeconds int64    `json:"ttlSeconds" validate:"min=0,max=315360000"` // 0 - key never expires, at most 10 years
+}
+
+// RotateAPIKeyReq - request rotating API key.
+type RotateAPIKeyReq struct {
+	GraceSeconds int64 `json:"graceSeconds" validate:"min=0,max=2592000"` // time the old key stays valid, at most 30 days
+}
+
+// APIKeyResp - created API key with its token, the token is returned only once.
+type APIKeyResp struct {
+	Key string `json:"key"`
+	app.APIKey
+}
+
+// Create godoc
+// @Summary Create API key
+// @Description creates API key of the owner, the key is returned only in this response. Available on admin port.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param key body api.CreateAPIKeyReq true "API key"
+// @Router /admin/apikeys [post]
+// @Failure 400 {object} api.HTTPError
+// @Failure 422 {object} api.HTTPError
+// @Success 201 {object} api.APIKeyResp
+func (h *APIKeyHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyReq
+	if err := ReadJSON(w, r, &req); err != nil {
+		WriteErrJSON(h.l, w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	now := h.now().UTC()
+	var expiresAt *time.Time
+	if req.TTLSeconds > 0 {
+		t := now.Add(time.Duration(req.TTLSeconds) * time.Second)
+		expiresAt = &t
+	}
+
+	token, key, err := GenerateAPIKey(req.Owner, req.Scopes, now, expiresAt)
+	if err != nil {
+		WriteErrJSON(h.l, w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if err := h.store.Create(r.Context(), key); err != nil {
+		WriteErrJSON(h.l, w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	LoggerFrom(r.Context()).Sugar().Infow("api key created", "prefix", key.Prefix, "owner", key.Owner)
+	AuditSummary(r.Context(), map[string]interface{}{"prefix": key.Prefix, "owner": key.Owner, "scopes": key.Scopes, "expiresAt": key.ExpiresAt})
+	MustWriteJSON(h.l, w, r, APIKeyResp{Key: token, APIKey: key}, http.StatusCreated)
+}
+
+// List godoc
+// @Summary List API keys
+// @Description returns API keys without their secrets, optionally filtered by owner. Available on admin port.
+// @Tags Admin
+// @Produce json
+// @Param owner query string false "owner of the keys"
+// @Router /admin/apikeys [get]
+// @Failure 500 {object} api.HTTPError
+// @Success 200 {array} app.APIKey
+func (h *APIKeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.store.List(r.Context(), r.URL.Query().Get("owner"))
+	if err != nil {
+		WriteErrJSON(h.l, w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	MustWriteJSON(h.l, w, r, keys, http.StatusOK)
+}
+
+// Rotate godoc
+// @Summary Rotate API key
+// @Description creates replacement of the key with the same owner, scopes and lifetime. The old key stays valid for the grace period. Available on admin port.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param prefix path string true "key prefix"
+// @Param rotation body api.RotateAPIKeyReq false "rotation options"
+// @Router /admin/apikeys/{prefix}/rotate [post]
+// @Failure 404 {object} api.HTTPError
+// @Failure 409 {object} api.HTTPError
+// @Failure 422 {object} api.HTTPError
+// @Success 201 {object} api.APIKeyResp
+func (h *APIKeyHandler) Rotate(w http.ResponseWriter, r *http.Request) {
+	prefix := mux.Vars(r)["prefix"]
+
+	var req RotateAPIKeyReq
+	if r.ContentLength != 0 {
+		if err := ReadJSON(w, r, &req); err != nil {
+			WriteErrJSON(h.l, w, r, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	old, err := h.store.Get(r.Context(), prefix)
+	if err != nil {
+		h.writeStoreErr(w, r, err)
+		return
+	}
+
+	now := h.now().UTC()
+	if !old.Active(now) {
+		WriteErrJSON(h.l, w, r, errors.Errorf("api key %s is revoked or expired", prefix), http.StatusConflict)
+		return
+	}
+
+	var expiresAt *time.Time
+	if old.ExpiresAt != nil {
+		t := now.Add(old.ExpiresAt.Sub(old.CreatedAt))
+		expiresAt = &t
+	}
+
+	token, key, err := GenerateAPIKey(old.Owner, old.Scopes, now, expiresAt)
+	if err != nil {
+		WriteErrJSON(h.l, w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if err := h.store.Rotate(r.Context(), prefix, key, now.Add(time.Duration(req.GraceSeconds)*time.Second)); err != nil {
+		h.writeStoreErr(w, r, err)
+		return
+	}
+	h.auth.Invalidate(prefix)
+
+	LoggerFrom(r.Context()).Sugar().Infow("api key rotated", "prefix", prefix, "replacement", key.Prefix, "owner", key.Owner)
+	AuditSummary(r.Context(), map[string]interface{}{"prefix": prefix, "replacement": key.Prefix, "graceSeconds": req.GraceSeconds})
+	MustWriteJSON(h.l, w, r, APIKeyResp{Key: token, APIKey: key}, http.StatusCreated)
+}
+
+// Revoke godoc
+// @Summary Revoke API key
+// @Description revokes the key, other replicas stop accepting it after their cache TTL. Available on admin port.
+// @Tags Admin
+// @Param prefix path string true "key prefix"
+// @Router /admin/apikeys/{prefix} [delete]
+// @Failure 404 {object} api.HTTPError
+// @Success 204
+func (h *APIKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	prefix := mux.Vars(r)["prefix"]
+
+	if err := h.store.Revoke(r.Context(), prefix); err != nil {
+		h.writeStoreErr(w, r, err)
+		return
+	}
+	h.auth.Invalidate(prefix)
+
+	LoggerFrom(r.Context()).Sugar().Infow("api key revoked", "prefix", prefix)
+	AuditSummary(r.Context(), map[string]interface{}{"prefix": prefix})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *APIKeyHandler) writeStoreErr(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Cause(err) == app.ErrAPIKeyNotFound {
+		WriteErrJSON(h.l, w, r, err, http.StatusNotFound)
+		return
+	}
+	WriteErrJSON(h.l, w, r, err, http.StatusInternalServerError)
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mateuszdyminski/go-template/app"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type memAPIKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]app.APIKey
+	gets int
+}
+
+func (s *memAPIKeyStore) Create(ctx context.Context, key app.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key.Prefix] = key
+	return nil
+}
+
+func (s *memAPIKeyStore) Get(ctx context.Context, prefix string) (*app.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gets++
+	key, ok := s.keys[prefix]
+	if !ok {
+		return nil, app.ErrAPIKeyNotFound
+	}
+	return &key, nil
+}
+
+func (s *memAPIKeyStore) List(ctx context.Context, owner string) ([]app.APIKey, error) {
+	return nil, nil
+}
+
+func (s *memAPIKeyStore) Rotate(ctx context.Context, prefix string, replacement app.APIKey, oldExpiresAt time.Time) error {
+	return nil
+}
+
+func (s *memAPIKeyStore) Revoke(ctx context.Context, prefix string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := s.keys[prefix]
+	now := time.Now()
+	key.RevokedAt = &now
+	s.keys[prefix] = key
+	return nil
+}
+
+func (s *memAPIKeyStore) Touch(ctx context.Context, prefix string, t time.Time) error { return nil }
+
+func Test_APIKeyAuthenticator_ShouldAuthenticateActiveKeys(t *testing.T) {
+	// given
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	store := &memAPIKeyStore{keys: map[string]app.APIKey{}}
+	token := func(expiresAt *time.Time) string {
+		token, key, err := GenerateAPIKey("billing", []string{"invoices:read"}, now, expiresAt)
+		require.NoError(t, err)
+		require.NoError(t, store.Create(context.Background(), key))
+		return token
+	}
+	valid, expired, revoked := token(nil), token(&past), token(nil)
+	require.NoError(t, store.Revoke(context.Background(), revoked[:12]))
+
+	a := NewAPIKeyAuthenticator(zap.NewNop(), store, time.Minute, NewAuthMetrics(prometheus.NewRegistry()))
+	h := newProtectedRouter(t, a.Handler, "invoices:read")
+
+	tests := map[string]struct {
+		key    string
+		status int
+	}{
+		"valid key":      {key: valid, status: http.StatusOK},
+		"wrong secret":   {key: valid[:13] + "wrong", status: http.StatusUnauthorized},
+		"expired key":    {key: expired, status: http.StatusUnauthorized},
+		"revoked key":    {key: revoked, status: http.StatusUnauthorized},
+		"unknown prefix": {key: "000000000000.secret", status: http.StatusUnauthorized},
+		"malformed key":  {key: "secret", status: http.StatusUnauthorized},
+		"anonymous":      {status: http.StatusUnauthorized},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// when
+			req := httptest.NewRequest(http.MethodGet, "/api/whoami", nil)
+			req.Header.Set("X-API-Key", tt.key)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			// then
+			assert.Equal(t, tt.status, rec.Code)
+			if tt.status == http.StatusOK {
+				assert.Equal(t, "billing", rec.Body.String())
+			}
+		})
+	}
+}
+
+func Test_APIKeyAuthenticator_ShouldCacheKeysUntilInvalidated(t *testing.T) {
+	// given
+	store := &memAPIKeyStore{keys: map[string]app.APIKey{}}
+	token, key, err := GenerateAPIKey("billing", nil, time.Now(), nil)
+	require.NoError(t, err)
+	require.NoError(t, store.Create(context.Background(), key))
+	a := NewAPIKeyAuthenticator(zap.NewNop(), store, time.Minute, NewAuthMetrics(prometheus.NewRegistry()))
+
+	// when
+	_, first := a.authenticate(context.Background(), token)
+	_, second := a.authenticate(context.Background(), token)
+	require.NoError(t, store.Revoke(context.Background(), key.Prefix))
+	_, cached := a.authenticate(context.Background(), token)
+	a.Invalidate(key.Prefix)
+	_, invalidated := a.authenticate(context.Background(), token)
+
+	// then
+	assert.NoError(t, first)
+	assert.NoError(t, second)
+	assert.NoError(t, cached, "revocation should take effect after cache TTL")
+	assert.Equal(t, errInvalidAPIKey, invalidated)
+	assert.Equal(t, 2, store.gets)
+}
+
+func Test_APIKeyHandler_ShouldRejectDurationsOutOfRange(t *testing.T) {
+	h := NewAPIKeyHandler(zap.NewNop(), &memAPIKeyStore{keys: make(map[string]app.APIKey)}, nil)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+	}{
+		{name: "key TTL", handler: h.Create, body: `{"owner":"ci","ttlSeconds":9223372036}`},
+		{name: "rotation grace period", handler: h.Rotate, body: `{"graceSeconds":9223372036}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			r := httptest.NewRequest(http.MethodPost, "/admin/apikeys", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			// when
+			tt.handler(w, r)
+
+			// then
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		})
+	}
+}
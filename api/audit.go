@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/mateuszdyminski/go-template/app"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// AuditRecorder receives audit events, e.g. audit.Writer.
+type AuditRecorder interface {
+	Record(e app.AuditEvent)
+}
+
+// auditState - summary of the change set by the handler.
+type auditState struct {
+	summary json.RawMessage
+}
+
+// AuditSummary attaches summary of the change, e.g. changed fields, to the audit event of the request.
+func AuditSummary(ctx context.Context, summary interface{}) {
+	s, ok := ctx.Value(auditKey).(*auditState)
+	if !ok {
+		return
+	}
+	if b, err := json.Marshal(summary); err == nil {
+		s.summary = b
+	}
+}
+
+// Auditor records audit events of mutating requests - all methods except GET, HEAD and OPTIONS.
+// Register it after the authentication middlewares, so events carry the principal, and before
+// authorization, so denied requests are recorded too.
+type Auditor struct {
+	l        *zap.SugaredLogger
+	recorder AuditRecorder
+	now      func() time.Time
+}
+
+// NewAuditor returns Auditor recording events with the recorder. Routes which don't change anything
+// despite their method are excluded with WithoutAudit.
+func NewAuditor(l *zap.Logger, recorder AuditRecorder) *Auditor {
+	return &Auditor{l: l.Sugar(), recorder: recorder, now: time.Now}
+}
+
+func (a *Auditor) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions || RouteConfigFrom(r.Context()).SkipAudit {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		begin := a.now()
+		state := &auditState{}
+		interceptor := &interceptor{ResponseWriter: w, statusCode: http.StatusOK}
+
+		next.ServeHTTP(interceptor, r.WithContext(context.WithValue(r.Context(), auditKey, state)))
+
+		e := app.AuditEvent{
+			Time:      begin,
+			Action:    r.Method + " " + r.URL.Path,
+			Target:    r.URL.Path,
+			RequestID: GetReqID(r.Context()),
+			ClientIP:  getRealIP(r),
+			Status:    interceptor.statusCode,
+			Outcome:   auditOutcome(interceptor.statusCode),
+			Summary:   state.summary,
+		}
+		if route != nil {
+			if tpl, err := route.GetPathTemplate(); err == nil {
+				e.Route = tpl
+				e.Action = r.Method + " " + tpl
+			}
+		}
+		if p := PrincipalFrom(r.Context()); p != nil {
+			e.PrincipalID, e.PrincipalType = p.ID, p.Type
+		}
+
+		a.recorder.Record(e)
+	})
+}
+
+func auditOutcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return app.AuditDenied
+	case status >= 400:
+		return app.AuditFailure
+	default:
+		return app.AuditSuccess
+	}
+}
+
+// AuditHandler serves audit events on the admin listener.
+type AuditHandler struct {
+	l         *zap.SugaredLogger
+	store     app.AuditStore
+	paginator *Paginator
+}
+
+// NewAuditHandler returns handler listing events of the store, cursors are signed with the secret.
+func NewAuditHandler(l *zap.Logger, store app.AuditStore, secret []byte) *AuditHandler {
+	return &AuditHandler{
+		l:     l.Sugar(),
+		store: store,
+		paginator: NewPaginator(PaginationConfig{
+			DefaultLimit: 50,
+			MaxLimit:     500,
+			Sortable:     []string{"id", "time"},
+			DefaultSort:  []app.Sort{{Field: "id", Desc: true}},
+			Key:          "id",
+			Filterable: map[string][]string{
+				"principalId": {app.FilterEq},
+				"action":      {app.FilterEq},
+				"route":       {app.FilterEq},
+				"target":      {app.FilterEq},
+				"outcome":     {app.FilterEq, app.FilterIn},
+				"requestId":   {app.FilterEq},
+				"time":        {app.FilterGte, app.FilterLt},
+			},
+			Secret: secret,
+		}),
+	}
+}
+
+// List godoc
+// @Summary List audit events
+// @Description returns audit events of mutating requests matching the filters, newest first by default. Available on admin port.
+// @Tags Admin
+// @Produce json
+// @Param principalId query string false "principal ID"
+// @Param action query string false "method and route, e.g. DELETE /admin/apikeys/{prefix}"
+// @Param route query string false "route template"
+// @Param target query string false "resource path"
+// @Param outcome query string false "success, denied or failure, outcome[in] takes a comma separated list"
+// @Param requestId query string false "request ID"
+// @Param time[gte] query string false "RFC 3339 time, inclusive"
+// @Param time[lt] query string false "RFC 3339 time, exclusive"
+// @Param sort query string false "id or time, minus sorts descending, -id by default"
+// @Param cursor query string false "cursor of the next or prev link"
+// @Param limit query int false "page size, 50 by default, 500 max"
+// @Router /admin/audit [get]
+// @Failure 422 {object} api.HTTPError
+// @Success 200 {object} api.Page
+func (h *AuditHandler) List(w http.ResponseWriter, r *http.Request) {
+	req, err := h.paginator.Parse(r)
+	if err == nil {
+		err = validateAuditFilters(req.Filters)
+	}
+	if err != nil {
+		WriteErrJSON(h.l, w, r, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	events, err := h.store.List(r.Context(), req)
+	if err != nil {
+		WriteErrJSON(h.l, w, r, err, http.StatusServiceUnavailable)
+		return
+	}
+
+	page, err := h.paginator.Page(r, req, events)
+	if err != nil {
+		WriteErrJSON(h.l, w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	MustWriteJSON(h.l, w, r, page, http.StatusOK)
+}
+
+// validateAuditFilters checks values of the filters the Paginator doesn't know the type of.
+func validateAuditFilters(filters []app.Filter) error {
+	var fields []FieldError
+	for _, f := range filters {
+		name := f.Field
+		if f.Op != app.FilterEq {
+			name += "[" + f.Op + "]"
+		}
+
+		for _, v := range f.Values {
+			switch f.Field {
+			case "time":
+				if _, err := time.Parse(time.RFC3339, v); err != nil {
+					fields = append(fields, FieldError{Field: name, Reason: "format", Msg: "must be RFC 3339 time"})
+				}
+			case "outcome":
+				switch v {
+				case app.AuditSuccess, app.AuditDenied, app.AuditFailure:
+				default:
+					fields = append(fields, FieldError{Field: name, Reason: "oneof", Msg: "must be one of: success, denied, failure"})
+				}
+			}
+		}
+	}
+
+	if len(fields) > 0 {
+		return &RequestError{Status: http.StatusUnprocessableEntity, Msg: "invalid query parameters", Fields: fields}
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mateuszdyminski/go-template/app"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeAuditRecorder struct {
+	events []app.AuditEvent
+}
+
+func (f *fakeAuditRecorder) Record(e app.AuditEvent) {
+	f.events = append(f.events, e)
+}
+
+type fakeAuditStore struct {
+	events []app.AuditEvent
+	req    app.PageRequest
+}
+
+func (f *fakeAuditStore) Append(ctx context.Context, events []app.AuditEvent) error {
+	f.events = append(f.events, events...)
+	return nil
+}
+
+func (f *fakeAuditStore) List(ctx context.Context, req app.PageRequest) ([]app.AuditEvent, error) {
+	f.req = req
+	if len(f.events) > req.Limit+1 {
+		return f.events[:req.Limit+1], nil
+	}
+	return f.events, nil
+}
+
+func newAuditRouter(a *Auditor) *mux.Router {
+	routes := NewRoutes()
+	r := mux.NewRouter()
+	r.Use(routes.Handler)
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if id := r.Header.Get("X-Test-Principal"); id != "" {
+				r = r.WithContext(WithPrincipal(r.Context(), &Principal{ID: id, Type: PrincipalAPIKey}))
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.Use(a.Handler)
+	r.HandleFunc("/api/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete && PrincipalFrom(r.Context()) == nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		AuditSummary(r.Context(), map[string]string{"name": "new"})
+		w.WriteHeader(http.StatusNoContent)
+	})
+	routes.Route(r.HandleFunc("/api/search", func(w http.ResponseWriter, r *http.Request) {}).Methods(http.MethodPost), WithoutAudit())
+	return r
+}
+
+func Test_Auditor_ShouldRecordMutatingRequests(t *testing.T) {
+	// given
+	recorder := &fakeAuditRecorder{}
+	router := newAuditRouter(NewAuditor(zap.NewNop(), recorder))
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		principal string
+		recorded  bool
+		outcome   string
+	}{
+		{name: "GET is not recorded", method: http.MethodGet, path: "/api/items/1", recorded: false},
+		{name: "skipped route", method: http.MethodPost, path: "/api/search", recorded: false},
+		{name: "successful change", method: http.MethodPut, path: "/api/items/1", principal: "billing", recorded: true, outcome: app.AuditSuccess},
+		{name: "denied change", method: http.MethodDelete, path: "/api/items/1", recorded: true, outcome: app.AuditDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder.events = nil
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.principal != "" {
+				req.Header.Set("X-Test-Principal", tt.principal)
+			}
+
+			// when
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			// then
+			if !tt.recorded {
+				assert.Empty(t, recorder.events)
+				return
+			}
+			require.Len(t, recorder.events, 1)
+			e := recorder.events[0]
+			assert.Equal(t, tt.method+" /api/items/{id}", e.Action)
+			assert.Equal(t, "/api/items/{id}", e.Route)
+			assert.Equal(t, tt.path, e.Target)
+			assert.Equal(t, tt.principal, e.PrincipalID)
+			assert.Equal(t, tt.outcome, e.Outcome)
+		})
+	}
+}
+
+func Test_Auditor_ShouldAttachSummary(t *testing.T) {
+	// given
+	recorder := &fakeAuditRecorder{}
+	router := newAuditRouter(NewAuditor(zap.NewNop(), recorder))
+	req := httptest.NewRequest(http.MethodPatch, "/api/items/1", nil)
+	req.Header.Set("X-Test-Principal", "billing")
+
+	// when
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	// then
+	require.Len(t, recorder.events, 1)
+	assert.Equal(t, http.StatusNoContent, recorder.events[0].Status)
+	assert.JSONEq(t, `{"name":"new"}`, string(recorder.events[0].Summary))
+	assert.Equal(t, PrincipalAPIKey, recorder.events[0].PrincipalType)
+}
+
+func Test_AuditHandler_List_ShouldValidateFilter(t *testing.T) {
+	// given
+	h := NewAuditHandler(zap.NewNop(), &fakeAuditStore{}, []byte("secret"))
+
+	tests := []struct {
+		name  string
+		query string
+		field string
+	}{
+		{name: "invalid time", query: "time[gte]=yesterday", field: "time[gte]"},
+		{name: "invalid cursor", query: "cursor=abc", field: "cursor"},
+		{name: "limit too big", query: "limit=501", field: "limit"},
+		{name: "unknown outcome", query: "outcome[in]=denied,maybe", field: "outcome[in]"},
+		{name: "unknown filter", query: "principal=billing", field: "principal"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			// when
+			h.List(w, httptest.NewRequest(http.MethodGet, "/admin/audit?"+tt.query, nil))
+
+			// then
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+			var resp HTTPError
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			require.Len(t, resp.Errors, 1)
+			assert.Equal(t, tt.field, resp.Errors[0].Field)
+		})
+	}
+}
+
+func Test_AuditHandler_List_ShouldPaginate(t *testing.T) {
+	// given
+	store := &fakeAuditStore{events: []app.AuditEvent{{ID: 5}, {ID: 4}, {ID: 3}}}
+	h := NewAuditHandler(zap.NewNop(), store, []byte("secret"))
+
+	// when
+	w := httptest.NewRecorder()
+	h.List(w, httptest.NewRequest(http.MethodGet, "/admin/audit?principalId=billing&outcome=denied&limit=2", nil))
+
+	// then
+	assert.Equal(t, http.StatusOK, w.Code)
+	var page struct {
+		Items []app.AuditEvent `json:"items"`
+		Next  string           `json:"next"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Len(t, page.Items, 2)
+	assert.Equal(t, []app.Filter{
+		{Field: "outcome", Op: app.FilterEq, Values: []string{app.AuditDenied}},
+		{Field: "principalId", Op: app.FilterEq, Values: []string{"billing"}},
+	}, store.req.Filters)
+	require.NotEmpty(t, page.Next)
+
+	// when
+	w = httptest.NewRecorder()
+	h.List(w, httptest.NewRequest(http.MethodGet, page.Next, nil))
+
+	// then
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.NotNil(t, store.req.Cursor)
+	assert.Equal(t, []string{"4"}, store.req.Cursor.Values)
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mateuszdyminski/go-template/auth"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var (
+	authorization   = http.CanonicalHeaderKey("Authorization")
+	wwwAuthenticate = http.CanonicalHeaderKey("WWW-Authenticate")
+)
+
+// Types of principals by the authentication method.
+const (
+	PrincipalJWT    = "jwt"
+	PrincipalAPIKey = "apikey"
+	PrincipalAdmin  = "admin"
+)
+
+// Principal - authenticated caller of the request.
+type Principal struct {
+	ID     string   `json:"id"`
+	Type   string   `json:"type"`
+	Scopes []string `json:"scopes"`
+	Roles  []string `json:"roles"`
+}
+
+// HasScope returns true when the principal was granted the scope.
+func (p *Principal) HasScope(scope string) bool {
+	return contains(p.Scopes, scope)
+}
+
+// PrincipalFrom returns principal of the authenticated request or nil for anonymous one.
+func PrincipalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey).(*Principal)
+	return p
+}
+
+// ClaimsFrom returns claims of the request authenticated with JWT.
+func ClaimsFrom(ctx context.Context) *auth.Claims {
+	c, _ := ctx.Value(claimsKey).(*auth.Claims)
+	return c
+}
+
+// WithPrincipal returns context of the request authenticated as p.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	setAccessLogPrincipal(ctx, p)
+	ctx = withLogFields(ctx, zap.String("principal", p.ID), zap.String("principalType", p.Type))
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// AuthMetrics - metrics shared by the authentication middlewares.
+type AuthMetrics struct {
+	Failures *prometheus.CounterVec
+}
+
+func NewAuthMetrics(reg prometheus.Registerer) *AuthMetrics {
+	failures := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "http",
+		Name:      "auth_failures_total",
+		Help:      "The total number of HTTP requests with invalid credentials.",
+	}, []string{"method", "reason"})
+
+	reg.MustRegister(failures)
+
+	return &AuthMetrics{Failures: failures}
+}
+
+// JWTAuthenticator authenticates requests with bearer JWTs. Requests without credentials pass
+// anonymously - routes which need authenticated callers declare permissions enforced by Authorizer.
+type JWTAuthenticator struct {
+	l        *zap.SugaredLogger
+	verifier *auth.Verifier
+	m        *AuthMetrics
+}
+
+func NewJWTAuthenticator(l *zap.Logger, verifier *auth.Verifier, m *AuthMetrics) *JWTAuthenticator {
+	return &JWTAuthenticator{l: l.Sugar(), verifier: verifier, m: m}
+}
+
+func (a *JWTAuthenticator) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, token := splitAuthorization(r.Header.Get(authorization))
+		if !strings.EqualFold(scheme, "Bearer") {
+			// anonymous or authenticated with other scheme
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims, err := a.verifier.Verify(r.Context(), token)
+		if err != nil {
+			a.m.Failures.WithLabelValues(PrincipalJWT, "invalid_token").Inc()
+			w.Header().Set(wwwAuthenticate, fmt.Sprintf(`Bearer error="invalid_token", error_description=%q`, err.Error()))
+			WriteErrJSON(a.l, w, r, errors.Wrap(err, "invalid bearer token"), http.StatusUnauthorized)
+			return
+		}
+
+		ctx := WithPrincipal(r.Context(), &Principal{
+			ID:     claims.Subject,
+			Type:   PrincipalJWT,
+			Scopes: claims.Scopes,
+			Roles:  claims.Roles,
+		})
+		ctx = context.WithValue(ctx, claimsKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func splitAuthorization(h string) (scheme, credentials string) {
+	h = strings.TrimSpace(h)
+	if i := strings.IndexByte(h, ' '); i != -1 {
+		return h[:i], strings.TrimSpace(h[i+1:])
+	}
+	return h, ""
+}
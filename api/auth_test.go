@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mateuszdyminski/go-template/auth"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type staticKey struct{ key crypto.PublicKey }
+
+func (s staticKey) Key(ctx context.Context, kid string) (crypto.PublicKey, error) { return s.key, nil }
+
+func newTestAuthenticator(t *testing.T) (*JWTAuthenticator, func(scope string) string) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	a := NewJWTAuthenticator(zap.NewNop(), auth.NewVerifier(staticKey{pub}, "", "", 0), NewAuthMetrics(prometheus.NewRegistry()))
+	token := func(scope string) string {
+		b64 := base64.RawURLEncoding.EncodeToString
+		header, _ := json.Marshal(map[string]string{"alg": "EdDSA"})
+		payload, _ := json.Marshal(map[string]interface{}{"sub": "user-1", "scope": scope, "exp": time.Now().Add(time.Minute).Unix()})
+		input := b64(header) + "." + b64(payload)
+		return input + "." + b64(ed25519.Sign(priv, []byte(input)))
+	}
+
+	return a, token
+}
+
+// newProtectedRouter returns router authenticating requests with the middleware and serving /api/whoami,
+// which requires the permission, with ID of the principal.
+func newProtectedRouter(t *testing.T, authenticate mux.MiddlewareFunc, permission string) *mux.Router {
+	routes := NewRoutes()
+	r := mux.NewRouter()
+	r.Use(routes.Handler, authenticate, newTestAuthorizer(t, "").Handler)
+	routes.Route(r.HandleFunc("/api/whoami", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(PrincipalFrom(r.Context()).ID))
+	}), WithPermissions(permission))
+	return r
+}
+
+func Test_JWTAuthenticator_ShouldEnforceRequiredScopes(t *testing.T) {
+	// given
+	a, token := newTestAuthenticator(t)
+	h := newProtectedRouter(t, a.Handler, "write")
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+		challenge     string
+	}{
+		{name: "anonymous", status: http.StatusUnauthorized, challenge: "Bearer"},
+		{name: "invalid token", authorization: "Bearer invalid", status: http.StatusUnauthorized, challenge: `Bearer error="invalid_token"`},
+		{name: "missing scope", authorization: "Bearer " + token("read"), status: http.StatusForbidden, challenge: `Bearer error="insufficient_scope"`},
+		{name: "granted scope", authorization: "bearer " + token("read write"), status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// when
+			req := httptest.NewRequest(http.MethodGet, "/api/whoami", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			// then
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Contains(t, rec.Header().Get("WWW-Authenticate"), tt.challenge)
+			if tt.status == http.StatusOK {
+				assert.Equal(t, "user-1", rec.Body.String())
+			} else {
+				assert.Contains(t, rec.Body.String(), `"httpStatusCode":`)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mateuszdyminski/go-template/app"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// roleLoadTimeout bounds loading of role permissions from the store.
+const roleLoadTimeout = 10 * time.Second
+
+// StaticRoles - role permissions defined in configuration. It implements app.RoleStore interface.
+type StaticRoles map[string][]string
+
+// RolePermissions returns the configured permissions.
+func (s StaticRoles) RolePermissions(ctx context.Context) (map[string][]string, error) {
+	return s, nil
+}
+
+// ParseRoles parses role permissions in "role=perm1,perm2;role2=perm3" format.
+func ParseRoles(s string) (StaticRoles, error) {
+	roles := make(StaticRoles)
+	for _, def := range strings.Split(s, ";") {
+		def = strings.TrimSpace(def)
+		if def == "" {
+			continue
+		}
+		i := strings.IndexByte(def, '=')
+		if i <= 0 {
+			return nil, errors.Errorf("invalid role definition %q, expected role=perm1,perm2", def)
+		}
+		role := strings.TrimSpace(def[:i])
+		for _, p := range strings.Split(def[i+1:], ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				roles[role] = append(roles[role], p)
+			}
+		}
+	}
+	return roles, nil
+}
+
+// Decision - outcome of the authorization with the reasoning behind it.
+type Decision struct {
+	Allowed   bool              `json:"allowed"`
+	Reason    string            `json:"reason"`
+	Route     string            `json:"route,omitempty"`
+	Principal *Principal        `json:"principal,omitempty"`
+	Required  []string          `json:"required"`
+	Granted   map[string]string `json:"granted,omitempty"` // required permission -> role or scope granting it
+	Missing   []string          `json:"missing,omitempty"`
+}
+
+// Authorizer enforces permissions declared on routes. Principal is granted permissions of its roles
+// and its scopes. Permission "*" grants everything and "resource:*" grants all actions on the resource.
+type Authorizer struct {
+	l       *zap.SugaredLogger
+	store   app.RoleStore
+	refresh time.Duration // 0 - roles are loaded once
+
+	mu       sync.Mutex
+	roles    map[string][]string
+	loadedAt time.Time
+	lastErr  error         // error of the last load
+	loading  chan struct{} // closed when the load in progress finishes, nil when there is none
+
+	decisions *prometheus.CounterVec
+}
+
+func NewAuthorizer(l *zap.Logger, reg prometheus.Registerer, store app.RoleStore, refresh time.Duration) *Authorizer {
+	decisions := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "http",
+		Name:      "authz_decisions_total",
+		Help:      "The total number of authorization decisions made for protected routes.",
+	}, []string{"result"})
+
+	reg.MustRegister(decisions)
+
+	return &Authorizer{
+		l:         l.Sugar(),
+		store:     store,
+		refresh:   refresh,
+		decisions: decisions,
+	}
+}
+
+func (a *Authorizer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := RouteConfigFrom(r.Context())
+		if !rc.Protected {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		d, err := a.Explain(r.Context(), PrincipalFrom(r.Context()), mux.CurrentRoute(r), rc)
+		if err != nil {
+			WriteErrJSON(a.l, w, r, err, http.StatusServiceUnavailable)
+			return
+		}
+		a.log(r, d)
+
+		switch {
+		case d.Allowed:
+			next.ServeHTTP(w, r)
+		case d.Principal == nil:
+			w.Header().Set(wwwAuthenticate, "Bearer")
+			WriteErrJSON(a.l, w, r, errors.New(d.Reason), http.StatusUnauthorized)
+		default:
+			w.Header().Set(wwwAuthenticate, fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, strings.Join(d.Missing, " ")))
+			WriteErrJSON(a.l, w, r, errors.New(d.Reason), http.StatusForbidden)
+		}
+	})
+}
+
+func (a *Authorizer) log(r *http.Request, d *Decision) {
+	result := "denied"
+	if d.Allowed {
+		result = "allowed"
+	}
+	a.decisions.WithLabelValues(result).Inc()
+
+	// route and principal come with the request logger
+	l := LoggerFrom(r.Context()).Sugar()
+	fields := []interface{}{"result", result, "reason", d.Reason}
+
+	// denials are worth attention, allowed requests are logged only in debug mode
+	if d.Allowed {
+		l.Debugw("authorization decision", fields...)
+	} else {
+		l.Infow("authorization decision", append(fields, "missing", d.Missing)...)
+	}
+}
+
+// Explain returns decision whether the principal may call the route with the options, nil principal
+// is anonymous.
+func (a *Authorizer) Explain(ctx context.Context, p *Principal, route *mux.Route, rc RouteConfig) (*Decision, error) {
+	d := &Decision{Principal: p, Required: []string{}}
+	if route != nil {
+		d.Route, _ = route.GetPathTemplate()
+	}
+
+	if !rc.Protected {
+		d.Allowed = true
+		d.Reason = "route is not protected"
+		return d, nil
+	}
+	required := rc.Permissions
+	d.Required = required
+
+	if p == nil {
+		d.Missing = required
+		d.Reason = "authentication required"
+		return d, nil
+	}
+
+	roles, err := a.rolePermissions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	d.Granted = make(map[string]string)
+	for _, perm := range required {
+		if source, ok := grantedBy(p, roles, perm); ok {
+			d.Granted[perm] = source
+		} else {
+			d.Missing = append(d.Missing, perm)
+		}
+	}
+
+	d.Allowed = len(d.Missing) == 0
+	if d.Allowed {
+		d.Reason = "all required permissions granted"
+	} else {
+		d.Reason = fmt.Sprintf("missing permissions: %s", strings.Join(d.Missing, ", "))
+	}
+
+	return d, nil
+}
+
+// grantedBy returns role or scope of the principal granting the permission.
+func grantedBy(p *Principal, roles map[string][]string, perm string) (string, bool) {
+	for _, s := range p.Scopes {
+		if permissionMatches(s, perm) {
+			return "scope:" + s, true
+		}
+	}
+
+	names := append([]string{}, p.Roles...)
+	sort.Strings(names)
+	for _, role := range names {
+		for _, granted := range roles[role] {
+			if permissionMatches(granted, perm) {
+				return "role:" + role, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+func permissionMatches(granted, required string) bool {
+	if granted == "*" || granted == required {
+		return true
+	}
+	return strings.HasSuffix(granted, ":*") && strings.HasPrefix(required, strings.TrimSuffix(granted, "*"))
+}
+
+// rolePermissions returns cached role permissions. They are reloaded in the background, one load
+// at a time, and stale permissions are used meanwhile or when the reload fails. Only the first load
+// is waited for.
+func (a *Authorizer) rolePermissions(ctx context.Context) (map[string][]string, error) {
+	a.mu.Lock()
+	if a.roles == nil || (a.refresh > 0 && time.Since(a.loadedAt) >= a.refresh) {
+		a.startLoad()
+	}
+	roles, loading := a.roles, a.loading
+	a.mu.Unlock()
+
+	if roles != nil {
+		return roles, nil
+	}
+
+	select {
+	case <-loading:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.roles == nil {
+		return nil, errors.Wrap(a.lastErr, "can't load role permissions")
+	}
+	return a.roles, nil
+}
+
+// startLoad loads role permissions in the background unless they're being loaded already.
+// It must be called with the lock held.
+func (a *Authorizer) startLoad() {
+	if a.loading != nil {
+		return
+	}
+
+	done := make(chan struct{})
+	a.loading = done
+	go func() {
+		defer close(done)
+		// the load outlives the request which started it
+		ctx, cancel := context.WithTimeout(context.Background(), roleLoadTimeout)
+		defer cancel()
+		roles, err := a.store.RolePermissions(ctx)
+
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.loading = nil
+		a.lastErr = err
+		if err != nil {
+			if a.roles != nil {
+				a.l.Warnw("can't reload role permissions, using stale ones", "err", err)
+				a.loadedAt = time.Now()
+			}
+			return
+		}
+		a.roles = roles
+		a.loadedAt = time.Now()
+	}()
+}
+
+// ExplainReq - request explaining authorization decision.
+type ExplainReq struct {
+	Method    string     `json:"method" validate:"required"`
+	Path      string     `json:"path" validate:"required"`
+	Principal *Principal `json:"principal"` // nil - anonymous
+}
+
+// ExplainHandler godoc
+// @Summary Explain authorization decision
+// @Description shows whether the principal is allowed to call the method and path of the API router and why. Available on admin port.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param request body api.ExplainReq true "principal and route"
+// @Router /admin/authz/explain [post]
+// @Failure 404 {object} api.HTTPError
+// @Failure 422 {object} api.HTTPError
+// @Success 200 {object} api.Decision
+func (a *Authorizer) ExplainHandler(router *mux.Router, routes *Routes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ExplainReq
+		if err := ReadJSON(w, r, &req); err != nil {
+			WriteErrJSON(a.l, w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		u, err := url.Parse(req.Path)
+		if err != nil {
+			WriteErrJSON(a.l, w, r, &RequestError{Status: http.StatusUnprocessableEntity, Msg: "invalid path"}, http.StatusUnprocessableEntity)
+			return
+		}
+
+		var match mux.RouteMatch
+		target := &http.Request{Method: strings.ToUpper(req.Method), URL: u, Header: make(http.Header)}
+		if !router.Match(target, &match) || match.Route == nil {
+			WriteErrJSON(a.l, w, r, errors.Errorf("no route matches %s %s", req.Method, req.Path), http.StatusNotFound)
+			return
+		}
+
+		d, err := a.Explain(r.Context(), req.Principal, match.Route, routes.Config(match.Route))
+		if err != nil {
+			WriteErrJSON(a.l, w, r, err, http.StatusServiceUnavailable)
+			return
+		}
+
+		MustWriteJSON(a.l, w, r, d, http.StatusOK)
+	}
+}
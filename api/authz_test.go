@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestAuthorizer(t *testing.T, roles string) *Authorizer {
+	parsed, err := ParseRoles(roles)
+	require.NoError(t, err)
+
+	return NewAuthorizer(zap.NewNop(), prometheus.NewRegistry(), parsed, 0)
+}
+
+// blockingRoleStore returns the roles, loads wait for release when it's set.
+type blockingRoleStore struct {
+	roles   StaticRoles
+	release chan struct{}
+	loads   int32
+}
+
+func (s *blockingRoleStore) RolePermissions(ctx context.Context) (map[string][]string, error) {
+	atomic.AddInt32(&s.loads, 1)
+	if s.release != nil {
+		<-s.release
+	}
+	return s.roles, nil
+}
+
+func Test_ParseRoles_ShouldParseRolePermissions(t *testing.T) {
+	// when
+	roles, err := ParseRoles("admin=*; reader = invoices:read, users:read ;")
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, StaticRoles{"admin": {"*"}, "reader": {"invoices:read", "users:read"}}, roles)
+	_, err = ParseRoles("admin")
+	assert.Error(t, err)
+}
+
+func Test_Authorizer_ShouldEnforceRoutePermissions(t *testing.T) {
+	// given
+	a := newTestAuthorizer(t, "admin=*;billing=invoices:*;reader=invoices:read")
+	routes := NewRoutes()
+	r := mux.NewRouter()
+	r.Use(routes.Handler)
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if role := req.Header.Get("Role"); role != "" {
+				req = req.WithContext(WithPrincipal(req.Context(), &Principal{ID: "u1", Roles: []string{role}, Scopes: []string{"reports:read"}}))
+			}
+			next.ServeHTTP(w, req)
+		})
+	})
+	r.Use(a.Handler)
+	routes.Route(r.HandleFunc("/invoices", func(w http.ResponseWriter, r *http.Request) {}).Methods(http.MethodPost), WithPermissions("invoices:write"))
+	routes.Route(r.HandleFunc("/reports", func(w http.ResponseWriter, r *http.Request) {}), WithPermissions("reports:read"))
+	r.HandleFunc("/public", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name, method, path, role string
+		status                   int
+	}{
+		{name: "anonymous", method: http.MethodPost, path: "/invoices", status: http.StatusUnauthorized},
+		{name: "missing permission", method: http.MethodPost, path: "/invoices", role: "reader", status: http.StatusForbidden},
+		{name: "resource wildcard", method: http.MethodPost, path: "/invoices", role: "billing", status: http.StatusOK},
+		{name: "global wildcard", method: http.MethodPost, path: "/invoices", role: "admin", status: http.StatusOK},
+		{name: "granted by scope", method: http.MethodGet, path: "/reports", role: "reader", status: http.StatusOK},
+		{name: "unprotected route", method: http.MethodGet, path: "/public", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// when
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Role", tt.role)
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			// then
+			assert.Equal(t, tt.status, rec.Code)
+		})
+	}
+}
+
+func Test_Authorizer_ExplainHandler_ShouldExplainDecision(t *testing.T) {
+	// given
+	a := newTestAuthorizer(t, "reader=invoices:read")
+	routes := NewRoutes()
+	router := mux.NewRouter()
+	routes.Route(router.HandleFunc("/invoices/{id}", func(w http.ResponseWriter, r *http.Request) {}).Methods(http.MethodGet),
+		WithPermissions("invoices:read", "invoices:audit"))
+	body := `{"method":"GET","path":"/invoices/42","principal":{"id":"u1","type":"jwt","roles":["reader"]}}`
+
+	// when
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/admin/authz/explain", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	a.ExplainHandler(router, routes)(rec, req)
+
+	// then
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"allowed":false`)
+	assert.Contains(t, rec.Body.String(), `"route":"/invoices/{id}"`)
+	assert.Contains(t, rec.Body.String(), `"granted":{"invoices:read":"role:reader"}`)
+	assert.Contains(t, rec.Body.String(), `"missing":["invoices:audit"]`)
+}
+
+func Test_Authorizer_ShouldUseStaleRolesWhileReloading(t *testing.T) {
+	// given
+	store := &blockingRoleStore{roles: StaticRoles{"admin": {"*"}}}
+	a := NewAuthorizer(zap.NewNop(), prometheus.NewRegistry(), store, time.Nanosecond)
+	_, err := a.rolePermissions(context.Background())
+	require.NoError(t, err)
+	store.release = make(chan struct{})
+	defer close(store.release)
+
+	// when the reload is blocked
+	first, firstErr := a.rolePermissions(context.Background())
+	second, secondErr := a.rolePermissions(context.Background())
+
+	// then
+	assert.NoError(t, firstErr)
+	assert.NoError(t, secondErr)
+	assert.Equal(t, map[string][]string(store.roles), first)
+	assert.Equal(t, map[string][]string(store.roles), second)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&store.loads) == 2 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&store.loads), "only one reload should run at a time")
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CachePolicy - Cache-Control directives of the response.
+type CachePolicy struct {
+	Public         bool
+	Private        bool
+	NoCache        bool // caches must revalidate before every reuse
+	NoStore        bool
+	MustRevalidate bool
+	Immutable      bool
+	MaxAge         time.Duration
+	// SharedMaxAge - max age in shared caches, e.g. CDN
+	SharedMaxAge         time.Duration
+	StaleWhileRevalidate time.Duration
+}
+
+// NoStore - response must not be cached, e.g. it contains personal data.
+func NoStore() CachePolicy {
+	return CachePolicy{NoStore: true}
+}
+
+// Revalidate - response can be cached, but must be revalidated with ETag or Last-Modified before reuse.
+func Revalidate() CachePolicy {
+	return CachePolicy{Private: true, NoCache: true}
+}
+
+// PublicCache - response can be cached by browsers and shared caches for maxAge.
+func PublicCache(maxAge time.Duration) CachePolicy {
+	return CachePolicy{Public: true, MaxAge: maxAge}
+}
+
+// PrivateCache - response can be cached only by the client for maxAge.
+func PrivateCache(maxAge time.Duration) CachePolicy {
+	return CachePolicy{Private: true, MaxAge: maxAge}
+}
+
+// String returns value of Cache-Control header.
+func (p CachePolicy) String() string {
+	var d []string
+	add := func(set bool, directive string) {
+		if set {
+			d = append(d, directive)
+		}
+	}
+	seconds := func(v time.Duration) string {
+		return strconv.Itoa(int(v.Seconds()))
+	}
+
+	add(p.Public, "public")
+	add(p.Private, "private")
+	add(p.NoCache, "no-cache")
+	add(p.NoStore, "no-store")
+	add(p.MaxAge > 0, "max-age="+seconds(p.MaxAge))
+	add(p.SharedMaxAge > 0, "s-maxage="+seconds(p.SharedMaxAge))
+	add(p.StaleWhileRevalidate > 0, "stale-while-revalidate="+seconds(p.StaleWhileRevalidate))
+	add(p.MustRevalidate, "must-revalidate")
+	add(p.Immutable, "immutable")
+
+	return strings.Join(d, ", ")
+}
+
+// CacheControl sets Cache-Control policy declared by the route with WithCachePolicy or the default one
+// on 2xx and 304 responses, other responses, e.g. 429 or 503, get no-store. Handlers can override it
+// with WithCacheControl or by setting the header.
+type CacheControl struct {
+	def string
+}
+
+func NewCacheControl(def CachePolicy) *CacheControl {
+	return &CacheControl{def: def.String()}
+}
+
+func (c *CacheControl) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := c.def
+		if p := RouteConfigFrom(r.Context()).CachePolicy; p != "" {
+			v = p
+		}
+
+		cw := &cacheWriter{ResponseWriter: w, policy: v}
+		next.ServeHTTP(cw, r)
+		// nothing was written, 200 OK is sent after the handler returns
+		cw.apply(http.StatusOK)
+	})
+}
+
+// cacheWriter sets Cache-Control header when the status is known, unless the handler set it.
+type cacheWriter struct {
+	http.ResponseWriter
+	policy  string
+	applied bool
+}
+
+func (c *cacheWriter) apply(code int) {
+	if c.applied {
+		return
+	}
+	c.applied = true
+
+	h := c.ResponseWriter.Header()
+	if h.Get(cacheControl) != "" {
+		return
+	}
+	switch {
+	case code >= 200 && code < 300 || code == http.StatusNotModified:
+		if c.policy != "" {
+			h.Set(cacheControl, c.policy)
+		}
+	default:
+		h.Set(cacheControl, NoStore().String())
+	}
+}
+
+func (c *cacheWriter) WriteHeader(code int) {
+	// informational responses are followed by the final one
+	if code >= 200 {
+		c.apply(code)
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *cacheWriter) Write(b []byte) (int, error) {
+	c.apply(http.StatusOK)
+	return c.ResponseWriter.Write(b)
+}
+
+func (c *cacheWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := c.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("cacheWriter: can't cast parent ResponseWriter to Hijacker")
+	}
+	return hj.Hijack()
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func Test_CachePolicy_String(t *testing.T) {
+	tests := []struct {
+		policy CachePolicy
+		want   string
+	}{
+		{policy: CachePolicy{}, want: ""},
+		{policy: NoStore(), want: "no-store"},
+		{policy: Revalidate(), want: "private, no-cache"},
+		{policy: PrivateCache(time.Minute), want: "private, max-age=60"},
+		{policy: CachePolicy{Public: true, MaxAge: time.Hour, SharedMaxAge: 2 * time.Hour, StaleWhileRevalidate: 30 * time.Second, Immutable: true},
+			want: "public, max-age=3600, s-maxage=7200, stale-while-revalidate=30, immutable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			// when
+			got := tt.policy.String()
+
+			// then
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_CacheControl_ShouldApplyRoutePolicy(t *testing.T) {
+	// given
+	c := NewCacheControl(NoStore())
+	routes := NewRoutes()
+	r := mux.NewRouter()
+	r.Use(routes.Handler)
+	r.Use(c.Handler)
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	routes.Route(r.HandleFunc("/api/version", ok), WithCachePolicy(PublicCache(time.Minute)))
+	r.HandleFunc("/api/users", ok)
+	routes.Route(r.HandleFunc("/api/items", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}), WithCachePolicy(PublicCache(time.Minute)))
+	routes.Route(r.HandleFunc("/api/limited", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}), WithCachePolicy(PublicCache(time.Minute)))
+	routes.Route(r.HandleFunc("/api/unavailable", func(w http.ResponseWriter, r *http.Request) {
+		WriteErrJSON(zap.NewNop().Sugar(), w, r, errors.New("db is down"), http.StatusServiceUnavailable)
+	}), WithCachePolicy(PublicCache(time.Minute)))
+	routes.Route(r.HandleFunc("/api/custom", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "private, max-age=5")
+		w.Write([]byte("ok"))
+	}), WithCachePolicy(PublicCache(time.Minute)))
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/api/version", want: "public, max-age=60"},
+		{path: "/api/users", want: "no-store"},
+		{path: "/api/items", want: "public, max-age=60"},
+		{path: "/api/limited", want: "no-store"},
+		{path: "/api/unavailable", want: "no-store"},
+		{path: "/api/custom", want: "private, max-age=5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			// when
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			// then
+			assert.Equal(t, tt.want, w.Header().Get("Cache-Control"))
+		})
+	}
+}
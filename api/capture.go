@@ -0,0 +1,327 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mateuszdyminski/go-template/redact"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var xDebugCapture = http.CanonicalHeaderKey("X-Debug-Capture")
+
+// captureSignatureTTL - how long a signed debug header is accepted.
+const captureSignatureTTL = 5 * time.Minute
+
+// CaptureConfig - body capture settings.
+type CaptureConfig struct {
+	// MaxBytes - request and response bodies are truncated to this size
+	MaxBytes int
+	// BufferSize - number of the last exchanges kept for the admin endpoint
+	BufferSize int
+	// Secret - HMAC key of the debug header enabling capture per request, empty disables the header
+	Secret []byte
+	// Routes - path templates of the routes always captured, e.g. /api/users/{id}
+	Routes []string
+	// AccessLog - attach the captured bodies to the access log entry of the request
+	AccessLog bool
+}
+
+// CapturedExchange - request and response captured by BodyCapture, secrets are redacted.
+type CapturedExchange struct {
+	Time              time.Time   `json:"time"`
+	RequestID         string      `json:"requestId"`
+	Method            string      `json:"method"`
+	URI               string      `json:"uri"`
+	Route             string      `json:"route"`
+	Status            int         `json:"status"`
+	TookMs            float64     `json:"tookMs"`
+	RequestHeaders    http.Header `json:"requestHeaders"`
+	RequestBody       string      `json:"requestBody"`
+	RequestTruncated  bool        `json:"requestTruncated"`
+	ResponseHeaders   http.Header `json:"responseHeaders"`
+	ResponseBody      string      `json:"responseBody"`
+	ResponseTruncated bool        `json:"responseTruncated"`
+}
+
+// BodyCapture records request and response bodies of the opted-in routes and of requests carrying
+// a valid signed X-Debug-Capture header, see SignCaptureHeader. The last exchanges are kept in memory
+// for the admin endpoint, bodies can be attached to the access log. Register it after the access log
+// middleware.
+type BodyCapture struct {
+	l        *zap.SugaredLogger
+	cfg      CaptureConfig
+	redactor *redact.Redactor
+	now      func() time.Time
+
+	templates map[string]bool
+
+	mu       sync.Mutex
+	ring     []*CapturedExchange
+	next     int
+	captured *prometheus.CounterVec
+}
+
+func NewBodyCapture(l *zap.Logger, reg prometheus.Registerer, cfg CaptureConfig, redactor *redact.Redactor) *BodyCapture {
+	captured := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "http",
+		Name:      "body_captures_total",
+		Help:      "The total number of captured request and response bodies.",
+	}, []string{"trigger"})
+	reg.MustRegister(captured)
+
+	c := &BodyCapture{
+		l:         l.Sugar(),
+		cfg:       cfg,
+		redactor:  redactor,
+		now:       time.Now,
+		templates: make(map[string]bool),
+		ring:      make([]*CapturedExchange, cfg.BufferSize),
+		captured:  captured,
+	}
+	for _, tpl := range cfg.Routes {
+		c.templates[tpl] = true
+	}
+
+	return c
+}
+
+func (c *BodyCapture) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trigger := c.trigger(r)
+		if trigger == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		begin := c.now()
+		reqBody := &captureBuffer{max: c.cfg.MaxBytes}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = &teeReadCloser{Reader: io.TeeReader(r.Body, reqBody), Closer: r.Body}
+		}
+		cw := &captureWriter{ResponseWriter: w, statusCode: http.StatusOK, body: &captureBuffer{max: c.cfg.MaxBytes}}
+
+		next.ServeHTTP(cw, r)
+
+		e := &CapturedExchange{
+			Time:              begin,
+			RequestID:         GetReqID(r.Context()),
+			Method:            r.Method,
+			URI:               c.redactor.URI(r.RequestURI),
+			Status:            cw.statusCode,
+			TookMs:            float64(c.now().Sub(begin)) / float64(time.Millisecond),
+			RequestHeaders:    c.headers(r.Header),
+			RequestBody:       c.body(r.Header, reqBody),
+			RequestTruncated:  reqBody.truncated,
+			ResponseHeaders:   c.headers(w.Header()),
+			ResponseBody:      c.body(w.Header(), cw.body),
+			ResponseTruncated: cw.body.truncated,
+		}
+		if route := mux.CurrentRoute(r); route != nil {
+			e.Route, _ = route.GetPathTemplate()
+		}
+
+		c.captured.WithLabelValues(trigger).Inc()
+		c.store(e)
+		if c.cfg.AccessLog {
+			AddAccessLogField(r.Context(), "requestBody", e.RequestBody)
+			AddAccessLogField(r.Context(), "responseBody", e.ResponseBody)
+		}
+	})
+}
+
+// trigger returns why the request is captured, empty when it isn't.
+func (c *BodyCapture) trigger(r *http.Request) string {
+	if h := r.Header.Get(xDebugCapture); h != "" {
+		if err := c.verify(h); err != nil {
+			LoggerFrom(r.Context()).Sugar().Infow("debug capture header rejected", "err", err)
+		} else {
+			return "header"
+		}
+	}
+
+	if RouteConfigFrom(r.Context()).Capture {
+		return "route"
+	}
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil && c.templates[tpl] {
+			return "route"
+		}
+	}
+	return ""
+}
+
+// verify checks the debug header "<unix timestamp>.<hex HMAC-SHA256 of the timestamp>".
+func (c *BodyCapture) verify(h string) error {
+	if len(c.cfg.Secret) == 0 {
+		return errors.New("debug header disabled")
+	}
+
+	i := strings.IndexByte(h, '.')
+	if i == -1 {
+		return errors.New("malformed header")
+	}
+	ts, err := strconv.ParseInt(h[:i], 10, 64)
+	if err != nil {
+		return errors.New("malformed timestamp")
+	}
+	sig, err := hex.DecodeString(h[i+1:])
+	if err != nil || !hmac.Equal(sig, captureSignature(c.cfg.Secret, h[:i])) {
+		return errors.New("invalid signature")
+	}
+
+	if age := c.now().Sub(time.Unix(ts, 0)); age > captureSignatureTTL || age < -captureSignatureTTL {
+		return errors.Errorf("signature older than %s", captureSignatureTTL)
+	}
+	return nil
+}
+
+// SignCaptureHeader returns X-Debug-Capture header value enabling body capture, valid for 5 minutes from t.
+func SignCaptureHeader(secret []byte, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return ts + "." + hex.EncodeToString(captureSignature(secret, ts))
+}
+
+func captureSignature(secret []byte, ts string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	return mac.Sum(nil)
+}
+
+func (c *BodyCapture) headers(h http.Header) http.Header {
+	out := c.redactor.Headers(h)
+	if _, ok := out[xDebugCapture]; ok {
+		out[xDebugCapture] = []string{redact.Mask}
+	}
+	return out
+}
+
+// body returns redacted body, binary content is replaced with its description.
+func (c *BodyCapture) body(h http.Header, b *captureBuffer) string {
+	if b.total == 0 {
+		return ""
+	}
+	ct := h.Get("Content-Type")
+	if ct == "" {
+		ct = http.DetectContentType(b.buf.Bytes())
+	}
+	if !textual(ct) {
+		return fmt.Sprintf("[%s, %d bytes]", ct, b.total)
+	}
+	return c.redactor.String(b.buf.String())
+}
+
+func textual(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mt, "text/") || mt == "application/x-www-form-urlencoded" ||
+		mt == "application/json" || strings.HasSuffix(mt, "+json") ||
+		mt == "application/xml" || strings.HasSuffix(mt, "+xml")
+}
+
+func (c *BodyCapture) store(e *CapturedExchange) {
+	if len(c.ring) == 0 {
+		return
+	}
+	c.mu.Lock()
+	c.ring[c.next] = e
+	c.next = (c.next + 1) % len(c.ring)
+	c.mu.Unlock()
+}
+
+// Exchanges returns captured exchanges, the newest first.
+func (c *BodyCapture) Exchanges() []*CapturedExchange {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list := make([]*CapturedExchange, 0, len(c.ring))
+	for i := 1; i <= len(c.ring); i++ {
+		if e := c.ring[(c.next-i+len(c.ring))%len(c.ring)]; e != nil {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// List godoc
+// @Summary List captured exchanges
+// @Description returns the last captured requests and responses, the newest first. Available on admin port.
+// @Tags Admin
+// @Produce json
+// @Router /admin/captures [get]
+// @Success 200 {array} api.CapturedExchange
+func (c *BodyCapture) List(w http.ResponseWriter, r *http.Request) {
+	MustWriteJSON(c.l, w, r, c.Exchanges(), http.StatusOK)
+}
+
+// captureBuffer keeps the first max bytes written to it and counts all of them.
+type captureBuffer struct {
+	max       int
+	buf       bytes.Buffer
+	total     int64
+	truncated bool
+}
+
+func (b *captureBuffer) Write(p []byte) (int, error) {
+	b.total += int64(len(p))
+	if room := b.max - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type captureWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	body        *captureBuffer
+}
+
+func (w *captureWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.statusCode = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.body.Write(p[:n])
+	return n, err
+}
+
+func (w *captureWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("captureWriter: can't cast parent ResponseWriter to Hijacker")
+	}
+	return hj.Hijack()
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mateuszdyminski/go-template/redact"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var captureSecret = []byte("capture-secret")
+
+func newTestBodyCapture(cfg CaptureConfig) *BodyCapture {
+	return NewBodyCapture(zap.NewNop(), prometheus.NewRegistry(), cfg, redact.New(redact.Config{}))
+}
+
+func newCaptureRouter(c *BodyCapture) *mux.Router {
+	routes := NewRoutes()
+	r := mux.NewRouter()
+	r.Use(routes.Handler)
+	r.Use(c.Handler)
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "sid=abc")
+		w.Write(body)
+	}
+	routes.Route(r.HandleFunc("/api/users", echo), WithCapture())
+	r.HandleFunc("/api/orders/{id}", echo)
+	r.HandleFunc("/api/other", echo)
+	return r
+}
+
+func Test_BodyCapture_ShouldCaptureOptedInRequests(t *testing.T) {
+	// given
+	c := newTestBodyCapture(CaptureConfig{MaxBytes: 1024, BufferSize: 10, Secret: captureSecret, Routes: []string{"/api/orders/{id}"}})
+	router := newCaptureRouter(c)
+
+	tests := []struct {
+		name     string
+		path     string
+		header   string
+		captured bool
+	}{
+		{name: "route opted in with Route", path: "/api/users", captured: true},
+		{name: "route opted in with config", path: "/api/orders/1", captured: true},
+		{name: "other route", path: "/api/other", captured: false},
+		{name: "signed header", path: "/api/other", header: SignCaptureHeader(captureSecret, time.Now()), captured: true},
+		{name: "expired header", path: "/api/other", header: SignCaptureHeader(captureSecret, time.Now().Add(-6*time.Minute)), captured: false},
+		{name: "header signed with other secret", path: "/api/other", header: SignCaptureHeader([]byte("other"), time.Now()), captured: false},
+		{name: "malformed header", path: "/api/other", header: strconv.FormatInt(time.Now().Unix(), 10), captured: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(c.Exchanges())
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(`{"name":"bob"}`))
+			if tt.header != "" {
+				req.Header.Set("X-Debug-Capture", tt.header)
+			}
+
+			// when
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			// then
+			assert.Equal(t, `{"name":"bob"}`, w.Body.String())
+			assert.Equal(t, tt.captured, len(c.Exchanges()) > before)
+		})
+	}
+}
+
+func Test_BodyCapture_ShouldRedactAndTruncateBodies(t *testing.T) {
+	// given
+	c := newTestBodyCapture(CaptureConfig{MaxBytes: 40, BufferSize: 10, Secret: captureSecret})
+	router := newCaptureRouter(c)
+	body := `{"user":"bob","password":"s3cr3t","comment":"` + strings.Repeat("x", 100) + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/users?token=s3cr3t", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	req.Header.Set("X-Debug-Capture", SignCaptureHeader(captureSecret, time.Now()))
+
+	// when
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// then
+	assert.Equal(t, body, w.Body.String(), "response must not be truncated")
+	exchanges := c.Exchanges()
+	require.Len(t, exchanges, 1)
+	e := exchanges[0]
+	assert.Equal(t, "/api/users", e.Route)
+	assert.Equal(t, http.StatusOK, e.Status)
+	assert.Equal(t, "/api/users?token=[REDACTED]", e.URI)
+	assert.Equal(t, `{"user":"bob","password":[REDACTED],"comme`, e.RequestBody)
+	assert.True(t, e.RequestTruncated)
+	assert.Equal(t, e.RequestBody, e.ResponseBody)
+	assert.True(t, e.ResponseTruncated)
+	assert.Equal(t, redact.Mask, e.RequestHeaders.Get("Authorization"))
+	assert.Equal(t, redact.Mask, e.RequestHeaders.Get("X-Debug-Capture"))
+	assert.Equal(t, redact.Mask, e.ResponseHeaders.Get("Set-Cookie"))
+
+	out, err := json.Marshal(exchanges)
+	require.NoError(t, err)
+	assert.NotContains(t, string(out), "s3cr3t")
+}
+
+func Test_BodyCapture_ShouldKeepLastExchangesNewestFirst(t *testing.T) {
+	// given
+	c := newTestBodyCapture(CaptureConfig{MaxBytes: 10, BufferSize: 3})
+	router := newCaptureRouter(c)
+
+	// when
+	for i := 0; i < 5; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(strconv.Itoa(i))))
+	}
+
+	// then
+	var bodies []string
+	for _, e := range c.Exchanges() {
+		bodies = append(bodies, e.RequestBody)
+	}
+	assert.Equal(t, []string{"4", "3", "2"}, bodies)
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var forwarded = http.CanonicalHeaderKey("Forwarded")
+
+// TrustedProxies - networks of the proxies allowed to report client address.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses CIDRs or single IP addresses of the trusted proxies.
+func ParseTrustedProxies(cidrs []string) (TrustedProxies, error) {
+	var nets TrustedProxies
+	for _, c := range cidrs {
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, errors.Errorf("invalid trusted proxy address %q", c)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid trusted proxy CIDR %q", c)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Contains returns true when ip belongs to a trusted proxy.
+func (t TrustedProxies) Contains(ip net.IP) bool {
+	for _, n := range t {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIPMiddleware resolves address of the client and stores it in the request context, see ClientIP.
+// Forwarding headers are taken into account only when sent by trusted proxies.
+type ClientIPMiddleware struct {
+	trusted TrustedProxies
+}
+
+func NewClientIPMiddleware(trusted TrustedProxies) *ClientIPMiddleware {
+	return &ClientIPMiddleware{trusted: trusted}
+}
+
+func (m *ClientIPMiddleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPKey, m.Resolve(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Resolve returns client address. Hops of the Forwarded (RFC 7239) or X-Forwarded-For header are walked
+// right to left starting from the connection peer, the first address which isn't a trusted proxy is
+// the client. X-Real-IP is used when a trusted peer sends no forwarding header.
+func (m *ClientIPMiddleware) Resolve(r *http.Request) string {
+	peer := net.ParseIP(remoteHost(r.RemoteAddr))
+	if peer == nil {
+		return remoteHost(r.RemoteAddr)
+	}
+	if !m.trusted.Contains(peer) {
+		return peer.String()
+	}
+
+	var hops []string
+	if fwd := r.Header[forwarded]; len(fwd) > 0 {
+		hops = parseForwardedFor(fwd)
+	} else if xff := r.Header[xForwardedFor]; len(xff) > 0 {
+		for _, h := range xff {
+			hops = append(hops, strings.Split(h, ",")...)
+		}
+	} else if xrip := net.ParseIP(strings.TrimSpace(r.Header.Get(xRealIP))); xrip != nil {
+		return xrip.String()
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseHop(hops[i])
+		if ip == nil {
+			// obfuscated or malformed hop - the last trusted proxy is the best we know
+			break
+		}
+		client = ip
+		if !m.trusted.Contains(ip) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+// ClientIP returns client address resolved by ClientIPMiddleware.
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey).(string)
+	return ip
+}
+
+// parseForwardedFor returns "for" parameters of the Forwarded header elements in order.
+func parseForwardedFor(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				pair = strings.TrimSpace(pair)
+				if i := strings.IndexByte(pair, '='); i != -1 && strings.EqualFold(pair[:i], "for") {
+					hop = pair[i+1:]
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// parseHop parses address of a hop: IPv4 or IPv6 with optional port and quotes, e.g. "[2001:db8::1]:4711".
+func parseHop(hop string) net.IP {
+	hop = strings.Trim(strings.TrimSpace(hop), `"`)
+	if ip := net.ParseIP(hop); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+}
+
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ClientIPMiddleware_ShouldTrustOnlyConfiguredProxies(t *testing.T) {
+	// given
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "2001:db8:ffff::/48", "192.168.1.1"})
+	require.NoError(t, err)
+	m := NewClientIPMiddleware(trusted)
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string][]string
+		ip      string
+	}{
+		{name: "untrusted peer spoofing XFF", remote: "203.0.113.7:1234", headers: map[string][]string{"X-Forwarded-For": {"1.2.3.4"}}, ip: "203.0.113.7"},
+		{name: "trusted peer without headers", remote: "10.0.0.1:1234", ip: "10.0.0.1"},
+		{name: "XFF walked right to left", remote: "10.0.0.1:1234", headers: map[string][]string{"X-Forwarded-For": {"1.2.3.4, 198.51.100.9,10.1.1.1"}}, ip: "198.51.100.9"},
+		{name: "multiple XFF headers", remote: "10.0.0.1:1234", headers: map[string][]string{"X-Forwarded-For": {"1.2.3.4", "198.51.100.9, 10.1.1.1"}}, ip: "198.51.100.9"},
+		{name: "all hops trusted", remote: "10.0.0.1:1234", headers: map[string][]string{"X-Forwarded-For": {"10.2.2.2, 192.168.1.1"}}, ip: "10.2.2.2"},
+		{name: "malformed hop", remote: "10.0.0.1:1234", headers: map[string][]string{"X-Forwarded-For": {"1.2.3.4, garbage, 10.1.1.1"}}, ip: "10.1.1.1"},
+		{name: "Forwarded preferred over XFF", remote: "10.0.0.1:1234", headers: map[string][]string{
+			"Forwarded":       {`for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8:cafe::17]:4711"`},
+			"X-Forwarded-For": {"1.2.3.4"},
+		}, ip: "2001:db8:cafe::17"},
+		{name: "Forwarded IPv4 with port", remote: "10.0.0.1:1234", headers: map[string][]string{"Forwarded": {`for="198.51.100.17:8080"`}}, ip: "198.51.100.17"},
+		{name: "obfuscated Forwarded", remote: "10.0.0.1:1234", headers: map[string][]string{"Forwarded": {"for=_hidden, for=10.3.3.3"}}, ip: "10.3.3.3"},
+		{name: "X-Real-IP from trusted peer", remote: "192.168.1.1:1234", headers: map[string][]string{"X-Real-IP": {"198.51.100.1"}}, ip: "198.51.100.1"},
+		{name: "X-Real-IP from untrusted peer", remote: "192.168.1.2:1234", headers: map[string][]string{"X-Real-IP": {"198.51.100.1"}}, ip: "192.168.1.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// when
+			var ip, realIP string
+			req := httptest.NewRequest(http.MethodGet, "/api/version", nil)
+			req.RemoteAddr = tt.remote
+			for k, vv := range tt.headers {
+				for _, v := range vv {
+					req.Header.Add(k, v)
+				}
+			}
+			m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ip, realIP = ClientIP(r.Context()), getRealIP(r)
+			})).ServeHTTP(httptest.NewRecorder(), req)
+
+			// then
+			assert.Equal(t, tt.ip, ip)
+			assert.Equal(t, tt.ip, realIP)
+		})
+	}
+}
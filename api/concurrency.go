@@ -0,0 +1,214 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var xRequestPriority = http.CanonicalHeaderKey("X-Request-Priority")
+
+// Priority - request priority class. Requests of lower priority are shed first.
+type Priority int
+
+const (
+	PrioritySheddable Priority = iota
+	PriorityNormal
+	PriorityCritical
+)
+
+// share of the concurrency limit available to the priority classes
+var priorityShares = map[Priority]float64{
+	PrioritySheddable: 0.5,
+	PriorityNormal:    0.9,
+	PriorityCritical:  1,
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PrioritySheddable:
+		return "sheddable"
+	case PriorityCritical:
+		return "critical"
+	default:
+		return "normal"
+	}
+}
+
+// ParsePriority returns priority class by its name.
+func ParsePriority(s string) (Priority, bool) {
+	switch strings.ToLower(s) {
+	case "sheddable":
+		return PrioritySheddable, true
+	case "normal":
+		return PriorityNormal, true
+	case "critical":
+		return PriorityCritical, true
+	}
+	return PriorityNormal, false
+}
+
+// ConcurrencyConfig - AIMD limiter settings.
+type ConcurrencyConfig struct {
+	InitialLimit  int
+	MinLimit      int
+	MaxLimit      int
+	TargetLatency time.Duration // latency above the target decreases the limit
+	Backoff       float64       // multiplier applied to the limit on decrease, e.g. 0.9
+}
+
+// ConcurrencyLimiter sheds requests over the adaptive concurrency limit with 503. The limit grows
+// additively while latency observed by the metrics middleware stays below the target and shrinks
+// multiplicatively when it doesn't or the request times out (AIMD). Only requests admitted by the limiter
+// adjust the limit, and it decreases at most once per round trip - requests admitted before the last
+// decrease don't decrease it again.
+type ConcurrencyLimiter struct {
+	l   *zap.SugaredLogger
+	cfg ConcurrencyConfig
+	now func() time.Time
+
+	mu          sync.Mutex
+	limit       float64
+	inFlight    int
+	decreasedAt time.Time
+
+	limitGauge    prometheus.Gauge
+	inFlightGauge prometheus.Gauge
+	rejected      *prometheus.CounterVec
+}
+
+func NewConcurrencyLimiter(l *zap.Logger, reg prometheus.Registerer, cfg ConcurrencyConfig) *ConcurrencyLimiter {
+	limitGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Subsystem: "http",
+		Name:      "concurrency_limit",
+		Help:      "Current adaptive limit of concurrent HTTP requests.",
+	})
+	inFlightGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Subsystem: "http",
+		Name:      "concurrency_in_flight",
+		Help:      "The number of HTTP requests admitted by the concurrency limiter.",
+	})
+	rejected := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "http",
+		Name:      "concurrency_rejected_total",
+		Help:      "The total number of HTTP requests shed by the concurrency limiter.",
+	}, []string{"priority"})
+
+	reg.MustRegister(limitGauge, inFlightGauge, rejected)
+
+	c := &ConcurrencyLimiter{
+		l:             l.Sugar(),
+		cfg:           cfg,
+		now:           time.Now,
+		limit:         float64(cfg.InitialLimit),
+		limitGauge:    limitGauge,
+		inFlightGauge: inFlightGauge,
+		rejected:      rejected,
+	}
+	limitGauge.Set(c.limit)
+
+	return c
+}
+
+func (c *ConcurrencyLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := c.priority(r)
+		if !c.acquire(p) {
+			c.rejected.WithLabelValues(p.String()).Inc()
+			w.Header().Set(retryAfter, "1")
+			WriteErrJSON(c.l, w, r, errors.New("server overloaded, try again later"), http.StatusServiceUnavailable)
+			return
+		}
+		defer c.release()
+
+		if s := getRequestState(r.Context()); s != nil {
+			atomic.StoreInt64(&s.admittedAt, c.now().UnixNano())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ObserveLatency adjusts the limit. It implements LatencyObserver interface.
+func (c *ConcurrencyLimiter) ObserveLatency(r *http.Request, status int, took time.Duration) {
+	// shed requests and requests rejected before the limiter, e.g. 404 or 429, say nothing about the load
+	admittedAt, ok := admitted(r.Context())
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	old := c.limit
+	if timedOut(r.Context()) || took > c.cfg.TargetLatency {
+		if admittedAt.Before(c.decreasedAt) {
+			// admitted under the limit which was already decreased
+			return
+		}
+		c.limit = math.Max(float64(c.cfg.MinLimit), c.limit*c.cfg.Backoff)
+		c.decreasedAt = c.now()
+	} else {
+		c.limit = math.Min(float64(c.cfg.MaxLimit), c.limit+1/c.limit)
+	}
+
+	if int(old) != int(c.limit) {
+		c.l.Debugw("concurrency limit changed", "from", int(old), "to", int(c.limit), "latency", took.String())
+	}
+	c.limitGauge.Set(c.limit)
+}
+
+// Limit returns current concurrency limit.
+func (c *ConcurrencyLimiter) Limit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int(c.limit)
+}
+
+func (c *ConcurrencyLimiter) acquire(p Priority) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if float64(c.inFlight) >= c.limit*priorityShares[p] {
+		return false
+	}
+	c.inFlight++
+	c.inFlightGauge.Set(float64(c.inFlight))
+
+	return true
+}
+
+func (c *ConcurrencyLimiter) release() {
+	c.mu.Lock()
+	c.inFlight--
+	c.inFlightGauge.Set(float64(c.inFlight))
+	c.mu.Unlock()
+}
+
+// priority returns route priority, the X-Request-Priority header can only lower it.
+func (c *ConcurrencyLimiter) priority(r *http.Request) Priority {
+	p := RouteConfigFrom(r.Context()).Priority
+
+	if hp, ok := ParsePriority(r.Header.Get(xRequestPriority)); ok && hp < p {
+		p = hp
+	}
+
+	return p
+}
+
+// admitted returns the time the request was admitted by ConcurrencyLimiter.
+func admitted(ctx context.Context) (time.Time, bool) {
+	s := getRequestState(ctx)
+	if s == nil {
+		return time.Time{}, false
+	}
+	at := atomic.LoadInt64(&s.admittedAt)
+	return time.Unix(0, at), at != 0
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func newTestConcurrencyLimiter(now *time.Time) *ConcurrencyLimiter {
+	c := NewConcurrencyLimiter(zap.NewNop(), prometheus.NewRegistry(), ConcurrencyConfig{
+		InitialLimit:  10,
+		MinLimit:      2,
+		MaxLimit:      20,
+		TargetLatency: 100 * time.Millisecond,
+		Backoff:       0.5,
+	})
+	c.now = func() time.Time { return *now }
+	return c
+}
+
+// admit passes the request through the limiter, the way the metrics middleware sees it.
+func admit(c *ConcurrencyLimiter) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+	r = r.WithContext(context.WithValue(r.Context(), requestStateKey, &requestState{}))
+	c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(httptest.NewRecorder(), r)
+	return r
+}
+
+func Test_ConcurrencyLimiter_ShouldIncreaseLimitWhenLatencyIsBelowTarget(t *testing.T) {
+	// given
+	now := time.Unix(1000, 0)
+	c := newTestConcurrencyLimiter(&now)
+
+	// when
+	for i := 0; i < 25; i++ {
+		c.ObserveLatency(admit(c), http.StatusOK, 10*time.Millisecond)
+	}
+
+	// then
+	assert.Equal(t, 12, c.Limit())
+	assert.Equal(t, c.limit, testutil.ToFloat64(c.limitGauge))
+}
+
+func Test_ConcurrencyLimiter_ShouldDecreaseLimitOncePerRoundTrip(t *testing.T) {
+	// given
+	now := time.Unix(1000, 0)
+	c := newTestConcurrencyLimiter(&now)
+	first, second := admit(c), admit(c)
+	now = now.Add(time.Second)
+
+	// when
+	c.ObserveLatency(first, http.StatusOK, time.Second)
+	c.ObserveLatency(second, http.StatusOK, time.Second)
+
+	// then
+	assert.Equal(t, 5, c.Limit(), "requests admitted before the decrease shouldn't decrease the limit again")
+
+	// when request admitted after the decrease is slow too
+	now = now.Add(time.Second)
+	c.ObserveLatency(admit(c), http.StatusServiceUnavailable, time.Second)
+
+	// then
+	assert.Equal(t, 2, c.Limit())
+}
+
+func Test_ConcurrencyLimiter_ShouldDecreaseLimitOnTimeout(t *testing.T) {
+	// given
+	now := time.Unix(1000, 0)
+	c := newTestConcurrencyLimiter(&now)
+	r := admit(c)
+	getRequestState(r.Context()).timedOut = 1
+
+	// when
+	c.ObserveLatency(r, http.StatusGatewayTimeout, 10*time.Millisecond)
+
+	// then
+	assert.Equal(t, 5, c.Limit())
+}
+
+func Test_ConcurrencyLimiter_ShouldIgnoreRequestsItDidNotGovern(t *testing.T) {
+	// given
+	now := time.Unix(1000, 0)
+	c := newTestConcurrencyLimiter(&now)
+	c.inFlight = 10
+	shed := admit(c)
+	c.inFlight = 0
+	notFound := httptest.NewRequest(http.MethodGet, "/api/missing", nil)
+	notFound = notFound.WithContext(context.WithValue(notFound.Context(), requestStateKey, &requestState{}))
+
+	// when
+	for i := 0; i < 25; i++ {
+		c.ObserveLatency(shed, http.StatusServiceUnavailable, time.Millisecond)
+		c.ObserveLatency(notFound, http.StatusNotFound, time.Millisecond)
+		c.ObserveLatency(httptest.NewRequest(http.MethodGet, "/api/items", nil), http.StatusTooManyRequests, time.Millisecond)
+	}
+
+	// then
+	assert.Equal(t, float64(10), c.limit)
+}
+
+func Test_ConcurrencyLimiter_ShouldShedByPriority(t *testing.T) {
+	tests := []struct {
+		name     string
+		inFlight int
+		path     string
+		priority string
+		status   int
+	}{
+		{name: "normal under its share", inFlight: 8, path: "/api/items", status: http.StatusOK},
+		{name: "normal over its share", inFlight: 9, path: "/api/items", status: http.StatusServiceUnavailable},
+		{name: "sheddable over its share", inFlight: 5, path: "/api/items", priority: "sheddable", status: http.StatusServiceUnavailable},
+		{name: "critical route", inFlight: 9, path: "/api/health", status: http.StatusOK},
+		{name: "critical route lowered by header", inFlight: 9, path: "/api/health", priority: "normal", status: http.StatusServiceUnavailable},
+		{name: "header can't raise priority", inFlight: 9, path: "/api/items", priority: "critical", status: http.StatusServiceUnavailable},
+		{name: "critical over the limit", inFlight: 10, path: "/api/health", status: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			now := time.Unix(1000, 0)
+			c := newTestConcurrencyLimiter(&now)
+			c.inFlight = tt.inFlight
+			routes := NewRoutes()
+			r := mux.NewRouter()
+			r.Use(routes.Handler, c.Handler)
+			r.HandleFunc("/api/items", func(w http.ResponseWriter, r *http.Request) {})
+			routes.Route(r.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {}), WithPriority(PriorityCritical))
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.priority != "" {
+				req.Header.Set("X-Request-Priority", tt.priority)
+			}
+			w := httptest.NewRecorder()
+
+			// when
+			r.ServeHTTP(w, req)
+
+			// then
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.inFlight, c.inFlight, "admitted request should be released")
+			if tt.status == http.StatusServiceUnavailable {
+				assert.Equal(t, "1", w.Header().Get("Retry-After"))
+				assert.Contains(t, w.Body.String(), `"httpStatusCode":503`)
+			} else {
+				assert.Empty(t, w.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
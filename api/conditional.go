@@ -0,0 +1,179 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	eTag              = http.CanonicalHeaderKey("ETag")
+	lastModified      = http.CanonicalHeaderKey("Last-Modified")
+	cacheControl      = http.CanonicalHeaderKey("Cache-Control")
+	ifMatch           = http.CanonicalHeaderKey("If-Match")
+	ifNoneMatch       = http.CanonicalHeaderKey("If-None-Match")
+	ifModifiedSince   = http.CanonicalHeaderKey("If-Modified-Since")
+	ifUnmodifiedSince = http.CanonicalHeaderKey("If-Unmodified-Since")
+)
+
+// JSONOption adds validators and caching policy to the response written by MustWriteJSON.
+type JSONOption func(*jsonOptions)
+
+type jsonOptions struct {
+	etag         string
+	hashPayload  bool
+	weak         bool
+	lastModified time.Time
+	cache        *CachePolicy
+}
+
+// WithETag sets strong ETag generated from the encoded payload.
+func WithETag() JSONOption {
+	return func(o *jsonOptions) {
+		o.hashPayload, o.weak = true, false
+	}
+}
+
+// WithWeakETag sets weak ETag generated from the encoded payload - use it when equal payloads
+// may differ in bytes, e.g. are compressed by a proxy.
+func WithWeakETag() JSONOption {
+	return func(o *jsonOptions) {
+		o.hashPayload, o.weak = true, true
+	}
+}
+
+// WithVersionETag sets ETag of the resource version, e.g. revision number, without hashing the payload.
+func WithVersionETag(version string, weak bool) JSONOption {
+	return func(o *jsonOptions) {
+		o.etag, o.hashPayload = VersionETag(version, weak), false
+	}
+}
+
+// WithLastModified sets Last-Modified of the resource, used by If-Modified-Since requests.
+func WithLastModified(t time.Time) JSONOption {
+	return func(o *jsonOptions) {
+		o.lastModified = t
+	}
+}
+
+// WithCacheControl sets Cache-Control of the response, it takes precedence over the route policy.
+func WithCacheControl(p CachePolicy) JSONOption {
+	return func(o *jsonOptions) {
+		o.cache = &p
+	}
+}
+
+// JSONETag returns ETag MustWriteJSON generates for data with WithETag or WithWeakETag.
+// Handlers use it to check preconditions of updates against the current representation.
+func JSONETag(data interface{}, weak bool) (string, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return "", errors.Wrap(err, "can't encode JSON")
+	}
+	return payloadETag(b, weak), nil
+}
+
+// VersionETag returns quoted entity tag of the version.
+func VersionETag(version string, weak bool) string {
+	tag := `"` + strings.Replace(version, `"`, "", -1) + `"`
+	if weak {
+		return "W/" + tag
+	}
+	return tag
+}
+
+func payloadETag(b []byte, weak bool) string {
+	sum := sha256.Sum256(b)
+	return VersionETag(hex.EncodeToString(sum[:16]), weak)
+}
+
+// writeValidators sets headers of the options and returns true when the client's copy is fresh,
+// so 304 Not Modified should be sent instead of the payload.
+func writeValidators(w http.ResponseWriter, r *http.Request, payload []byte, httpCode int, opts []JSONOption) bool {
+	var o jsonOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	h := w.Header()
+	if o.hashPayload {
+		o.etag = payloadETag(payload, o.weak)
+	}
+	if o.etag != "" {
+		h.Set(eTag, o.etag)
+	}
+	if !o.lastModified.IsZero() {
+		h.Set(lastModified, o.lastModified.UTC().Format(http.TimeFormat))
+	}
+	if o.cache != nil {
+		if v := o.cache.String(); v != "" {
+			h.Set(cacheControl, v)
+		}
+	}
+
+	return r != nil && httpCode == http.StatusOK && notModified(r, o.etag, o.lastModified)
+}
+
+// notModified evaluates If-None-Match and If-Modified-Since of GET and HEAD requests (RFC 7232).
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get(ifNoneMatch); inm != "" {
+		return etagMatch(inm, etag, false)
+	}
+	if ims, err := http.ParseTime(r.Header.Get(ifModifiedSince)); err == nil && !modified.IsZero() {
+		return !modified.Truncate(time.Second).After(ims)
+	}
+	return false
+}
+
+// CheckPreconditions evaluates If-Match, If-Unmodified-Since and If-None-Match of the update
+// against validators of the current resource, empty etag means the resource doesn't exist.
+// Returned *RequestError has 412 status when the client's copy is outdated.
+func CheckPreconditions(r *http.Request, etag string, modified time.Time) error {
+	if im := r.Header.Get(ifMatch); im != "" {
+		if !etagMatch(im, etag, true) {
+			return &RequestError{Status: http.StatusPreconditionFailed, Msg: "resource was modified, If-Match doesn't match current ETag"}
+		}
+	} else if ius, err := http.ParseTime(r.Header.Get(ifUnmodifiedSince)); err == nil && !modified.IsZero() {
+		if modified.Truncate(time.Second).After(ius) {
+			return &RequestError{Status: http.StatusPreconditionFailed, Msg: "resource was modified after If-Unmodified-Since"}
+		}
+	}
+
+	if inm := r.Header.Get(ifNoneMatch); inm != "" && etagMatch(inm, etag, false) {
+		return &RequestError{Status: http.StatusPreconditionFailed, Msg: "resource matches If-None-Match"}
+	}
+
+	return nil
+}
+
+// etagMatch returns true when the list of entity tags from the header matches etag.
+// Strong comparison requires both tags to be strong, weak one ignores the W/ prefix.
+func etagMatch(header, etag string, strong bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if strong {
+			if t == etag && !strings.HasPrefix(t, "W/") {
+				return true
+			}
+			continue
+		}
+		if strings.TrimPrefix(t, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
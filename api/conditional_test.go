@@ -0,0 +1,120 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func Test_MustWriteJSON_ShouldHandleConditionalGet(t *testing.T) {
+	// given
+	data := map[string]string{"name": "bob"}
+	etag, err := JSONETag(data, false)
+	require.NoError(t, err)
+	modified := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		method string
+		header map[string]string
+		opts   []JSONOption
+		status int
+	}{
+		{name: "no conditions", method: http.MethodGet, opts: []JSONOption{WithETag()}, status: http.StatusOK},
+		{name: "matching If-None-Match", method: http.MethodGet, header: map[string]string{"If-None-Match": etag}, opts: []JSONOption{WithETag()}, status: http.StatusNotModified},
+		{name: "weak match of strong ETag", method: http.MethodGet, header: map[string]string{"If-None-Match": `"other", W/` + etag}, opts: []JSONOption{WithETag()}, status: http.StatusNotModified},
+		{name: "wildcard If-None-Match", method: http.MethodHead, header: map[string]string{"If-None-Match": "*"}, opts: []JSONOption{WithWeakETag()}, status: http.StatusNotModified},
+		{name: "changed payload", method: http.MethodGet, header: map[string]string{"If-None-Match": `"other"`}, opts: []JSONOption{WithETag()}, status: http.StatusOK},
+		{name: "If-None-Match takes precedence", method: http.MethodGet, header: map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": modified.Format(http.TimeFormat)},
+			opts: []JSONOption{WithETag(), WithLastModified(modified)}, status: http.StatusOK},
+		{name: "not modified since", method: http.MethodGet, header: map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)},
+			opts: []JSONOption{WithLastModified(modified.Add(500 * time.Millisecond))}, status: http.StatusNotModified},
+		{name: "modified since", method: http.MethodGet, header: map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)},
+			opts: []JSONOption{WithLastModified(modified.Add(time.Second))}, status: http.StatusOK},
+		{name: "unsafe method", method: http.MethodPost, header: map[string]string{"If-None-Match": etag}, opts: []JSONOption{WithETag()}, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/users/1", nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+
+			// when
+			MustWriteJSON(zap.NewNop().Sugar(), w, r, data, http.StatusOK, tt.opts...)
+
+			// then
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status == http.StatusNotModified {
+				assert.Empty(t, w.Body.String())
+				assert.Empty(t, w.Header().Get("Content-Type"))
+			} else {
+				assert.JSONEq(t, `{"name":"bob"}`, w.Body.String())
+			}
+		})
+	}
+}
+
+func Test_MustWriteJSON_ShouldSetValidatorHeaders(t *testing.T) {
+	// given
+	w := httptest.NewRecorder()
+	modified := time.Date(2020, 1, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+
+	// when
+	MustWriteJSON(zap.NewNop().Sugar(), w, httptest.NewRequest(http.MethodGet, "/", nil), "v", http.StatusOK,
+		WithVersionETag("42", true), WithLastModified(modified), WithCacheControl(Revalidate()))
+
+	// then
+	assert.Equal(t, `W/"42"`, w.Header().Get("ETag"))
+	assert.Equal(t, "Wed, 01 Jan 2020 11:00:00 GMT", w.Header().Get("Last-Modified"))
+	assert.Equal(t, "private, no-cache", w.Header().Get("Cache-Control"))
+}
+
+func Test_CheckPreconditions(t *testing.T) {
+	// given
+	modified := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		etag   string
+		failed bool
+	}{
+		{name: "no conditions", etag: `"v1"`, failed: false},
+		{name: "matching If-Match", header: map[string]string{"If-Match": `"v0", "v1"`}, etag: `"v1"`, failed: false},
+		{name: "outdated If-Match", header: map[string]string{"If-Match": `"v0"`}, etag: `"v1"`, failed: true},
+		{name: "weak If-Match never matches", header: map[string]string{"If-Match": `W/"v1"`}, etag: `W/"v1"`, failed: true},
+		{name: "If-Match of missing resource", header: map[string]string{"If-Match": "*"}, etag: "", failed: true},
+		{name: "If-None-Match wildcard of existing resource", header: map[string]string{"If-None-Match": "*"}, etag: `"v1"`, failed: true},
+		{name: "If-None-Match wildcard of missing resource", header: map[string]string{"If-None-Match": "*"}, etag: "", failed: false},
+		{name: "unmodified since", header: map[string]string{"If-Unmodified-Since": modified.Format(http.TimeFormat)}, etag: `"v1"`, failed: false},
+		{name: "modified since", header: map[string]string{"If-Unmodified-Since": modified.Add(-time.Second).Format(http.TimeFormat)}, etag: `"v1"`, failed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/api/users/1", nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+
+			// when
+			err := CheckPreconditions(r, tt.etag, modified)
+
+			// then
+			if !tt.failed {
+				assert.NoError(t, err)
+				return
+			}
+			require.IsType(t, &RequestError{}, err)
+			assert.Equal(t, http.StatusPreconditionFailed, err.(*RequestError).Status)
+		})
+	}
+}
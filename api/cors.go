@@ -0,0 +1,170 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	origin                        = http.CanonicalHeaderKey("Origin")
+	vary                          = http.CanonicalHeaderKey("Vary")
+	accessControlRequestMethod    = http.CanonicalHeaderKey("Access-Control-Request-Method")
+	accessControlRequestHeaders   = http.CanonicalHeaderKey("Access-Control-Request-Headers")
+	accessControlAllowOrigin      = http.CanonicalHeaderKey("Access-Control-Allow-Origin")
+	accessControlAllowMethods     = http.CanonicalHeaderKey("Access-Control-Allow-Methods")
+	accessControlAllowHeaders     = http.CanonicalHeaderKey("Access-Control-Allow-Headers")
+	accessControlAllowCredentials = http.CanonicalHeaderKey("Access-Control-Allow-Credentials")
+	accessControlExposeHeaders    = http.CanonicalHeaderKey("Access-Control-Expose-Headers")
+	accessControlMaxAge           = http.CanonicalHeaderKey("Access-Control-Max-Age")
+)
+
+// CORSConfig - cross-origin resource sharing policy.
+type CORSConfig struct {
+	// AllowedOrigins - exact origins, e.g. https://app.example.com, patterns with a single wildcard,
+	// e.g. https://*.example.com, or "*" allowing any origin
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// CORS adds CORS headers to responses of the route group under the path prefix and answers
+// preflight requests with 204 without calling the next handler. Register it on the router after
+// the request ID and metrics middlewares and before authentication, so error responses carry
+// CORS headers too. Preflight requests must match a route to reach the router middlewares.
+type CORS struct {
+	l      *zap.SugaredLogger
+	prefix string
+	cfg    CORSConfig
+
+	methods map[string]bool
+	headers map[string]bool
+}
+
+func NewCORS(l *zap.Logger, prefix string, cfg CORSConfig) *CORS {
+	c := &CORS{
+		l:       l.Sugar(),
+		prefix:  prefix,
+		cfg:     cfg,
+		methods: make(map[string]bool),
+		headers: make(map[string]bool),
+	}
+	for _, m := range cfg.AllowedMethods {
+		c.methods[strings.ToUpper(m)] = true
+	}
+	for _, h := range cfg.AllowedHeaders {
+		c.headers[http.CanonicalHeaderKey(h)] = true
+	}
+
+	return c
+}
+
+func (c *CORS) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, c.prefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// responses differ per origin, caches must not mix them up
+		w.Header().Add(vary, origin)
+
+		o := r.Header.Get(origin)
+		if r.Method == http.MethodOptions && o != "" && r.Header.Get(accessControlRequestMethod) != "" {
+			c.preflight(w, r, o)
+			return
+		}
+
+		if o != "" && c.originAllowed(o) {
+			c.allowOrigin(w, o)
+			if len(c.cfg.ExposedHeaders) > 0 {
+				w.Header().Set(accessControlExposeHeaders, strings.Join(c.cfg.ExposedHeaders, ", "))
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// preflight answers the preflight request. Disallowed requests get no CORS headers, so the browser blocks them.
+func (c *CORS) preflight(w http.ResponseWriter, r *http.Request, o string) {
+	h := w.Header()
+	h.Add(vary, accessControlRequestMethod)
+	h.Add(vary, accessControlRequestHeaders)
+
+	method := strings.ToUpper(r.Header.Get(accessControlRequestMethod))
+	requested := SplitList(r.Header.Get(accessControlRequestHeaders))
+
+	l := LoggerFrom(r.Context()).Sugar()
+	switch {
+	case !c.originAllowed(o):
+		l.Debugw("CORS preflight rejected", "reason", "origin not allowed", "origin", o)
+	case !c.methods[method]:
+		l.Debugw("CORS preflight rejected", "reason", "method not allowed", "method", method)
+	case !c.headersAllowed(requested):
+		l.Debugw("CORS preflight rejected", "reason", "headers not allowed", "headers", requested)
+	default:
+		c.allowOrigin(w, o)
+		h.Set(accessControlAllowMethods, strings.Join(c.cfg.AllowedMethods, ", "))
+		if len(requested) > 0 {
+			h.Set(accessControlAllowHeaders, strings.Join(requested, ", "))
+		}
+		if c.cfg.MaxAge > 0 {
+			h.Set(accessControlMaxAge, strconv.Itoa(int(c.cfg.MaxAge.Seconds())))
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *CORS) allowOrigin(w http.ResponseWriter, o string) {
+	// "*" can't be used with credentials - the origin is echoed instead
+	if len(c.cfg.AllowedOrigins) == 1 && c.cfg.AllowedOrigins[0] == "*" && !c.cfg.AllowCredentials {
+		w.Header().Set(accessControlAllowOrigin, "*")
+	} else {
+		w.Header().Set(accessControlAllowOrigin, o)
+	}
+	if c.cfg.AllowCredentials {
+		w.Header().Set(accessControlAllowCredentials, "true")
+	}
+}
+
+func (c *CORS) originAllowed(o string) bool {
+	for _, allowed := range c.cfg.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, o) || matchOriginPattern(allowed, o) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOriginPattern matches origin against pattern with a single wildcard standing for subdomains,
+// e.g. https://*.example.com matches https://app.example.com but not https://example.com.
+func matchOriginPattern(pattern, o string) bool {
+	i := strings.IndexByte(pattern, '*')
+	if i == -1 {
+		return false
+	}
+	prefix, suffix := strings.ToLower(pattern[:i]), strings.ToLower(pattern[i+1:])
+	o = strings.ToLower(o)
+	if len(o) <= len(prefix)+len(suffix) || !strings.HasPrefix(o, prefix) || !strings.HasSuffix(o, suffix) {
+		return false
+	}
+	sub := o[len(prefix) : len(o)-len(suffix)]
+	return !strings.ContainsAny(sub, "/:@")
+}
+
+func (c *CORS) headersAllowed(requested []string) bool {
+	for _, h := range requested {
+		if !c.headers[http.CanonicalHeaderKey(h)] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func newTestCORSRouter(cfg CORSConfig, requestIDs *[]string) *mux.Router {
+	cors := NewCORS(zap.NewNop(), "/api/", cfg)
+
+	r := mux.NewRouter()
+	r.Use(RequestIDMiddleware)
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			*requestIDs = append(*requestIDs, GetReqID(req.Context()))
+			next.ServeHTTP(w, req)
+		})
+	})
+	r.Use(cors.Handler)
+	r.HandleFunc("/api/version", func(w http.ResponseWriter, r *http.Request) {}).Methods(http.MethodGet)
+	r.PathPrefix("/api/").Methods(http.MethodOptions).HandlerFunc(NewFallback(zap.NewNop(), r).Options)
+
+	return r
+}
+
+func Test_CORS_ShouldAnswerPreflightRequests(t *testing.T) {
+	// given
+	var requestIDs []string
+	r := newTestCORSRouter(CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}, &requestIDs)
+
+	tests := []struct {
+		name, origin, method, headers string
+		allowed                       bool
+	}{
+		{name: "exact origin", origin: "https://app.example.com", method: "POST", headers: "content-type, authorization", allowed: true},
+		{name: "wildcard origin", origin: "https://eu.shop.example.org", method: "GET", allowed: true},
+		{name: "wildcard doesn't match apex", origin: "https://example.org", method: "GET"},
+		{name: "unknown origin", origin: "https://evil.com", method: "GET"},
+		{name: "method not allowed", origin: "https://app.example.com", method: "DELETE"},
+		{name: "header not allowed", origin: "https://app.example.com", method: "GET", headers: "X-Custom"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// when
+			req := httptest.NewRequest(http.MethodOptions, "/api/version", nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", tt.method)
+			req.Header.Set("Access-Control-Request-Headers", tt.headers)
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			// then
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+			if tt.allowed {
+				assert.Equal(t, tt.origin, rec.Header().Get("Access-Control-Allow-Origin"))
+				assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+				assert.Equal(t, "GET, POST", rec.Header().Get("Access-Control-Allow-Methods"))
+				assert.Equal(t, "600", rec.Header().Get("Access-Control-Max-Age"))
+			} else {
+				assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+			}
+			assert.Contains(t, rec.Header()["Vary"], "Origin")
+		})
+	}
+	assert.Len(t, requestIDs, len(tests), "preflight requests should pass through router middlewares")
+	assert.NotEmpty(t, requestIDs[0])
+}
+
+func Test_CORS_ShouldAddHeadersToActualRequests(t *testing.T) {
+	// given
+	var requestIDs []string
+	r := newTestCORSRouter(CORSConfig{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET"},
+		ExposedHeaders: []string{"X-Request-Id"},
+	}, &requestIDs)
+
+	// when
+	req := httptest.NewRequest(http.MethodGet, "/api/version", nil)
+	req.Header.Set("Origin", "https://any.example.com")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	// then
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Request-Id", rec.Header().Get("Access-Control-Expose-Headers"))
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Credentials"))
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Drainer tracks in-flight requests and hijacked connections, so the graceful shutdown
+// can wait until the traffic actually stops instead of sleeping a fixed amount of time.
+type Drainer struct {
+	l *zap.SugaredLogger
+
+	quietPeriod time.Duration
+	maxWait     time.Duration
+	ignored     map[string]bool
+
+	inFlight    int64
+	lastRequest int64 // unix nano timestamp of the last incoming request
+
+	startOnce sync.Once
+	draining  chan struct{}
+
+	mu       sync.Mutex
+	hijacked map[*hijackedConn]struct{}
+
+	inFlightGauge prometheus.Gauge
+	hijackedGauge prometheus.Gauge
+}
+
+// NewDrainer returns Drainer which considers the server drained when there were no
+// requests for quietPeriod. Draining never takes longer than maxWait. Requests to the ignored
+// paths, e.g. probes and metrics scrapes, come regardless of the traffic and don't delay draining.
+// Its metrics are registered with reg.
+func NewDrainer(l *zap.Logger, reg prometheus.Registerer, quietPeriod, maxWait time.Duration, ignored ...string) *Drainer {
+	inFlight := prometheus.NewGauge(prometheus.GaugeOpts{
+		Subsystem: "http",
+		Name:      "requests_in_flight",
+		Help:      "The number of HTTP requests being served.",
+	})
+	hijacked := prometheus.NewGauge(prometheus.GaugeOpts{
+		Subsystem: "http",
+		Name:      "hijacked_connections",
+		Help:      "The number of open hijacked connections, e.g. websockets.",
+	})
+
+	reg.MustRegister(inFlight, hijacked)
+
+	d := &Drainer{
+		l:             l.Sugar(),
+		quietPeriod:   quietPeriod,
+		maxWait:       maxWait,
+		ignored:       make(map[string]bool),
+		draining:      make(chan struct{}),
+		hijacked:      make(map[*hijackedConn]struct{}),
+		inFlightGauge: inFlight,
+		hijackedGauge: hijacked,
+	}
+	for _, p := range ignored {
+		d.ignored[p] = true
+	}
+
+	return d
+}
+
+// Handler counts in-flight requests and keeps track of hijacked connections.
+func (d *Drainer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.ignored[r.URL.Path] {
+			atomic.StoreInt64(&d.lastRequest, time.Now().UnixNano())
+		}
+		d.inFlightGauge.Set(float64(atomic.AddInt64(&d.inFlight, 1)))
+
+		dw := &drainWriter{ResponseWriter: w, d: d}
+		defer func() {
+			// hijacked requests stop being counted as in-flight when the connection is taken over
+			if !dw.hijacked {
+				d.inFlightGauge.Set(float64(atomic.AddInt64(&d.inFlight, -1)))
+			}
+		}()
+
+		next.ServeHTTP(dw, r)
+	})
+}
+
+// Start flips the service into draining mode. Readiness probes start failing from now on.
+func (d *Drainer) Start() {
+	d.startOnce.Do(func() {
+		d.l.Infow("draining started", "inFlight", atomic.LoadInt64(&d.inFlight))
+		close(d.draining)
+	})
+}
+
+// Draining returns channel closed when draining starts.
+func (d *Drainer) Draining() <-chan struct{} {
+	return d.draining
+}
+
+// Wait starts draining and blocks until there are no in-flight requests and no new requests
+// came for the quiet period. It returns error when it gives up after the max wait time or ctx is done.
+func (d *Drainer) Wait(ctx context.Context) error {
+	d.Start()
+
+	begin := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, d.maxWait)
+	defer cancel()
+
+	ticker := time.NewTicker(d.pollInterval())
+	defer ticker.Stop()
+
+	for {
+		inFlight := atomic.LoadInt64(&d.inFlight)
+		idle := time.Since(time.Unix(0, atomic.LoadInt64(&d.lastRequest)))
+		if inFlight == 0 && idle >= d.quietPeriod {
+			d.l.Infow("draining finished", "took", time.Since(begin).String())
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("draining not finished after %s, in-flight requests: %d", time.Since(begin), inFlight)
+		case <-ticker.C:
+		}
+	}
+}
+
+// WaitHijacked blocks until all hijacked connections are closed or ctx is done.
+// http.Server.Shutdown doesn't wait for them.
+func (d *Drainer) WaitHijacked(ctx context.Context) error {
+	ticker := time.NewTicker(d.pollInterval())
+	defer ticker.Stop()
+
+	for d.Hijacked() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+// CloseHijacked closes all hijacked connections which are still open and returns their number.
+func (d *Drainer) CloseHijacked() int {
+	d.mu.Lock()
+	conns := make([]*hijackedConn, 0, len(d.hijacked))
+	for c := range d.hijacked {
+		conns = append(conns, c)
+	}
+	d.mu.Unlock()
+
+	for _, c := range conns {
+		if err := c.Close(); err != nil {
+			d.l.Warnw("can't close hijacked connection", "remote", c.RemoteAddr().String(), "err", err)
+		}
+	}
+
+	return len(conns)
+}
+
+// InFlight returns number of requests being served.
+func (d *Drainer) InFlight() int64 {
+	return atomic.LoadInt64(&d.inFlight)
+}
+
+// Hijacked returns number of open hijacked connections.
+func (d *Drainer) Hijacked() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.hijacked)
+}
+
+// DrainHandler godoc
+// @Summary Drain traffic
+// @Description flips readiness probe to failing and waits until all ongoing requests are finished - designed to be used as Kubernetes preStop hook. Available on admin port, requires the admin token.
+// @Tags Admin
+// @Produce json
+// @Router /admin/drain [post]
+// @Failure 503 {object} api.HTTPError
+// @Success 200 {object} api.DrainResp
+func (d *Drainer) DrainHandler(w http.ResponseWriter, r *http.Request) {
+	begin := time.Now()
+	if err := d.Wait(r.Context()); err != nil {
+		WriteErrJSON(d.l, w, r, err, http.StatusServiceUnavailable)
+		return
+	}
+
+	MustWriteJSON(d.l, w, r, DrainResp{
+		Msg:      "drained",
+		Took:     time.Since(begin).String(),
+		Hijacked: d.Hijacked(),
+	}, http.StatusOK)
+}
+
+func (d *Drainer) pollInterval() time.Duration {
+	interval := d.quietPeriod / 10
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	if interval > 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	return interval
+}
+
+func (d *Drainer) track(c net.Conn) net.Conn {
+	hc := &hijackedConn{Conn: c, d: d}
+
+	d.mu.Lock()
+	d.hijacked[hc] = struct{}{}
+	d.hijackedGauge.Set(float64(len(d.hijacked)))
+	d.mu.Unlock()
+
+	return hc
+}
+
+func (d *Drainer) untrack(c *hijackedConn) {
+	d.mu.Lock()
+	delete(d.hijacked, c)
+	d.hijackedGauge.Set(float64(len(d.hijacked)))
+	d.mu.Unlock()
+}
+
+type drainWriter struct {
+	http.ResponseWriter
+	d        *Drainer
+	hijacked bool
+}
+
+func (w *drainWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("drainWriter: can't cast parent ResponseWriter to Hijacker")
+	}
+
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w.hijacked = true
+	w.d.inFlightGauge.Set(float64(atomic.AddInt64(&w.d.inFlight, -1)))
+
+	return w.d.track(conn), rw, nil
+}
+
+type hijackedConn struct {
+	net.Conn
+	d    *Drainer
+	once sync.Once
+}
+
+func (c *hijackedConn) Close() error {
+	c.once.Do(func() { c.d.untrack(c) })
+	return c.Conn.Close()
+}
+
+// DrainResp - struct represents response for /admin/drain endpoint.
+type DrainResp struct {
+	Msg      string `json:"msg"`
+	Took     string `json:"took"`
+	Hijacked int    `json:"hijacked"`
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func Test_Drainer_Wait_ShouldFinishAfterQuietPeriod(t *testing.T) {
+	// given
+	d := NewDrainer(zap.NewNop(), prometheus.NewRegistry(), 50*time.Millisecond, time.Second)
+	d.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/version", nil))
+	begin := time.Now()
+
+	// when
+	err := d.Wait(context.Background())
+
+	// then
+	assert.NoError(t, err)
+	assert.True(t, time.Since(begin) >= 40*time.Millisecond, "draining should wait for the quiet period")
+	select {
+	case <-d.Draining():
+	default:
+		t.Error("draining should be started")
+	}
+}
+
+func Test_Drainer_Wait_ShouldWaitForInFlightRequests(t *testing.T) {
+	// given
+	d := NewDrainer(zap.NewNop(), prometheus.NewRegistry(), 0, time.Second)
+	release := make(chan struct{})
+	served := make(chan struct{})
+	h := d.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/version", nil))
+		close(served)
+	}()
+	for d.InFlight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// when
+	done := make(chan error)
+	go func() { done <- d.Wait(context.Background()) }()
+
+	// then
+	select {
+	case <-done:
+		t.Fatal("draining should wait for the in-flight request")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-served
+	assert.NoError(t, <-done)
+	assert.Equal(t, float64(0), testutil.ToFloat64(d.inFlightGauge))
+}
+
+func Test_Drainer_Wait_ShouldGiveUpAfterMaxWait(t *testing.T) {
+	// given
+	d := NewDrainer(zap.NewNop(), prometheus.NewRegistry(), time.Hour, 50*time.Millisecond)
+	d.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/version", nil))
+
+	// when
+	err := d.Wait(context.Background())
+
+	// then
+	assert.Error(t, err)
+}
+
+func Test_Drainer_Wait_ShouldIgnoreProbes(t *testing.T) {
+	// given
+	d := NewDrainer(zap.NewNop(), prometheus.NewRegistry(), time.Hour, 100*time.Millisecond, "/api/ready", "/metrics")
+	h := d.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/ready", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	// when
+	err := d.Wait(context.Background())
+
+	// then
+	assert.NoError(t, err, "requests to the ignored paths should not reset the quiet period")
+}
+
+func Test_Drainer_DrainHandler_ShouldRespondWithStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxWait time.Duration
+		status  int
+	}{
+		{name: "drained", maxWait: time.Second, status: http.StatusOK},
+		{name: "not drained", maxWait: 10 * time.Millisecond, status: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDrainer(zap.NewNop(), prometheus.NewRegistry(), 50*time.Millisecond, tt.maxWait)
+			d.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+				ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/version", nil))
+			w := httptest.NewRecorder()
+
+			// when
+			d.DrainHandler(w, httptest.NewRequest(http.MethodPost, "/drain", nil))
+
+			// then
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
+
+func Test_Drainer_ShouldTrackHijackedConnections(t *testing.T) {
+	// given
+	d := NewDrainer(zap.NewNop(), prometheus.NewRegistry(), 0, time.Second)
+	hijacked := make(chan struct{})
+	srv := httptest.NewServer(d.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n\r\n"))
+		close(hijacked)
+	})))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: test\r\n\r\n"))
+	require.NoError(t, err)
+	<-hijacked
+
+	// when
+	closed := d.CloseHijacked()
+
+	// then
+	assert.Equal(t, 1, closed)
+	assert.Equal(t, 0, d.Hijacked())
+	assert.Equal(t, int64(0), d.InFlight())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, d.WaitHijacked(ctx))
+}
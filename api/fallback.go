@@ -0,0 +1,103 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+var allow = http.CanonicalHeaderKey("Allow")
+
+// allowCandidates - methods checked against the routes of the path, OPTIONS is always allowed.
+var allowCandidates = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// Fallback answers requests which don't match any route of the router with JSON HTTPError -
+// 404 Not Found when no route matches the path and 405 Method Not Allowed with Allow header when
+// routes of the path don't accept the method. OPTIONS requests of the registered paths are answered
+// with 204 and Allow header. Unmatched requests skip the router middlewares, so Register wraps
+// the handlers with the middlewares which must see every request.
+type Fallback struct {
+	l      *zap.SugaredLogger
+	router *mux.Router
+}
+
+func NewFallback(l *zap.Logger, router *mux.Router) *Fallback {
+	return &Fallback{l: l.Sugar(), router: router}
+}
+
+// Register sets NotFoundHandler and MethodNotAllowedHandler of the router wrapped with
+// the middlewares, the first one is the outermost like with Router.Use.
+func (f *Fallback) Register(mws ...mux.MiddlewareFunc) {
+	f.router.NotFoundHandler = chain(http.HandlerFunc(f.NotFound), mws)
+	f.router.MethodNotAllowedHandler = chain(http.HandlerFunc(f.MethodNotAllowed), mws)
+}
+
+// NotFound responds with 404 Not Found.
+func (f *Fallback) NotFound(w http.ResponseWriter, r *http.Request) {
+	MustWriteJSON(f.l, w, r, HTTPError{
+		HTTPStatusCode:  http.StatusNotFound,
+		Msg:             "no route matches " + r.URL.Path,
+		InternalErrCode: -1,
+	}, http.StatusNotFound)
+}
+
+// MethodNotAllowed responds with 405 Method Not Allowed and methods accepted by the path
+// in Allow header. OPTIONS requests are answered with 204 No Content.
+func (f *Fallback) MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	methods := allowedMethods(f.router, r)
+	if len(methods) == 0 {
+		f.NotFound(w, r)
+		return
+	}
+	w.Header().Set(allow, strings.Join(append(methods, http.MethodOptions), ", "))
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	MustWriteJSON(f.l, w, r, HTTPError{
+		HTTPStatusCode:  http.StatusMethodNotAllowed,
+		Msg:             "method " + r.Method + " is not allowed on " + r.URL.Path,
+		InternalErrCode: -1,
+	}, http.StatusMethodNotAllowed)
+}
+
+// Options responds to OPTIONS requests of a route group registered to pass CORS preflight
+// requests through the router middlewares, e.g. /api/ prefix, like unmatched OPTIONS requests.
+func (f *Fallback) Options(w http.ResponseWriter, r *http.Request) {
+	f.MethodNotAllowed(w, r)
+}
+
+// allowedMethods returns methods of the router's routes matching path of the request, routes
+// matching only OPTIONS, e.g. CORS preflight catch-all, aren't counted.
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+	var methods []string
+	for _, m := range allowCandidates {
+		probe := new(http.Request)
+		*probe = *r
+		probe.Method = m
+
+		var match mux.RouteMatch
+		if router.Match(probe, &match) && match.MatchErr == nil {
+			methods = append(methods, m)
+		}
+	}
+	return methods
+}
+
+func chain(h http.Handler, mws []mux.MiddlewareFunc) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newFallbackRouter(mws ...mux.MiddlewareFunc) *mux.Router {
+	r := mux.NewRouter()
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	r.HandleFunc("/api/items", ok).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/api/items/{id}", ok).Methods(http.MethodGet)
+	r.HandleFunc("/api/items/{id}", ok).Methods(http.MethodDelete)
+	r.PathPrefix("/api/v1/").Subrouter().HandleFunc("/users", ok).Methods(http.MethodGet)
+	NewFallback(zap.NewNop(), r).Register(mws...)
+	return r
+}
+
+func Test_Fallback_ShouldAnswerUnmatchedRequests(t *testing.T) {
+	// given
+	router := newFallbackRouter()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		allow  string
+	}{
+		{name: "unknown path", method: http.MethodGet, path: "/api/unknown", status: http.StatusNotFound},
+		{name: "unknown subrouter path", method: http.MethodGet, path: "/api/v1/unknown", status: http.StatusNotFound},
+		{name: "method not allowed", method: http.MethodPut, path: "/api/items", status: http.StatusMethodNotAllowed, allow: "GET, POST, OPTIONS"},
+		{name: "methods of several routes", method: http.MethodPost, path: "/api/items/1", status: http.StatusMethodNotAllowed, allow: "GET, DELETE, OPTIONS"},
+		{name: "subrouter method not allowed", method: http.MethodPost, path: "/api/v1/users", status: http.StatusMethodNotAllowed, allow: "GET, OPTIONS"},
+		{name: "options", method: http.MethodOptions, path: "/api/items", status: http.StatusNoContent, allow: "GET, POST, OPTIONS"},
+		{name: "options of unknown path", method: http.MethodOptions, path: "/api/unknown", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			// when
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+			// then
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.allow, w.Header().Get("Allow"))
+			if tt.status == http.StatusNoContent {
+				assert.Empty(t, w.Body.String())
+				return
+			}
+			assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+			var e HTTPError
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &e))
+			assert.Equal(t, tt.status, e.HTTPStatusCode)
+			assert.NotEmpty(t, e.Msg)
+		})
+	}
+}
+
+func Test_Fallback_ShouldRunMiddlewares(t *testing.T) {
+	// given
+	histogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "duration"}, []string{"method", "path", "status"})
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "total"}, []string{"status"})
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(histogram)
+	prom := &MetricsMiddleware{Histogram: histogram, Counter: counter}
+
+	var requestID string
+	capture := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID = GetReqID(r.Context())
+			next.ServeHTTP(w, r)
+		})
+	}
+	router := newFallbackRouter(prom.Handler, RequestIDMiddleware, capture)
+
+	// when
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/unknown/42", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/api/items", nil))
+
+	// then
+	assert.NotEmpty(t, requestID)
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	labels := map[string]string{}
+	for _, m := range families[0].GetMetric() {
+		var path, status string
+		for _, lp := range m.GetLabel() {
+			switch lp.GetName() {
+			case "path":
+				path = lp.GetValue()
+			case "status":
+				status = lp.GetValue()
+			}
+		}
+		labels[status] = path
+	}
+	assert.Equal(t, map[string]string{"404": unmatchedLabel, "405": unmatchedLabel}, labels)
+}
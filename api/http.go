@@ -2,7 +2,11 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"strings"
 
 	_ "github.com/mateuszdyminski/go-template/swagger-docs"
 	"github.com/swaggo/swag"
@@ -11,18 +15,29 @@ import (
 	"go.uber.org/zap"
 )
 
-// WriteErrJSON wraps error in JSON structure.
+// DefaultMaxBodyBytes - max size of request body accepted by ReadJSON.
+const DefaultMaxBodyBytes = 1 << 20
+
+// WriteErrJSON wraps error in JSON structure. Status code and field errors of *RequestError
+// take precedence over httpCode. Secrets are masked with the redactor of ContextLogger.
 func WriteErrJSON(l *zap.SugaredLogger, w http.ResponseWriter, r *http.Request, err error, httpCode int) {
 	// log outgoing errors
-	l.With("requestId", GetReqID(r.Context())).Error(err)
+	LoggerFrom(r.Context()).Sugar().Error(err)
 
 	// write error to response
 	e := HTTPError{
 		HTTPStatusCode:  httpCode,
-		Msg:             err.Error(),
+		Msg:             redactString(r.Context(), err.Error()),
 		InternalErrCode: -1,
 	}
 
+	if re, ok := errors.Cause(err).(*RequestError); ok {
+		httpCode = re.Status
+		e.HTTPStatusCode = re.Status
+		e.Msg = re.Msg
+		e.Errors = re.Fields
+	}
+
 	if err := WriteJSON(w, e, httpCode); err != nil {
 		l.Errorw("error while sending err json", "err", err)
 	}
@@ -30,11 +45,29 @@ func WriteErrJSON(l *zap.SugaredLogger, w http.ResponseWriter, r *http.Request,
 
 // WriteJSON writes response to client, response is a struct defining JSON reply.
 func WriteJSON(w http.ResponseWriter, data interface{}, httpCode int) error {
+	return writeJSON(w, nil, data, httpCode, nil)
+}
+
+// MustWriteJSON writes response to client, response is a struct defining JSON reply.
+// Options add ETag, Last-Modified and Cache-Control headers - 304 Not Modified is sent instead of
+// 200 response when the client's copy matches If-None-Match or If-Modified-Since.
+func MustWriteJSON(l *zap.SugaredLogger, w http.ResponseWriter, r *http.Request, data interface{}, httpCode int, opts ...JSONOption) {
+	if err := writeJSON(w, r, data, httpCode, opts); err != nil {
+		WriteErrJSON(l, w, r, err, http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, data interface{}, httpCode int, opts []JSONOption) error {
 	json, err := json.Marshal(data)
 	if err != nil {
 		return errors.Wrap(err, "can't encode JSON")
 	}
 
+	if len(opts) > 0 && writeValidators(w, r, json, httpCode, opts) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(httpCode)
 
@@ -45,11 +78,77 @@ func WriteJSON(w http.ResponseWriter, data interface{}, httpCode int) error {
 	return nil
 }
 
-// MustWriteJSON writes response to client, response is a struct defining JSON reply.
-func MustWriteJSON(l *zap.SugaredLogger, w http.ResponseWriter, r *http.Request, data interface{}, httpCode int) {
-	if err := WriteJSON(w, data, httpCode); err != nil {
-		WriteErrJSON(l, w, r, err, http.StatusInternalServerError)
+// ReadJSON decodes JSON request body into dst and validates it with `validate` struct tags.
+// It's ReadJSONLimit with DefaultMaxBodyBytes limit.
+func ReadJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	return ReadJSONLimit(w, r, dst, DefaultMaxBodyBytes)
+}
+
+// ReadJSONLimit decodes JSON request body into dst and validates it with `validate` struct tags.
+// Returned *RequestError describes the problem: 415 for wrong Content-Type, 413 for body over maxBytes,
+// 400 for malformed JSON, unknown fields or trailing data and 422 listing all fields failing validation.
+func ReadJSONLimit(w http.ResponseWriter, r *http.Request, dst interface{}, maxBytes int64) error {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil || mt != "application/json" {
+			return &RequestError{Status: http.StatusUnsupportedMediaType, Msg: fmt.Sprintf("unsupported Content-Type %q, expected application/json", ct)}
+		}
+	} else {
+		return &RequestError{Status: http.StatusUnsupportedMediaType, Msg: "missing Content-Type, expected application/json"}
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBytes))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(dst); err != nil {
+		return decodeError(err, maxBytes)
+	}
+
+	// body must contain a single JSON value
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		if _, ok := err.(*http.MaxBytesError); ok {
+			return decodeError(err, maxBytes)
+		}
+		return &RequestError{Status: http.StatusBadRequest, Msg: "request body must contain a single JSON value"}
+	}
+
+	if fields := Validate(dst); len(fields) > 0 {
+		return &RequestError{Status: http.StatusUnprocessableEntity, Msg: "request validation failed", Fields: fields}
+	}
+
+	return nil
+}
+
+// decodeError translates JSON decoder errors to messages which don't expose Go internals.
+func decodeError(err error, maxBytes int64) *RequestError {
+	switch e := err.(type) {
+	case *http.MaxBytesError:
+		return &RequestError{Status: http.StatusRequestEntityTooLarge, Msg: fmt.Sprintf("request body must not be larger than %d bytes", maxBytes)}
+	case *json.SyntaxError:
+		return &RequestError{Status: http.StatusBadRequest, Msg: fmt.Sprintf("malformed JSON at position %d", e.Offset)}
+	case *json.UnmarshalTypeError:
+		return &RequestError{
+			Status: http.StatusBadRequest,
+			Msg:    "invalid type of JSON value",
+			Fields: []FieldError{{Field: e.Field, Reason: "type", Msg: fmt.Sprintf("must be %s", e.Type)}},
+		}
+	}
+
+	switch {
+	case err == io.EOF:
+		return &RequestError{Status: http.StatusBadRequest, Msg: "request body must not be empty"}
+	case err == io.ErrUnexpectedEOF:
+		return &RequestError{Status: http.StatusBadRequest, Msg: "malformed JSON"}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return &RequestError{
+			Status: http.StatusBadRequest,
+			Msg:    "unknown field in request body",
+			Fields: []FieldError{{Field: field, Reason: "unknown", Msg: "unknown field"}},
+		}
 	}
+
+	return &RequestError{Status: http.StatusBadRequest, Msg: "can't decode request body"}
 }
 
 func SwaggerHandler(l *zap.SugaredLogger) func(w http.ResponseWriter, r *http.Request) {
@@ -67,7 +166,46 @@ func SwaggerHandler(l *zap.SugaredLogger) func(w http.ResponseWriter, r *http.Re
 
 // HTTPError - general error response for api.
 type HTTPError struct {
-	HTTPStatusCode  int    `json:"httpStatusCode"`
-	Msg             string `json:"msg"`
-	InternalErrCode int    `json:"internalErrCode"`
+	HTTPStatusCode  int          `json:"httpStatusCode"`
+	Msg             string       `json:"msg"`
+	InternalErrCode int          `json:"internalErrCode"`
+	Errors          []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError - describes why a field of the request is invalid.
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"` // machine-readable reason, e.g. required, min, max, email, type, unknown
+	Msg    string `json:"msg"`
+}
+
+// RequestError - error of the request decoding or validation.
+type RequestError struct {
+	Status int
+	Msg    string
+	Fields []FieldError
+}
+
+func (e *RequestError) Error() string {
+	if len(e.Fields) == 0 {
+		return e.Msg
+	}
+
+	fields := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		fields = append(fields, f.Field+": "+f.Msg)
+	}
+	return e.Msg + ": " + strings.Join(fields, ", ")
+}
+
+// SplitList returns elements of comma separated list, e.g. header, query parameter or config value,
+// trimmed and without the empty ones.
+func SplitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
 }
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mateuszdyminski/go-template/redact"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type createUserReq struct {
+	Name    string   `json:"name" validate:"required,min=3,max=10"`
+	Email   string   `json:"email" validate:"required,email"`
+	Role    string   `json:"role" validate:"oneof=admin user"`
+	Age     int      `json:"age" validate:"min=18"`
+	Website string   `json:"website,omitempty" validate:"url"`
+	Tags    []string `json:"tags" validate:"max=2"`
+	Address *struct {
+		City string `json:"city" validate:"required"`
+	} `json:"address"`
+}
+
+func jsonRequest(body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	return r
+}
+
+func Test_ReadJSON_ShouldDecodeValidBody(t *testing.T) {
+	// given
+	r := jsonRequest(`{"name":"john","email":"john@example.com","role":"admin","age":30,"address":{"city":"Gdansk"}}`)
+	var req createUserReq
+
+	// when
+	err := ReadJSON(httptest.NewRecorder(), r, &req)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "john", req.Name)
+	assert.Equal(t, "Gdansk", req.Address.City)
+}
+
+func Test_ReadJSON_ShouldReturnStatusForInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{"wrong content type", httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`)), http.StatusUnsupportedMediaType},
+		{"empty body", jsonRequest(``), http.StatusBadRequest},
+		{"malformed JSON", jsonRequest(`{"name":`), http.StatusBadRequest},
+		{"unknown field", jsonRequest(`{"nick":"john"}`), http.StatusBadRequest},
+		{"wrong type", jsonRequest(`{"age":"old"}`), http.StatusBadRequest},
+		{"trailing data", jsonRequest(`{"name":"john"} {}`), http.StatusBadRequest},
+		{"too large", jsonRequest(`{"name":"` + strings.Repeat("a", DefaultMaxBodyBytes) + `"}`), http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// when
+			err := ReadJSON(httptest.NewRecorder(), tt.req, &createUserReq{})
+
+			// then
+			if assert.IsType(t, &RequestError{}, err) {
+				assert.Equal(t, tt.status, err.(*RequestError).Status)
+			}
+		})
+	}
+}
+
+func Test_ReadJSON_ShouldListAllInvalidFields(t *testing.T) {
+	// given
+	r := jsonRequest(`{"name":"jo","email":"john","role":"root","age":17,"website":"example.com","tags":["a","b","c"],"address":{}}`)
+	w := httptest.NewRecorder()
+
+	// when
+	err := ReadJSON(w, r, &createUserReq{})
+	WriteErrJSON(zap.NewNop().Sugar(), w, r, err, http.StatusBadRequest)
+
+	// then
+	var resp HTTPError
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.HTTPStatusCode)
+	assert.Equal(t, []FieldError{
+		{Field: "name", Reason: "min", Msg: "length must be at least 3"},
+		{Field: "email", Reason: "email", Msg: "must be a valid email address"},
+		{Field: "role", Reason: "oneof", Msg: "must be one of: admin, user"},
+		{Field: "age", Reason: "min", Msg: "value must be at least 18"},
+		{Field: "website", Reason: "url", Msg: "must be an absolute URL"},
+		{Field: "tags", Reason: "max", Msg: "length must be at most 2"},
+		{Field: "address.city", Reason: "required", Msg: "is required"},
+	}, resp.Errors)
+}
+
+func Test_Validate_ShouldValidateZeroValues(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		errs []FieldError
+	}{
+		{name: "zero number", body: `{"name":"john","email":"john@example.com","role":"admin","age":0}`,
+			errs: []FieldError{{Field: "age", Reason: "min", Msg: "value must be at least 18"}}},
+		{name: "absent number", body: `{"name":"john","email":"john@example.com","role":"admin"}`,
+			errs: []FieldError{{Field: "age", Reason: "min", Msg: "value must be at least 18"}}},
+		{name: "empty string", body: `{"name":"john","email":"john@example.com","role":"","age":18}`,
+			errs: []FieldError{{Field: "role", Reason: "oneof", Msg: "must be one of: admin, user"}}},
+		{name: "empty omitempty string", body: `{"name":"john","email":"john@example.com","role":"user","age":18,"website":""}`},
+		{name: "absent slice and pointer", body: `{"name":"john","email":"john@example.com","role":"user","age":18}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			var req createUserReq
+			assert.NoError(t, json.Unmarshal([]byte(tt.body), &req))
+
+			// when
+			errs := Validate(&req)
+
+			// then
+			assert.Equal(t, tt.errs, errs)
+		})
+	}
+}
+
+func Test_WriteErrJSON_ShouldNotExposeSecrets(t *testing.T) {
+	// given
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/ready", nil)
+	err := errors.Wrap(errors.New("dial postgres://app:s3cr3t@db:5432/app: connection refused"), "can't ping DB")
+
+	// when
+	WriteErrJSON(zap.NewNop().Sugar(), w, r, err, http.StatusServiceUnavailable)
+
+	// then
+	var resp HTTPError
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "can't ping DB: dial postgres://app:[REDACTED]@db:5432/app: connection refused", resp.Msg)
+}
+
+func Test_WriteErrJSON_ShouldUseConfiguredRedactor(t *testing.T) {
+	// given
+	logger := NewContextLogger(zap.NewNop(), redact.New(redact.Config{Fields: []string{"pin"}}))
+	h := logger.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteErrJSON(zap.NewNop().Sugar(), w, r, errors.New("card rejected: pin=1234"), http.StatusBadRequest)
+	}))
+	w := httptest.NewRecorder()
+
+	// when
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/payments", nil))
+
+	// then
+	var resp HTTPError
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "card rejected: pin=[REDACTED]", resp.Msg)
+}
@@ -0,0 +1,250 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/mateuszdyminski/go-template/app"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var (
+	idempotencyKey     = http.CanonicalHeaderKey("Idempotency-Key")
+	idempotentReplayed = http.CanonicalHeaderKey("Idempotent-Replayed")
+)
+
+const (
+	// max length of the Idempotency-Key header
+	maxIdempotencyKeyLen = 255
+	// timeout of storing the response - it's stored even when the client is gone
+	idempotencyStoreTimeout = 5 * time.Second
+)
+
+// IdempotencyConfig - idempotency middleware settings.
+type IdempotencyConfig struct {
+	// TTL - how long the response is replayed to retries with the same key
+	TTL time.Duration
+	// LockTimeout - request in progress for longer is considered lost and its key can be used again
+	LockTimeout time.Duration
+	// MaxBodyBytes - max size of the request body and of the stored response body,
+	// larger responses are stored without the body and can't be replayed
+	MaxBodyBytes int
+}
+
+// Idempotency makes unsafe requests with Idempotency-Key header safe to retry. The first request
+// with the key is processed and its response is stored, retries get the stored response replayed.
+// Retries while the first request is in progress get 409, reusing the key with a different request
+// gets 422. Every response is stored, 5xx included - the side effects might have happened already.
+// Responses larger than MaxBodyBytes are stored without the body and retries get 409. The key is
+// released only when the handler panics. Keys are scoped to the principal, anonymous requests to the client IP.
+type Idempotency struct {
+	l        *zap.SugaredLogger
+	cfg      IdempotencyConfig
+	store    app.IdempotencyStore
+	requests *prometheus.CounterVec
+	now      func() time.Time
+}
+
+func NewIdempotency(l *zap.Logger, reg prometheus.Registerer, cfg IdempotencyConfig, store app.IdempotencyStore) *Idempotency {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "http",
+		Name:      "idempotency_requests_total",
+		Help:      "The total number of requests with Idempotency-Key header by result: stored, replayed, not_replayable, in_progress, mismatch, released or error.",
+	}, []string{"result"})
+	reg.MustRegister(requests)
+
+	return &Idempotency{l: l.Sugar(), cfg: cfg, store: store, requests: requests, now: time.Now}
+}
+
+func (m *Idempotency) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKey)
+		if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			WriteErrJSON(m.l, w, r, &RequestError{Status: http.StatusBadRequest, Msg: "Idempotency-Key header is too long"}, http.StatusBadRequest)
+			return
+		}
+
+		fingerprint, err := m.fingerprint(r)
+		if err != nil {
+			WriteErrJSON(m.l, w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		now := m.now().UTC()
+		rec := app.IdempotencyRecord{
+			Key:         scopedIdempotencyKey(r, key),
+			Fingerprint: fingerprint,
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(m.cfg.TTL),
+		}
+		began, existing, err := m.store.Begin(r.Context(), rec, now.Add(-m.cfg.LockTimeout))
+		if err != nil {
+			m.requests.WithLabelValues("error").Inc()
+			WriteErrJSON(m.l, w, r, err, http.StatusServiceUnavailable)
+			return
+		}
+		if !began {
+			m.replay(w, r, existing, fingerprint)
+			return
+		}
+
+		m.serve(w, r, next, rec)
+	})
+}
+
+// replay writes stored response of the key, unless the request differs or is still in progress.
+func (m *Idempotency) replay(w http.ResponseWriter, r *http.Request, rec *app.IdempotencyRecord, fingerprint string) {
+	switch {
+	case rec.Fingerprint != fingerprint:
+		m.requests.WithLabelValues("mismatch").Inc()
+		WriteErrJSON(m.l, w, r, &RequestError{
+			Status: http.StatusUnprocessableEntity,
+			Msg:    "Idempotency-Key was already used with a different request",
+		}, http.StatusUnprocessableEntity)
+	case !rec.Completed:
+		m.requests.WithLabelValues("in_progress").Inc()
+		w.Header().Set(retryAfter, "1")
+		WriteErrJSON(m.l, w, r, &RequestError{
+			Status: http.StatusConflict,
+			Msg:    "request with the same Idempotency-Key is in progress",
+		}, http.StatusConflict)
+	case rec.BodyOmitted:
+		m.requests.WithLabelValues("not_replayable").Inc()
+		WriteErrJSON(m.l, w, r, &RequestError{
+			Status: http.StatusConflict,
+			Msg:    fmt.Sprintf("request with the same Idempotency-Key was processed with status %d, its response is too large to be replayed", rec.Status),
+		}, http.StatusConflict)
+	default:
+		m.requests.WithLabelValues("replayed").Inc()
+		for k, v := range rec.Header {
+			w.Header()[k] = v
+		}
+		w.Header().Set(idempotentReplayed, "true")
+		w.WriteHeader(rec.Status)
+		w.Write(rec.Body)
+	}
+}
+
+// serve processes the first request of the key and stores its response.
+func (m *Idempotency) serve(w http.ResponseWriter, r *http.Request, next http.Handler, rec app.IdempotencyRecord) {
+	ctx, cancel := context.WithTimeout(context.Background(), idempotencyStoreTimeout)
+	defer cancel()
+
+	stored := false
+	defer func() {
+		// release the key when the response isn't stored, e.g. the handler panicked
+		if !stored {
+			m.requests.WithLabelValues("released").Inc()
+			if err := m.store.Release(ctx, rec.Key); err != nil {
+				LoggerFrom(r.Context()).Sugar().Warnw("can't release idempotency key", "err", err)
+			}
+		}
+	}()
+
+	// headers set by the previous middlewares are set again on replay
+	before := w.Header().Clone()
+	body := &captureBuffer{max: m.cfg.MaxBodyBytes}
+	interceptor := &interceptor{ResponseWriter: w, statusCode: http.StatusOK, body: body}
+
+	next.ServeHTTP(interceptor, r)
+
+	rec.Completed = true
+	rec.Status = interceptor.statusCode
+	rec.Header = make(map[string][]string)
+	for k, v := range w.Header() {
+		if !sameValues(before[k], v) {
+			rec.Header[k] = v
+		}
+	}
+	if body.truncated {
+		rec.BodyOmitted = true
+	} else {
+		rec.Body = body.buf.Bytes()
+	}
+
+	if err := m.store.Complete(ctx, rec); err != nil {
+		m.requests.WithLabelValues("error").Inc()
+		LoggerFrom(r.Context()).Sugar().Warnw("can't store idempotent response", "err", err)
+		return
+	}
+	stored = true
+	m.requests.WithLabelValues("stored").Inc()
+}
+
+// fingerprint hashes method, URI and body of the request, the body is buffered for the handler.
+func (m *Idempotency) fingerprint(r *http.Request) (string, error) {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, int64(m.cfg.MaxBodyBytes)+1))
+		r.Body.Close()
+		if err != nil {
+			return "", errors.Wrap(err, "can't read request body")
+		}
+		if len(body) > m.cfg.MaxBodyBytes {
+			return "", &RequestError{Status: http.StatusRequestEntityTooLarge, Msg: "request body is too large for idempotent request"}
+		}
+		h.Write(body)
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Purge deletes expired keys every interval until ctx is done.
+func (m *Idempotency) Purge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := m.store.DeleteExpired(ctx, m.now().UTC())
+			if err != nil {
+				m.l.Warnw("can't delete expired idempotency keys", "err", err)
+				continue
+			}
+			m.l.Debugw("expired idempotency keys deleted", "count", n)
+		}
+	}
+}
+
+// scopedIdempotencyKey returns key unique per principal, so clients can't replay responses of others.
+func scopedIdempotencyKey(r *http.Request, key string) string {
+	scope := "ip:" + getRealIP(r)
+	if p := PrincipalFrom(r.Context()); p != nil {
+		scope = p.Type + ":" + p.ID
+	}
+
+	sum := sha256.Sum256([]byte(scope + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+func sameValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
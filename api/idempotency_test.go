@@ -0,0 +1,247 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mateuszdyminski/go-template/app"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]app.IdempotencyRecord
+}
+
+func newFakeIdempotencyStore() *fakeIdempotencyStore {
+	return &fakeIdempotencyStore{records: make(map[string]app.IdempotencyRecord)}
+}
+
+func (f *fakeIdempotencyStore) Begin(ctx context.Context, rec app.IdempotencyRecord, staleBefore time.Time) (bool, *app.IdempotencyRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if existing, ok := f.records[rec.Key]; ok && existing.ExpiresAt.After(rec.CreatedAt) &&
+		(existing.Completed || !existing.CreatedAt.Before(staleBefore)) {
+		return false, &existing, nil
+	}
+	f.records[rec.Key] = rec
+	return true, nil, nil
+}
+
+func (f *fakeIdempotencyStore) Complete(ctx context.Context, rec app.IdempotencyRecord) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.records[rec.Key] = rec
+	return nil
+}
+
+func (f *fakeIdempotencyStore) Release(ctx context.Context, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.records, key)
+	return nil
+}
+
+func (f *fakeIdempotencyStore) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	return 0, nil
+}
+
+func newTestIdempotency(store app.IdempotencyStore) *Idempotency {
+	return NewIdempotency(zap.NewNop(), prometheus.NewRegistry(), IdempotencyConfig{TTL: time.Hour, LockTimeout: time.Minute, MaxBodyBytes: 1024}, store)
+}
+
+func newIdempotencyRouter(m *Idempotency, handler http.HandlerFunc) *mux.Router {
+	r := mux.NewRouter()
+	r.Use(m.Handler)
+	r.HandleFunc("/api/orders", handler).Methods(http.MethodPost)
+	return r
+}
+
+func postOrder(router http.Handler, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/orders", strings.NewReader(body))
+	req.Header.Set("Idempotency-Key", key)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func Test_Idempotency_ShouldReplayStoredResponse(t *testing.T) {
+	// given
+	calls := 0
+	router := newIdempotencyRouter(newTestIdempotency(newFakeIdempotencyStore()), func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Location", "/api/orders/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	})
+
+	// when
+	first := postOrder(router, "key-1", `{"item":"book"}`)
+	retry := postOrder(router, "key-1", `{"item":"book"}`)
+
+	// then
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, `{"item":"book"}`, retry.Body.String())
+	assert.Equal(t, "/api/orders/1", retry.Header().Get("Location"))
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+}
+
+func Test_Idempotency_ShouldRejectKeyReusedWithDifferentRequest(t *testing.T) {
+	// given
+	router := newIdempotencyRouter(newTestIdempotency(newFakeIdempotencyStore()), func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	postOrder(router, "key-1", `{"item":"book"}`)
+
+	// when
+	w := postOrder(router, "key-1", `{"item":"pen"}`)
+
+	// then
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func Test_Idempotency_ShouldRejectConcurrentDuplicate(t *testing.T) {
+	// given
+	inHandler := make(chan struct{})
+	release := make(chan struct{})
+	router := newIdempotencyRouter(newTestIdempotency(newFakeIdempotencyStore()), func(w http.ResponseWriter, r *http.Request) {
+		close(inHandler)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- postOrder(router, "key-1", `{}`) }()
+	<-inHandler
+
+	// when
+	w := postOrder(router, "key-1", `{}`)
+	close(release)
+
+	// then
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusCreated, (<-done).Code)
+}
+
+func Test_Idempotency_ShouldReplayServerError(t *testing.T) {
+	// given
+	calls := 0
+	router := newIdempotencyRouter(newTestIdempotency(newFakeIdempotencyStore()), func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	postOrder(router, "key-1", `{}`)
+
+	// when
+	w := postOrder(router, "key-1", `{}`)
+
+	// then
+	assert.Equal(t, 1, calls, "side effects might have happened, the request shouldn't run again")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+}
+
+func Test_Idempotency_ShouldRejectRetryOfTooLargeResponse(t *testing.T) {
+	// given
+	calls := 0
+	router := newIdempotencyRouter(newTestIdempotency(newFakeIdempotencyStore()), func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(strings.Repeat("x", 2048)))
+	})
+	first := postOrder(router, "key-1", `{}`)
+
+	// when
+	w := postOrder(router, "key-1", `{}`)
+
+	// then
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, 2048, first.Body.Len(), "first response shouldn't be truncated")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "processed with status 201")
+}
+
+func Test_Idempotency_ShouldReleaseKeyWhenHandlerPanics(t *testing.T) {
+	// given
+	calls := 0
+	router := newIdempotencyRouter(newTestIdempotency(newFakeIdempotencyStore()), func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			panic("boom")
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	assert.Panics(t, func() { postOrder(router, "key-1", `{}`) })
+
+	// when
+	w := postOrder(router, "key-1", `{}`)
+
+	// then
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func Test_Idempotency_ShouldScopeKeysToPrincipal(t *testing.T) {
+	// given
+	calls := 0
+	m := newTestIdempotency(newFakeIdempotencyStore())
+	r := mux.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p := &Principal{ID: r.Header.Get("X-Test-Principal"), Type: PrincipalAPIKey}
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+		})
+	})
+	r.Use(m.Handler)
+	r.HandleFunc("/api/orders", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	// when
+	for _, principal := range []string{"alice", "bob"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/orders", strings.NewReader(`{}`))
+		req.Header.Set("Idempotency-Key", "key-1")
+		req.Header.Set("X-Test-Principal", principal)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// then
+	assert.Equal(t, 2, calls)
+}
+
+func Test_Idempotency_ShouldRejectTooLargeBody(t *testing.T) {
+	// given
+	router := newIdempotencyRouter(newTestIdempotency(newFakeIdempotencyStore()), func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	// when
+	w := postOrder(router, "key-1", strings.Repeat("a", 2048))
+
+	// then
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	var resp HTTPError
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.HTTPStatusCode)
+}
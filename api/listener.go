@@ -0,0 +1,208 @@
+package api
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// response written to connections rejected because of the connection caps
+const rejectResponse = "HTTP/1.1 503 Service Unavailable\r\nConnection: close\r\nContent-Length: 0\r\n\r\n"
+
+// ListenerLimits - caps of concurrent connections, 0 means unlimited.
+type ListenerLimits struct {
+	MaxConns      int
+	MaxConnsPerIP int
+}
+
+// ListenerMetrics - connection metrics shared by all limited listeners.
+type ListenerMetrics struct {
+	Open     *prometheus.GaugeVec
+	Rejected *prometheus.CounterVec
+	Timeouts *prometheus.CounterVec
+}
+
+func NewListenerMetrics(reg prometheus.Registerer) *ListenerMetrics {
+	open := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "http",
+		Name:      "open_connections",
+		Help:      "The number of open connections.",
+	}, []string{"listener"})
+	rejected := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "http",
+		Name:      "rejected_connections_total",
+		Help:      "The total number of connections rejected because of connection caps.",
+	}, []string{"listener", "reason"})
+	// read timeouts include header, body and keep-alive idle timeouts
+	timeouts := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "http",
+		Name:      "connection_timeouts_total",
+		Help:      "The total number of connection reads and writes which hit the deadline.",
+	}, []string{"listener", "op"})
+
+	reg.MustRegister(open, rejected, timeouts)
+
+	return &ListenerMetrics{
+		Open:     open,
+		Rejected: rejected,
+		Timeouts: timeouts,
+	}
+}
+
+// NewLimitListener returns listener which rejects connections over the limits with 503 response
+// and reports connection timeouts. The name is used as metrics label.
+func NewLimitListener(ln net.Listener, name string, limits ListenerLimits, m *ListenerMetrics) net.Listener {
+	return &limitListener{
+		Listener: ln,
+		name:     name,
+		limits:   limits,
+		m:        m,
+		perIP:    make(map[string]int),
+	}
+}
+
+type limitListener struct {
+	net.Listener
+	name   string
+	limits ListenerLimits
+	m      *ListenerMetrics
+
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip := hostIP(c.RemoteAddr())
+		if reason := l.acquire(ip); reason != "" {
+			l.m.Rejected.WithLabelValues(l.name, reason).Inc()
+			go reject(c)
+			continue
+		}
+
+		l.m.Open.WithLabelValues(l.name).Inc()
+		return &limitConn{Conn: c, l: l, ip: ip}, nil
+	}
+}
+
+// acquire reserves connection slot for the ip. It returns rejection reason when there is no free slot.
+func (l *limitListener) acquire(ip string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limits.MaxConns > 0 && l.total >= l.limits.MaxConns {
+		return "max_conns"
+	}
+	if l.limits.MaxConnsPerIP > 0 && l.perIP[ip] >= l.limits.MaxConnsPerIP {
+		return "max_conns_per_ip"
+	}
+
+	l.total++
+	l.perIP[ip]++
+
+	return ""
+}
+
+func (l *limitListener) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+type limitConn struct {
+	net.Conn
+	l    *limitListener
+	ip   string
+	once sync.Once
+
+	// set when the deadline was already in the past when it was set - net/http aborts
+	// pending reads this way after every request, it isn't a timeout of the client
+	readAborted  int32
+	writeAborted int32
+}
+
+func (c *limitConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if isTimeout(err) && atomic.LoadInt32(&c.readAborted) == 0 {
+		c.l.m.Timeouts.WithLabelValues(c.l.name, "read").Inc()
+	}
+	return n, err
+}
+
+func (c *limitConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if isTimeout(err) && atomic.LoadInt32(&c.writeAborted) == 0 {
+		c.l.m.Timeouts.WithLabelValues(c.l.name, "write").Inc()
+	}
+	return n, err
+}
+
+func (c *limitConn) SetDeadline(t time.Time) error {
+	aborted := abortDeadline(t)
+	atomic.StoreInt32(&c.readAborted, aborted)
+	atomic.StoreInt32(&c.writeAborted, aborted)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *limitConn) SetReadDeadline(t time.Time) error {
+	atomic.StoreInt32(&c.readAborted, abortDeadline(t))
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *limitConn) SetWriteDeadline(t time.Time) error {
+	atomic.StoreInt32(&c.writeAborted, abortDeadline(t))
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *limitConn) Close() error {
+	c.once.Do(func() {
+		c.l.release(c.ip)
+		c.l.m.Open.WithLabelValues(c.l.name).Dec()
+	})
+	return c.Conn.Close()
+}
+
+// reject tells the client the server is busy. Slow clients are not waited for.
+func reject(c net.Conn) {
+	c.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	c.Write([]byte(rejectResponse))
+	c.Close()
+}
+
+// abortDeadline returns 1 when the deadline is in the past, so it interrupts the pending operation.
+func abortDeadline(t time.Time) int32 {
+	if !t.IsZero() && t.Before(time.Now()) {
+		return 1
+	}
+	return 0
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+func hostIP(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LimitListener_ShouldRejectConnectionsOverPerIPLimit(t *testing.T) {
+	// given
+	base, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can't listen: %s", err)
+	}
+	m := NewListenerMetrics(prometheus.NewRegistry())
+	ln := NewLimitListener(base, "test", ListenerLimits{MaxConnsPerIP: 1}, m)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	// when
+	first, err := net.Dial("tcp", base.Addr().String())
+	if err != nil {
+		t.Fatalf("can't dial: %s", err)
+	}
+	defer first.Close()
+	serverConn := <-accepted
+
+	second, err := net.Dial("tcp", base.Addr().String())
+	if err != nil {
+		t.Fatalf("can't dial: %s", err)
+	}
+	defer second.Close()
+	resp, _ := ioutil.ReadAll(second)
+
+	// then
+	assert.Equal(t, rejectResponse, string(resp))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.Rejected.WithLabelValues("test", "max_conns_per_ip")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.Open.WithLabelValues("test")))
+
+	// when slot is released
+	serverConn.Close()
+	third, err := net.Dial("tcp", base.Addr().String())
+	if err != nil {
+		t.Fatalf("can't dial: %s", err)
+	}
+	defer third.Close()
+
+	// then
+	c := <-accepted
+	defer c.Close()
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.Open.WithLabelValues("test")))
+}
+
+func Test_LimitListener_ShouldCountOnlyDeadlineTimeouts(t *testing.T) {
+	// given
+	base, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can't listen: %s", err)
+	}
+	m := NewListenerMetrics(prometheus.NewRegistry())
+	srv := &http.Server{
+		Handler:     http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		ReadTimeout: time.Second,
+		IdleTimeout: 100 * time.Millisecond,
+	}
+	go srv.Serve(NewLimitListener(base, "test", ListenerLimits{}, m))
+	defer srv.Close()
+	client := &http.Client{Transport: &http.Transport{}}
+	timeouts := m.Timeouts.WithLabelValues("test", "read")
+
+	// when requests are served on a keep-alive connection
+	for i := 0; i < 5; i++ {
+		resp, err := client.Get("http://" + base.Addr().String())
+		if err != nil {
+			t.Fatalf("can't send request: %s", err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	// then
+	assert.Equal(t, float64(0), testutil.ToFloat64(timeouts), "aborted background reads aren't timeouts")
+
+	// when the connection stays idle longer than the idle timeout
+	for i := 0; i < 100 && testutil.ToFloat64(timeouts) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// then
+	assert.Equal(t, float64(1), testutil.ToFloat64(timeouts))
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/mateuszdyminski/go-template/app"
+	"github.com/mateuszdyminski/go-template/redact"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// ContextLogger stores logger enriched with request ID, route, client IP and trace ID in the request
+// context, so every log line of the request can be correlated. Authentication adds the principal.
+// The configured redactor is stored too, so error responses are redacted the same way as the logs.
+// Register it after the request ID and client IP middlewares.
+type ContextLogger struct {
+	l *zap.Logger
+	r *redact.Redactor
+}
+
+func NewContextLogger(l *zap.Logger, r *redact.Redactor) *ContextLogger {
+	return &ContextLogger{l: l, r: r}
+}
+
+func (m *ContextLogger) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fields := []zap.Field{zap.String("requestId", GetReqID(r.Context()))}
+		if route := mux.CurrentRoute(r); route != nil {
+			if tpl, err := route.GetPathTemplate(); err == nil {
+				fields = append(fields, zap.String("route", tpl))
+			}
+		}
+		fields = append(fields, zap.String("clientIp", getRealIP(r)))
+		if traceID, spanID := parseTraceparent(r.Header.Get(traceparent)); traceID != "" {
+			fields = append(fields, zap.String("traceId", traceID), zap.String("spanId", spanID))
+		}
+
+		ctx := app.WithLogger(r.Context(), m.l)
+		ctx = context.WithValue(ctx, contextLoggerKey, m)
+		next.ServeHTTP(w, r.WithContext(withLogFields(ctx, fields...)))
+	})
+}
+
+// LoggerFrom returns logger of the request stored by ContextLogger, handlers, middlewares and repositories
+// log with it. Outside of ContextLogger it's the global logger with request ID, when there's one.
+func LoggerFrom(ctx context.Context) *zap.Logger {
+	l := app.LoggerFrom(ctx)
+	if _, ok := ctx.Value(contextLoggerKey).(*ContextLogger); !ok {
+		if id := GetReqID(ctx); id != "" {
+			return l.With(zap.String("requestId", id))
+		}
+	}
+	return l
+}
+
+// redactString masks secrets in s with the redactor of ContextLogger, the default one when there's none.
+func redactString(ctx context.Context, s string) string {
+	if m, ok := ctx.Value(contextLoggerKey).(*ContextLogger); ok && m.r != nil {
+		return m.r.String(s)
+	}
+	return redact.String(s)
+}
+
+// withLogFields returns context with fields added to the context logger.
+func withLogFields(ctx context.Context, fields ...zap.Field) context.Context {
+	return app.WithLogger(ctx, app.LoggerFrom(ctx).With(fields...))
+}
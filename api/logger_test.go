@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mateuszdyminski/go-template/redact"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func Test_ContextLogger_ShouldEnrichLoggerWithRequestMetadata(t *testing.T) {
+	// given
+	core, logs := observer.New(zap.InfoLevel)
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	r := mux.NewRouter()
+	r.Use(RequestIDMiddleware, NewClientIPMiddleware(trusted).Handler, NewContextLogger(zap.New(core), redact.New(redact.Config{})).Handler)
+	r.HandleFunc("/api/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithPrincipal(r.Context(), &Principal{ID: "u1", Type: PrincipalAPIKey})
+		LoggerFrom(ctx).Info("handler")
+		// repositories get the request context
+		func(ctx context.Context) { LoggerFrom(ctx).Info("repository") }(ctx)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/items/42", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Request-Id", "req-1")
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	// when
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	// then
+	require.Equal(t, 2, logs.Len())
+	for _, entry := range logs.All() {
+		assert.Equal(t, map[string]interface{}{
+			"requestId":     "req-1",
+			"route":         "/api/items/{id}",
+			"clientIp":      "198.51.100.7",
+			"traceId":       "4bf92f3577b34da6a3ce929d0e0e4736",
+			"spanId":        "00f067aa0ba902b7",
+			"principal":     "u1",
+			"principalType": "apikey",
+		}, entry.ContextMap(), entry.Message)
+	}
+}
+
+func Test_LoggerFrom_ShouldAddRequestIDWithoutContextLogger(t *testing.T) {
+	// given
+	core, logs := observer.New(zap.InfoLevel)
+	defer zap.ReplaceGlobals(zap.New(core))()
+
+	// when
+	RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		LoggerFrom(r.Context()).Info("component")
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/drain", nil))
+
+	// then
+	require.Equal(t, 1, logs.Len())
+	assert.Len(t, logs.All()[0].ContextMap(), 1)
+	assert.NotEmpty(t, logs.All()[0].ContextMap()["requestId"])
+}
@@ -2,7 +2,9 @@ package api
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"regexp"
@@ -14,12 +16,54 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+type contextKey int
+
+const (
+	// requestStateKey holds *requestState of the request
+	requestStateKey contextKey = iota
+	// principalKey holds *Principal of the authenticated request
+	principalKey
+	// claimsKey holds *auth.Claims of the request authenticated with JWT
+	claimsKey
+	// cspNonceKey holds Content-Security-Policy nonce of the request
+	cspNonceKey
+	// clientIPKey holds client address resolved by ClientIPMiddleware
+	clientIPKey
+	// accessLogKey holds *accessLogState of the request
+	accessLogKey
+	// auditKey holds *auditState of the audited request
+	auditKey
+	// routeConfigKey holds *RouteConfig of the matched route
+	routeConfigKey
+	// contextLoggerKey holds *ContextLogger which enriched logger of the request
+	contextLoggerKey
+)
+
+// requestState - flags set by inner middlewares, read by the metrics middleware.
+type requestState struct {
+	admittedAt int64 // unix nanoseconds, 0 when the request wasn't admitted by ConcurrencyLimiter
+	timedOut   int32
+}
+
+func getRequestState(ctx context.Context) *requestState {
+	s, _ := ctx.Value(requestStateKey).(*requestState)
+	return s
+}
+
+// LatencyObserver receives latency of every request seen by the metrics middleware.
+type LatencyObserver interface {
+	ObserveLatency(r *http.Request, status int, took time.Duration)
+}
+
 type MetricsMiddleware struct {
 	Histogram *prometheus.HistogramVec
 	Counter   *prometheus.CounterVec
+
+	observers []LatencyObserver
 }
 
-func NewMetricsMiddleware() *MetricsMiddleware {
+// NewMetricsMiddleware returns middleware measuring requests, its metrics are registered with reg.
+func NewMetricsMiddleware(reg prometheus.Registerer) *MetricsMiddleware {
 	// used for monitoring and alerting (RED method)
 	histogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Subsystem: "http",
@@ -36,8 +80,7 @@ func NewMetricsMiddleware() *MetricsMiddleware {
 		[]string{"status"},
 	)
 
-	prometheus.MustRegister(histogram)
-	prometheus.MustRegister(counter)
+	reg.MustRegister(histogram, counter)
 
 	return &MetricsMiddleware{
 		Histogram: histogram,
@@ -57,16 +100,30 @@ func (p *MetricsMiddleware) Handler(next http.Handler) http.Handler {
 		begin := time.Now()
 		interceptor := &interceptor{ResponseWriter: w, statusCode: http.StatusOK}
 		path := p.getRouteName(r)
+		r = r.WithContext(context.WithValue(r.Context(), requestStateKey, &requestState{}))
 		next.ServeHTTP(interceptor, r)
 		var (
 			status = strconv.Itoa(interceptor.statusCode)
 			took   = time.Since(begin)
 		)
+		// timeouts are reported separately from errors returned by handlers
+		if timedOut(r.Context()) {
+			status = "timeout"
+		}
 		p.Histogram.WithLabelValues(r.Method, path, status).Observe(took.Seconds())
 		p.Counter.WithLabelValues(status).Inc()
+
+		for _, o := range p.observers {
+			o.ObserveLatency(r, interceptor.statusCode, took)
+		}
 	})
 }
 
+// AddObserver registers observer notified about latency of every request, call it before the server starts.
+func (p *MetricsMiddleware) AddObserver(o LatencyObserver) {
+	p.observers = append(p.observers, o)
+}
+
 // converts gorilla mux routes from '/api/delay/{wait}' to 'api_delay_wait'
 func (p *MetricsMiddleware) getRouteName(r *http.Request) string {
 	if mux.CurrentRoute(r) != nil {
@@ -79,9 +136,13 @@ func (p *MetricsMiddleware) getRouteName(r *http.Request) string {
 			}
 		}
 	}
-	return urlToLabel(r.RequestURI)
+	// requests without route are answered by the router fallback handlers, their path must not become a label
+	return unmatchedLabel
 }
 
+// unmatchedLabel - path label of the requests which don't match any route.
+const unmatchedLabel = "unmatched"
+
 var invalidChars = regexp.MustCompile(`[^a-zA-Z0-9]+`)
 
 // converts a URL path to a string compatible with Prometheus label value.
@@ -98,6 +159,8 @@ type interceptor struct {
 	http.ResponseWriter
 	statusCode int
 	recorded   bool
+	bytes      int64
+	body       io.Writer // receives copy of the response body when set
 }
 
 func (i *interceptor) WriteHeader(code int) {
@@ -108,6 +171,15 @@ func (i *interceptor) WriteHeader(code int) {
 	i.ResponseWriter.WriteHeader(code)
 }
 
+func (i *interceptor) Write(b []byte) (int, error) {
+	n, err := i.ResponseWriter.Write(b)
+	i.bytes += int64(n)
+	if i.body != nil {
+		i.body.Write(b[:n])
+	}
+	return n, err
+}
+
 func (i *interceptor) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	hj, ok := i.ResponseWriter.(http.Hijacker)
 	if !ok {
@@ -0,0 +1,333 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mateuszdyminski/go-template/app"
+
+	"github.com/pkg/errors"
+)
+
+// reserved query parameters of the paginated requests, other parameters are filters
+const (
+	cursorParam = "cursor"
+	limitParam  = "limit"
+	sortParam   = "sort"
+)
+
+// PaginationConfig - pagination settings of a list endpoint.
+type PaginationConfig struct {
+	// DefaultLimit - page size when limit isn't set
+	DefaultLimit int
+	// MaxLimit - max page size
+	MaxLimit int
+	// Sortable - fields clients can sort by, JSON names of the item fields
+	Sortable []string
+	// DefaultSort - order when sort isn't set
+	DefaultSort []app.Sort
+	// Key - unique field appended to the sort, so the order is total, e.g. id
+	Key string
+	// Filterable - fields clients can filter by with their allowed operators
+	Filterable map[string][]string
+	// Secret - HMAC key of the cursors, it must be the same on all replicas
+	Secret []byte
+}
+
+// Paginator parses keyset pagination of list requests and builds pages of the results.
+// Query parameters:
+//
+//	limit=20 - page size
+//	sort=-createdAt,name - sort fields, minus sorts descending
+//	name=bob, createdAt[gte]=2020-01-01T00:00:00Z, status[in]=new,paid - filters
+//	cursor=... - opaque cursor of the next or prev link of the previous page
+type Paginator struct {
+	cfg PaginationConfig
+}
+
+func NewPaginator(cfg PaginationConfig) *Paginator {
+	return &Paginator{cfg: cfg}
+}
+
+// Page - envelope of list responses.
+type Page struct {
+	Items interface{} `json:"items"`
+	// Next - link to the next page, empty on the last page
+	Next string `json:"next,omitempty"`
+	// Prev - link to the previous page, empty on the first page
+	Prev string `json:"prev,omitempty"`
+}
+
+// cursorToken - signed content of the cursor.
+type cursorToken struct {
+	Values   []string `json:"v"`
+	Backward bool     `json:"b,omitempty"`
+	// Query - hash of the sort and filters, the cursor is valid only for the same query
+	Query string `json:"q"`
+}
+
+// Parse returns page request of the query parameters, invalid ones are described by *RequestError.
+func (p *Paginator) Parse(r *http.Request) (app.PageRequest, error) {
+	q := r.URL.Query()
+	req := app.PageRequest{Limit: p.cfg.DefaultLimit}
+
+	var fields []FieldError
+	if v := q.Get(limitParam); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > p.cfg.MaxLimit {
+			fields = append(fields, FieldError{Field: limitParam, Reason: "range", Msg: "must be between 1 and " + strconv.Itoa(p.cfg.MaxLimit)})
+		}
+		req.Limit = limit
+	}
+
+	sortFields, err := p.parseSort(q.Get(sortParam))
+	if err != nil {
+		fields = append(fields, FieldError{Field: sortParam, Reason: "oneof", Msg: err.Error()})
+	}
+	req.Sort = sortFields
+
+	filters, filterErrs := p.parseFilters(q)
+	req.Filters = filters
+	fields = append(fields, filterErrs...)
+
+	if v := q.Get(cursorParam); v != "" && len(fields) == 0 {
+		cursor, err := p.decodeCursor(v, req)
+		if err != nil {
+			fields = append(fields, FieldError{Field: cursorParam, Reason: "format", Msg: err.Error()})
+		}
+		req.Cursor = cursor
+	}
+
+	if len(fields) > 0 {
+		return req, &RequestError{Status: http.StatusUnprocessableEntity, Msg: "invalid query parameters", Fields: fields}
+	}
+	return req, nil
+}
+
+func (p *Paginator) parseSort(v string) ([]app.Sort, error) {
+	var sorts []app.Sort
+	if v == "" {
+		sorts = append(sorts, p.cfg.DefaultSort...)
+	}
+	for _, f := range SplitList(v) {
+		s := app.Sort{Field: strings.TrimPrefix(f, "-"), Desc: strings.HasPrefix(f, "-")}
+		if !contains(p.cfg.Sortable, s.Field) {
+			return nil, errors.Errorf("can't sort by %q, sortable fields: %s", s.Field, strings.Join(p.cfg.Sortable, ", "))
+		}
+		sorts = append(sorts, s)
+	}
+
+	for _, s := range sorts {
+		if s.Field == p.cfg.Key {
+			return sorts, nil
+		}
+	}
+	key := app.Sort{Field: p.cfg.Key}
+	if len(sorts) > 0 {
+		key.Desc = sorts[len(sorts)-1].Desc
+	}
+	return append(sorts, key), nil
+}
+
+// parseFilters parses field=value and field[op]=value parameters, in order of the names.
+func (p *Paginator) parseFilters(q map[string][]string) ([]app.Filter, []FieldError) {
+	names := make([]string, 0, len(q))
+	for name := range q {
+		if name != cursorParam && name != limitParam && name != sortParam {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var (
+		filters []app.Filter
+		fields  []FieldError
+	)
+	for _, name := range names {
+		field, op := name, app.FilterEq
+		if i := strings.IndexByte(name, '['); i > 0 && strings.HasSuffix(name, "]") {
+			field, op = name[:i], name[i+1:len(name)-1]
+		}
+
+		ops, ok := p.cfg.Filterable[field]
+		if !ok {
+			fields = append(fields, FieldError{Field: name, Reason: "unknown", Msg: "unknown filter field"})
+			continue
+		}
+		if !contains(ops, op) {
+			fields = append(fields, FieldError{Field: name, Reason: "oneof", Msg: "operator must be one of: " + strings.Join(ops, ", ")})
+			continue
+		}
+
+		for _, v := range q[name] {
+			values := []string{v}
+			if op == app.FilterIn {
+				values = SplitList(v)
+			}
+			filters = append(filters, app.Filter{Field: field, Op: op, Values: values})
+		}
+	}
+
+	return filters, fields
+}
+
+// Page returns envelope of the items fetched with the page request. Items must be a slice of structs
+// with the sort fields, including the item fetched over the limit, in order of the query - see
+// postgres.PageQuery.
+func (p *Paginator) Page(r *http.Request, req app.PageRequest, items interface{}) (*Page, error) {
+	v := reflect.ValueOf(items)
+	if v.Kind() != reflect.Slice {
+		return nil, errors.Errorf("page items must be a slice, got %T", items)
+	}
+	if v.IsNil() {
+		v = reflect.MakeSlice(v.Type(), 0, 0)
+	}
+
+	more := v.Len() > req.Limit
+	if more {
+		v = v.Slice(0, req.Limit)
+	}
+	backward := req.Cursor != nil && req.Cursor.Backward
+	if backward {
+		swap := reflect.Swapper(v.Interface())
+		for i, j := 0, v.Len()-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+	}
+
+	page := &Page{Items: v.Interface()}
+	if v.Len() == 0 {
+		return page, nil
+	}
+
+	// forward page has the next one when there're more items and the previous one when it isn't
+	// the first page, backward page the other way round
+	hasNext, hasPrev := more, req.Cursor != nil
+	if backward {
+		hasNext, hasPrev = true, more
+	}
+	query := queryHash(req)
+
+	if hasNext {
+		values, err := cursorValues(v.Index(v.Len()-1), req.Sort)
+		if err != nil {
+			return nil, err
+		}
+		page.Next = p.link(r, cursorToken{Values: values, Query: query})
+	}
+	if hasPrev {
+		values, err := cursorValues(v.Index(0), req.Sort)
+		if err != nil {
+			return nil, err
+		}
+		page.Prev = p.link(r, cursorToken{Values: values, Backward: true, Query: query})
+	}
+
+	return page, nil
+}
+
+// link returns URL of the request with the cursor.
+func (p *Paginator) link(r *http.Request, c cursorToken) string {
+	q := r.URL.Query()
+	q.Set(cursorParam, p.encodeCursor(c))
+	return r.URL.Path + "?" + q.Encode()
+}
+
+func (p *Paginator) encodeCursor(c cursorToken) string {
+	b, _ := json.Marshal(c)
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + p.sign(payload)
+}
+
+func (p *Paginator) decodeCursor(v string, req app.PageRequest) (*app.Cursor, error) {
+	i := strings.LastIndexByte(v, '.')
+	if i < 0 || !hmac.Equal([]byte(v[i+1:]), []byte(p.sign(v[:i]))) {
+		return nil, errors.New("invalid cursor")
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(v[:i])
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var c cursorToken
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	if c.Query != queryHash(req) || len(c.Values) != len(req.Sort) {
+		return nil, errors.New("cursor doesn't match sort and filters of the request")
+	}
+
+	return &app.Cursor{Values: c.Values, Backward: c.Backward}, nil
+}
+
+func (p *Paginator) sign(payload string) string {
+	mac := hmac.New(sha256.New, p.cfg.Secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func queryHash(req app.PageRequest) string {
+	b, _ := json.Marshal(struct {
+		Sort    []app.Sort
+		Filters []app.Filter
+	}{req.Sort, req.Filters})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// cursorValues returns values of the sort fields of the item, fields are matched by JSON names.
+// Nil values are rejected, keyset pagination can't continue from them.
+func cursorValues(item reflect.Value, sorts []app.Sort) ([]string, error) {
+	for item.Kind() == reflect.Ptr || item.Kind() == reflect.Interface {
+		if item.IsNil() {
+			return nil, errors.New("page item can't be nil")
+		}
+		item = item.Elem()
+	}
+	if item.Kind() != reflect.Struct {
+		return nil, errors.Errorf("page item must be a struct, got %s", item.Type())
+	}
+
+	values := make([]string, len(sorts))
+	for i, s := range sorts {
+		f, ok := jsonField(item, s.Field)
+		if !ok {
+			return nil, errors.Errorf("page item %s has no field %q", item.Type(), s.Field)
+		}
+		for f.Kind() == reflect.Ptr || f.Kind() == reflect.Interface {
+			if f.IsNil() {
+				return nil, errors.Errorf("page item %s has nil %q, it can't be used as cursor", item.Type(), s.Field)
+			}
+			f = f.Elem()
+		}
+
+		switch v := f.Interface().(type) {
+		case time.Time:
+			values[i] = v.UTC().Format(time.RFC3339Nano)
+		default:
+			values[i] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+func jsonField(item reflect.Value, name string) (reflect.Value, bool) {
+	t := item.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if tag == name || (tag == "" && t.Field(i).Name == name) {
+			return item.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mateuszdyminski/go-template/app"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type pageItem struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func newTestPaginator() *Paginator {
+	return NewPaginator(PaginationConfig{
+		DefaultLimit: 2,
+		MaxLimit:     100,
+		Sortable:     []string{"name", "createdAt"},
+		DefaultSort:  []app.Sort{{Field: "createdAt", Desc: true}},
+		Key:          "id",
+		Filterable: map[string][]string{
+			"name":      {app.FilterEq, app.FilterPrefix, app.FilterIn},
+			"createdAt": {app.FilterGte, app.FilterLt},
+		},
+		Secret: []byte("page-secret"),
+	})
+}
+
+func Test_Paginator_Parse_ShouldParseQuery(t *testing.T) {
+	// given
+	p := newTestPaginator()
+	r := httptest.NewRequest(http.MethodGet, "/api/items?limit=10&sort=name,-createdAt&name[in]=a,b&createdAt[gte]=2020-01-01T00:00:00Z", nil)
+
+	// when
+	req, err := p.Parse(r)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, 10, req.Limit)
+	assert.Equal(t, []app.Sort{{Field: "name"}, {Field: "createdAt", Desc: true}, {Field: "id", Desc: true}}, req.Sort)
+	assert.Equal(t, []app.Filter{
+		{Field: "createdAt", Op: app.FilterGte, Values: []string{"2020-01-01T00:00:00Z"}},
+		{Field: "name", Op: app.FilterIn, Values: []string{"a", "b"}},
+	}, req.Filters)
+	assert.Nil(t, req.Cursor)
+}
+
+func Test_Paginator_Parse_ShouldUseDefaults(t *testing.T) {
+	// given
+	p := newTestPaginator()
+
+	// when
+	req, err := p.Parse(httptest.NewRequest(http.MethodGet, "/api/items", nil))
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, 2, req.Limit)
+	assert.Equal(t, []app.Sort{{Field: "createdAt", Desc: true}, {Field: "id", Desc: true}}, req.Sort)
+	assert.Empty(t, req.Filters)
+}
+
+func Test_Paginator_Parse_ShouldRejectInvalidQuery(t *testing.T) {
+	// given
+	p := newTestPaginator()
+	cursor := p.encodeCursor(cursorToken{Values: []string{"2020-01-01T00:00:00Z", "1"}, Query: "other"})
+
+	tests := []struct {
+		name  string
+		query string
+		field string
+	}{
+		{name: "limit over max", query: "limit=101", field: "limit"},
+		{name: "not sortable field", query: "sort=-secret", field: "sort"},
+		{name: "unknown filter", query: "owner=bob", field: "owner"},
+		{name: "operator not allowed", query: "name[gt]=a", field: "name[gt]"},
+		{name: "tampered cursor", query: "cursor=" + cursor[:len(cursor)-2] + "xx", field: "cursor"},
+		{name: "cursor of other query", query: "cursor=" + cursor, field: "cursor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// when
+			_, err := p.Parse(httptest.NewRequest(http.MethodGet, "/api/items?"+tt.query, nil))
+
+			// then
+			require.IsType(t, &RequestError{}, err)
+			re := err.(*RequestError)
+			assert.Equal(t, http.StatusUnprocessableEntity, re.Status)
+			require.Len(t, re.Fields, 1)
+			assert.Equal(t, tt.field, re.Fields[0].Field)
+		})
+	}
+}
+
+func Test_Paginator_Page_ShouldLinkPages(t *testing.T) {
+	// given
+	p := newTestPaginator()
+	t0 := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	items := []pageItem{
+		{ID: 5, Name: "e", CreatedAt: t0.Add(5 * time.Second)},
+		{ID: 4, Name: "d", CreatedAt: t0.Add(4 * time.Second)},
+		{ID: 3, Name: "c", CreatedAt: t0.Add(3 * time.Second)},
+	}
+
+	// when - first page has one item over the limit
+	r := httptest.NewRequest(http.MethodGet, "/api/items?name[prefix]=x", nil)
+	req, err := p.Parse(r)
+	require.NoError(t, err)
+	first, err := p.Page(r, req, items)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, items[:2], first.Items)
+	assert.Empty(t, first.Prev)
+	require.NotEmpty(t, first.Next)
+
+	// when - the next page follows the cursor
+	next := httptest.NewRequest(http.MethodGet, first.Next, nil)
+	assert.Equal(t, "x", next.URL.Query().Get("name[prefix]"))
+	req, err = p.Parse(next)
+	require.NoError(t, err)
+
+	// then
+	assert.Equal(t, &app.Cursor{Values: []string{"2020-01-01T12:00:04Z", "4"}}, req.Cursor)
+
+	// when - backward page is fetched in reverse order
+	second, err := p.Page(next, req, items[2:])
+	require.NoError(t, err)
+	assert.Empty(t, second.Next)
+	require.NotEmpty(t, second.Prev)
+	prev := httptest.NewRequest(http.MethodGet, second.Prev, nil)
+	req, err = p.Parse(prev)
+	require.NoError(t, err)
+	back, err := p.Page(prev, req, []pageItem{items[1], items[0]})
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, &app.Cursor{Values: []string{"2020-01-01T12:00:03Z", "3"}, Backward: true}, req.Cursor)
+	assert.Equal(t, []pageItem{items[0], items[1]}, back.Items)
+	assert.Empty(t, back.Prev)
+	u, err := url.Parse(back.Next)
+	require.NoError(t, err)
+	assert.Equal(t, "/api/items", u.Path)
+}
+
+func Test_Paginator_Page_ShouldReturnEmptyItems(t *testing.T) {
+	// given
+	p := newTestPaginator()
+	r := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+	req, err := p.Parse(r)
+	require.NoError(t, err)
+
+	// when
+	page, err := p.Page(r, req, []pageItem(nil))
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, []pageItem{}, page.Items)
+	assert.Empty(t, page.Next)
+	assert.Empty(t, page.Prev)
+}
+
+func Test_Paginator_Page_ShouldRejectNilCursorValues(t *testing.T) {
+	// given
+	type ptrItem struct {
+		ID   int64   `json:"id"`
+		Name *string `json:"name"`
+	}
+	p := NewPaginator(PaginationConfig{DefaultLimit: 1, MaxLimit: 10, Sortable: []string{"name"}, Key: "id", Secret: []byte("page-secret")})
+	r := httptest.NewRequest(http.MethodGet, "/api/items?sort=name", nil)
+	req, err := p.Parse(r)
+	require.NoError(t, err)
+	name := "a"
+
+	// when
+	nilField, fieldErr := p.Page(r, req, []ptrItem{{ID: 1}, {ID: 2}})
+	nilItem, itemErr := p.Page(r, req, []*ptrItem{nil, {ID: 2}})
+	valid, validErr := p.Page(r, req, []*ptrItem{{ID: 1, Name: &name}, {ID: 2}})
+
+	// then
+	assert.Nil(t, nilField)
+	assert.EqualError(t, fieldErr, `page item api.ptrItem has nil "name", it can't be used as cursor`)
+	assert.Nil(t, nilItem)
+	assert.EqualError(t, itemErr, "page item can't be nil")
+	require.NoError(t, validErr)
+	assert.NotEmpty(t, valid.Next)
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// NewProxyProtoListener returns listener reading PROXY protocol v1 or v2 header of connections from
+// the trusted proxies, so RemoteAddr of the connection is the client address. The header is required
+// from trusted proxies and not accepted from other peers. It's read on first use of the connection,
+// not in Accept, within the timeout.
+func NewProxyProtoListener(l *zap.Logger, ln net.Listener, trusted TrustedProxies, timeout time.Duration) net.Listener {
+	return &proxyProtoListener{Listener: ln, l: l.Sugar(), trusted: trusted, timeout: timeout}
+}
+
+type proxyProtoListener struct {
+	net.Listener
+	l       *zap.SugaredLogger
+	trusted TrustedProxies
+	timeout time.Duration
+}
+
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if tcp, ok := c.RemoteAddr().(*net.TCPAddr); !ok || !l.trusted.Contains(tcp.IP) {
+		return c, nil
+	}
+
+	return &proxyProtoConn{Conn: c, l: l, r: bufio.NewReaderSize(c, 256)}, nil
+}
+
+type proxyProtoConn struct {
+	net.Conn
+	l *proxyProtoListener
+	r *bufio.Reader
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyProtoConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyProtoConn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(c.l.timeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	remote, err := readProxyHeader(c.r)
+	if err != nil {
+		c.err = errors.Wrap(err, "invalid PROXY protocol header")
+		c.l.l.Warnw("closing connection", "remote", c.Conn.RemoteAddr().String(), "err", c.err)
+		c.Conn.Close()
+		return
+	}
+	c.remote = remote
+}
+
+// readProxyHeader returns source address from PROXY protocol header, nil for LOCAL and UNKNOWN connections.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err == nil && bytes.Equal(sig, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	if err != nil && len(sig) < 6 {
+		return nil, err
+	}
+	if !bytes.HasPrefix(sig, []byte("PROXY ")) {
+		return nil, errors.New("missing header")
+	}
+	return readProxyV1(r)
+}
+
+// readProxyV1 parses the human-readable header, e.g. "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < 107 { // max v1 header length
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("v1 header too long")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.Errorf("malformed v1 header %q", strings.TrimSpace(string(line)))
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, errors.Errorf("malformed v1 source address %s:%s", fields[2], fields[4])
+	}
+
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readProxyV2 parses the binary header, TLVs are skipped.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	verCmd, family := header[12], header[13]
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+	if verCmd>>4 != 2 {
+		return nil, errors.Errorf("unsupported version %d", verCmd>>4)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case verCmd&0xF == 0: // LOCAL - health check of the proxy itself
+		return nil, nil
+	case verCmd&0xF != 1:
+		return nil, errors.Errorf("unsupported command %d", verCmd&0xF)
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if length < 12 {
+			return nil, errors.New("truncated IPv4 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if length < 36 {
+			return nil, errors.New("truncated IPv6 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	}
+
+	// unspecified or unsupported family - keep the proxy address
+	return nil, nil
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func proxyV2Header(src net.IP, port uint16) []byte {
+	addrs := make([]byte, 12)
+	copy(addrs[0:4], src.To4())
+	copy(addrs[4:8], net.IPv4(10, 0, 0, 1).To4())
+	binary.BigEndian.PutUint16(addrs[8:10], port)
+	binary.BigEndian.PutUint16(addrs[10:12], 443)
+
+	h := append([]byte{}, proxyV2Signature...)
+	h = append(h, 0x21, 0x11, 0, byte(len(addrs)))
+	return append(h, addrs...)
+}
+
+func Test_readProxyHeader_ShouldParseSupportedVersions(t *testing.T) {
+	tests := map[string]struct {
+		header string
+		addr   string
+		err    bool
+	}{
+		"v1 TCP4":      {header: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", addr: "192.0.2.1:56324"},
+		"v1 TCP6":      {header: "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", addr: "[2001:db8::1]:56324"},
+		"v1 UNKNOWN":   {header: "PROXY UNKNOWN\r\n"},
+		"v1 malformed": {header: "PROXY TCP4 192.0.2.1\r\n", err: true},
+		"v1 too long":  {header: "PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n", err: true},
+		"v2 TCP4":      {header: string(proxyV2Header(net.IPv4(192, 0, 2, 9), 4000)), addr: "192.0.2.9:4000"},
+		"missing":      {header: "GET / HTTP/1.1\r\n\r\n", err: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// when
+			addr, err := readProxyHeader(bufio.NewReader(strings.NewReader(tt.header + "GET /")))
+
+			// then
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if tt.addr == "" {
+				assert.Nil(t, addr)
+			} else {
+				assert.Equal(t, tt.addr, addr.String())
+			}
+		})
+	}
+}
+
+func Test_ProxyProtoListener_ShouldReplaceRemoteAddrOfTrustedPeers(t *testing.T) {
+	// given
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer raw.Close()
+	trusted, _ := ParseTrustedProxies([]string{"127.0.0.1"})
+	ln := NewProxyProtoListener(zap.NewNop(), raw, trusted, time.Second)
+
+	go func() {
+		c, err := net.Dial("tcp", raw.Addr().String())
+		if err != nil {
+			return
+		}
+		c.Write(append(proxyV2Header(net.IPv4(192, 0, 2, 9), 4000), []byte("hello")...))
+		c.Close()
+	}()
+
+	// when
+	c, err := ln.Accept()
+	require.NoError(t, err)
+	defer c.Close()
+	body, err := ioutil.ReadAll(c)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, "192.0.2.9:4000", c.RemoteAddr().String())
+}
@@ -0,0 +1,301 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mateuszdyminski/go-template/app"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var (
+	rateLimitLimit     = http.CanonicalHeaderKey("RateLimit-Limit")
+	rateLimitRemaining = http.CanonicalHeaderKey("RateLimit-Remaining")
+	rateLimitReset     = http.CanonicalHeaderKey("RateLimit-Reset")
+	retryAfter         = http.CanonicalHeaderKey("Retry-After")
+	xAPIKey            = http.CanonicalHeaderKey("X-API-Key")
+)
+
+// KeyFunc returns the key the request is counted against.
+type KeyFunc func(r *http.Request) string
+
+// KeyByIP counts requests per client IP.
+func KeyByIP(r *http.Request) string {
+	return "ip:" + getRealIP(r)
+}
+
+// KeyByAPIKey counts requests per API key. Requests without a key are counted per client IP.
+func KeyByAPIKey(r *http.Request) string {
+	key := r.Header.Get(xAPIKey)
+	if key == "" {
+		return KeyByIP(r)
+	}
+
+	// don't keep secrets in the store
+	sum := sha256.Sum256([]byte(key))
+	return "apikey:" + hex.EncodeToString(sum[:8])
+}
+
+// KeyByRoute counts requests per route, regardless of the client.
+func KeyByRoute(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if path, err := route.GetPathTemplate(); err == nil {
+			return "route:" + r.Method + " " + path
+		}
+	}
+	return "route:" + r.Method + " " + r.URL.Path
+}
+
+// RateLimitMetrics - metrics shared by all rate limiters.
+type RateLimitMetrics struct {
+	Requests *prometheus.CounterVec
+}
+
+func NewRateLimitMetrics(reg prometheus.Registerer) *RateLimitMetrics {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "http",
+		Name:      "ratelimit_requests_total",
+		Help:      "The total number of requests checked by rate limiters.",
+	}, []string{"limiter", "key_type", "result"})
+
+	reg.MustRegister(requests)
+
+	return &RateLimitMetrics{Requests: requests}
+}
+
+// RateLimiter rejects requests over the limit with 429 JSON error. Register it before the authentication
+// middlewares, so requests with invalid credentials are limited too. Credentials aren't verified at that
+// point - requests keyed by anything but the client IP which fail authentication are also counted against
+// the client IP, and the IP is blocked once it's over the limit. Rotating fake credentials doesn't
+// bypass the limit this way.
+type RateLimiter struct {
+	l       *zap.SugaredLogger
+	name    string
+	keyType string
+	keyFunc KeyFunc
+	limit   app.RateLimit
+	store   app.RateLimitStore
+	metrics *RateLimitMetrics
+	now     func() time.Time
+
+	mu      sync.Mutex
+	blocked map[string]time.Time // client IP key -> end of the block
+}
+
+// NewRateLimiter returns rate limiter counting requests by keyFunc in the store.
+// The name and keyType are used as metrics labels, e.g. "api" and "ip".
+func NewRateLimiter(l *zap.Logger, name, keyType string, keyFunc KeyFunc, limit app.RateLimit, store app.RateLimitStore, m *RateLimitMetrics) *RateLimiter {
+	return &RateLimiter{
+		l:       l.Sugar(),
+		name:    name,
+		keyType: keyType,
+		keyFunc: keyFunc,
+		limit:   limit,
+		store:   store,
+		metrics: m,
+		now:     time.Now,
+		blocked: make(map[string]time.Time),
+	}
+}
+
+func (rl *RateLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if RouteConfigFrom(r.Context()).SkipRateLimit {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, ipKey := rl.keyFunc(r), KeyByIP(r)
+		if key != ipKey {
+			if wait := rl.blockedFor(ipKey); wait > 0 {
+				rl.setHeaders(w.Header(), 0, wait)
+				rl.reject(w, r, wait)
+				return
+			}
+		}
+
+		res, err := rl.store.Take(r.Context(), key, rl.limit)
+		if err != nil {
+			// fail open - store outage shouldn't take the whole service down
+			LoggerFrom(r.Context()).Sugar().Errorw("rate limit store failed", "limiter", rl.name, "err", err)
+			rl.metrics.Requests.WithLabelValues(rl.name, rl.keyType, "error").Inc()
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rl.setHeaders(w.Header(), res.Remaining, res.Reset)
+		if !res.Allowed {
+			rl.reject(w, r, res.RetryAfter)
+			return
+		}
+
+		rl.metrics.Requests.WithLabelValues(rl.name, rl.keyType, "allowed").Inc()
+		if key == ipKey {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		interceptor := &interceptor{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(interceptor, r)
+		if interceptor.statusCode == http.StatusUnauthorized {
+			rl.authFailed(r, ipKey)
+		}
+	})
+}
+
+// setHeaders sets RateLimit-* headers of the response.
+func (rl *RateLimiter) setHeaders(h http.Header, remaining int, reset time.Duration) {
+	h.Set(rateLimitLimit, strconv.Itoa(rl.limit.Requests))
+	h.Set(rateLimitRemaining, strconv.Itoa(remaining))
+	h.Set(rateLimitReset, seconds(reset))
+}
+
+func (rl *RateLimiter) reject(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	rl.metrics.Requests.WithLabelValues(rl.name, rl.keyType, "limited").Inc()
+	w.Header().Set(retryAfter, seconds(wait))
+	WriteErrJSON(rl.l, w, r, errors.New("rate limit exceeded"), http.StatusTooManyRequests)
+}
+
+// authFailed counts failed authentication against the client IP and blocks the IP when it's over the limit.
+func (rl *RateLimiter) authFailed(r *http.Request, ipKey string) {
+	res, err := rl.store.Take(r.Context(), "authfail:"+ipKey, rl.limit)
+	if err != nil || res.Allowed {
+		return
+	}
+
+	now := rl.now()
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for k, until := range rl.blocked {
+		if !until.After(now) {
+			delete(rl.blocked, k)
+		}
+	}
+	rl.blocked[ipKey] = now.Add(res.RetryAfter)
+}
+
+// blockedFor returns the time the client IP stays blocked for.
+func (rl *RateLimiter) blockedFor(ipKey string) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.blocked[ipKey].Sub(rl.now())
+}
+
+// Purge deletes state of the keys not counted for two windows every interval until ctx is done.
+func (rl *RateLimiter) Purge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := rl.store.DeleteExpired(ctx, rl.now().UTC().Add(-2*rl.limit.Window))
+			if err != nil {
+				rl.l.Warnw("can't delete expired rate limits", "limiter", rl.name, "err", err)
+				continue
+			}
+			rl.l.Debugw("expired rate limits deleted", "limiter", rl.name, "count", n)
+		}
+	}
+}
+
+// seconds formats duration as whole seconds, rounded up.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// MemoryRateLimitStore keeps token buckets in memory - limits are per replica.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Take takes a token from the key's bucket. Buckets hold limit.Requests tokens
+// and are refilled continuously over limit.Window.
+func (s *MemoryRateLimitStore) Take(_ context.Context, key string, limit app.RateLimit) (app.RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now, limit.Window)
+
+	capacity := float64(limit.Requests)
+	rate := capacity / limit.Window.Seconds() // tokens per second
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, last: now}
+		s.buckets[key] = b
+	}
+
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	res := app.RateLimitResult{}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = time.Duration((capacity - b.tokens) / rate * float64(time.Second))
+
+	return res, nil
+}
+
+// DeleteExpired deletes buckets not used since before.
+func (s *MemoryRateLimitStore) DeleteExpired(_ context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for key, b := range s.buckets {
+		if b.last.Before(before) {
+			delete(s.buckets, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// sweep removes buckets which were refilled completely, so the map doesn't grow forever.
+func (s *MemoryRateLimitStore) sweep(now time.Time, window time.Duration) {
+	if now.Sub(s.lastSweep) < window {
+		return
+	}
+	s.lastSweep = now
+
+	for key, b := range s.buckets {
+		if now.Sub(b.last) >= window {
+			delete(s.buckets, key)
+		}
+	}
+}
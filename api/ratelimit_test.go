@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mateuszdyminski/go-template/app"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func Test_MemoryRateLimitStore_ShouldRefillTokensOverTime(t *testing.T) {
+	// given
+	now := time.Unix(1000, 0)
+	store := NewMemoryRateLimitStore()
+	store.now = func() time.Time { return now }
+	limit := app.RateLimit{Requests: 2, Window: 2 * time.Second}
+
+	// when
+	first, _ := store.Take(context.Background(), "key", limit)
+	second, _ := store.Take(context.Background(), "key", limit)
+	third, _ := store.Take(context.Background(), "key", limit)
+	now = now.Add(time.Second)
+	fourth, _ := store.Take(context.Background(), "key", limit)
+
+	// then
+	assert.True(t, first.Allowed)
+	assert.Equal(t, 1, first.Remaining)
+	assert.True(t, second.Allowed)
+	assert.Equal(t, 0, second.Remaining)
+	assert.False(t, third.Allowed)
+	assert.Equal(t, time.Second, third.RetryAfter)
+	assert.True(t, fourth.Allowed, "one token should be refilled after a second")
+}
+
+func Test_MemoryRateLimitStore_ShouldDeleteExpiredBuckets(t *testing.T) {
+	// given
+	now := time.Unix(1000, 0)
+	store := NewMemoryRateLimitStore()
+	store.now = func() time.Time { return now }
+	limit := app.RateLimit{Requests: 2, Window: time.Minute}
+	store.Take(context.Background(), "old", limit)
+	now = now.Add(2 * time.Minute)
+	store.Take(context.Background(), "new", limit)
+
+	// when
+	n, err := store.DeleteExpired(context.Background(), now.Add(-time.Minute))
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.Contains(t, store.buckets, "new")
+	assert.NotContains(t, store.buckets, "old")
+}
+
+func Test_RateLimiter_ShouldRespondWith429AndHeaders(t *testing.T) {
+	// given
+	m := NewRateLimitMetrics(prometheus.NewRegistry())
+	limit := app.RateLimit{Requests: 1, Window: time.Minute}
+	rl := NewRateLimiter(zap.NewNop(), "test", "ip", KeyByIP, limit, NewMemoryRateLimitStore(), m)
+	h := rl.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// when
+	first := httptest.NewRecorder()
+	h.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/api/version", nil))
+	second := httptest.NewRecorder()
+	h.ServeHTTP(second, httptest.NewRequest(http.MethodGet, "/api/version", nil))
+
+	// then
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, "1", first.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", first.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, http.StatusTooManyRequests, second.Code)
+	assert.Equal(t, "60", second.Header().Get("Retry-After"))
+	assert.Contains(t, second.Body.String(), `"httpStatusCode":429`)
+}
+
+func Test_RateLimiter_ShouldSkipRoutesWithoutRateLimit(t *testing.T) {
+	// given
+	m := NewRateLimitMetrics(prometheus.NewRegistry())
+	limit := app.RateLimit{Requests: 1, Window: time.Minute}
+	rl := NewRateLimiter(zap.NewNop(), "test", "ip", KeyByIP, limit, NewMemoryRateLimitStore(), m)
+	routes := NewRoutes()
+	r := mux.NewRouter()
+	r.Use(routes.Handler, rl.Handler)
+	routes.Route(r.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {}), WithoutRateLimit())
+	r.HandleFunc("/api/version", func(w http.ResponseWriter, r *http.Request) {})
+
+	// when
+	probes := make([]int, 3)
+	for i := range probes {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/health", nil))
+		probes[i] = w.Code
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/version", nil))
+
+	// then
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusOK}, probes)
+	assert.Equal(t, http.StatusOK, w.Code, "probes shouldn't take tokens of the client")
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.Requests.WithLabelValues("test", "ip", "allowed")))
+}
+
+func Test_RateLimiter_ShouldCountAuthenticationFailuresAgainstClientIP(t *testing.T) {
+	// given
+	m := NewRateLimitMetrics(prometheus.NewRegistry())
+	limit := app.RateLimit{Requests: 2, Window: time.Minute}
+	rl := NewRateLimiter(zap.NewNop(), "test", "apikey", KeyByAPIKey, limit, NewMemoryRateLimitStore(), m)
+	h := rl.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+
+	// when each request presents another fake key
+	codes := make([]int, 5)
+	var last *httptest.ResponseRecorder
+	for i := range codes {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/whoami", nil)
+		r.Header.Set("X-API-Key", fmt.Sprintf("fake-%d", i))
+		last = httptest.NewRecorder()
+		h.ServeHTTP(last, r)
+		codes[i] = last.Code
+	}
+
+	// then
+	assert.Equal(t, []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized,
+		http.StatusTooManyRequests, http.StatusTooManyRequests}, codes)
+	assert.Equal(t, "2", last.Header().Get("RateLimit-Limit"), "blocked client IP should get the headers too")
+	assert.Equal(t, "0", last.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, last.Header().Get("Retry-After"), last.Header().Get("RateLimit-Reset"))
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// RouteConfig - options of the route read by the router middlewares.
+type RouteConfig struct {
+	// Priority - priority class of the requests shed by ConcurrencyLimiter
+	Priority Priority
+	// Protected - the route requires authenticated principal with the Permissions, see Authorizer
+	Protected   bool
+	Permissions []string
+	// CachePolicy - Cache-Control of the responses, empty for the default policy
+	CachePolicy string
+	// SecurityHeaders - changes the default security headers of the responses
+	SecurityHeaders func(*SecurityHeadersConfig)
+	// SkipAudit - the route doesn't change anything despite its method, e.g. a POST query
+	SkipAudit bool
+	// SkipRateLimit - requests aren't counted by RateLimiter, e.g. probes and metrics scrapes
+	SkipRateLimit bool
+	// Capture - request and response bodies are captured by BodyCapture
+	Capture bool
+	// Deprecation - deprecation of the route, it takes precedence over deprecation of its API version
+	Deprecation *Deprecation
+	// Timeout - deadline of the route's handler applied by RouteTimeout, 0 - no deadline
+	Timeout time.Duration
+}
+
+var defaultRouteConfig = RouteConfig{Priority: PriorityNormal}
+
+// RouteOption - sets an option of the route.
+type RouteOption func(*RouteConfig)
+
+// WithPriority sets priority class of the route's requests.
+func WithPriority(p Priority) RouteOption {
+	return func(c *RouteConfig) { c.Priority = p }
+}
+
+// WithPermissions protects the route, without permissions it only requires authenticated principal.
+func WithPermissions(perms ...string) RouteOption {
+	return func(c *RouteConfig) {
+		c.Protected = true
+		c.Permissions = append([]string{}, perms...)
+	}
+}
+
+// WithCachePolicy sets Cache-Control policy of the route.
+func WithCachePolicy(p CachePolicy) RouteOption {
+	return func(c *RouteConfig) { c.CachePolicy = p.String() }
+}
+
+// WithSecurityHeaders changes security headers of the route, fn gets copy of the default configuration.
+func WithSecurityHeaders(fn func(*SecurityHeadersConfig)) RouteOption {
+	return func(c *RouteConfig) { c.SecurityHeaders = fn }
+}
+
+// WithoutAudit excludes the route from the audit log.
+func WithoutAudit() RouteOption {
+	return func(c *RouteConfig) { c.SkipAudit = true }
+}
+
+// WithoutRateLimit excludes the route from rate limiting.
+func WithoutRateLimit() RouteOption {
+	return func(c *RouteConfig) { c.SkipRateLimit = true }
+}
+
+// WithCapture enables capture of the route's bodies.
+func WithCapture() RouteOption {
+	return func(c *RouteConfig) { c.Capture = true }
+}
+
+// WithDeprecation declares the route deprecated.
+func WithDeprecation(d Deprecation) RouteOption {
+	return func(c *RouteConfig) { c.Deprecation = &d }
+}
+
+// WithTimeout sets deadline of the route's handler, see Timeout.
+func WithTimeout(d time.Duration) RouteOption {
+	return func(c *RouteConfig) { c.Timeout = d }
+}
+
+// Routes keeps options of the routes of one or more routers. Options are declared while
+// registering the routes, before the server starts - Routes isn't safe for concurrent changes.
+type Routes struct {
+	configs map[*mux.Route]*RouteConfig
+}
+
+// NewRoutes returns Routes without options.
+func NewRoutes() *Routes {
+	return &Routes{configs: make(map[*mux.Route]*RouteConfig)}
+}
+
+// Route sets options of the route.
+func (rs *Routes) Route(route *mux.Route, opts ...RouteOption) *mux.Route {
+	c, ok := rs.configs[route]
+	if !ok {
+		c = &RouteConfig{}
+		*c = defaultRouteConfig
+		rs.configs[route] = c
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return route
+}
+
+// Config returns options of the route.
+func (rs *Routes) Config(route *mux.Route) RouteConfig {
+	if c, ok := rs.configs[route]; ok {
+		return *c
+	}
+	return defaultRouteConfig
+}
+
+// Handler makes options of the matched route available with RouteConfigFrom.
+// Register it before the middlewares reading them.
+func (rs *Routes) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, ok := rs.configs[mux.CurrentRoute(r)]; ok {
+			r = r.WithContext(context.WithValue(r.Context(), routeConfigKey, c))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RouteConfigFrom returns options of the route matched by the request, defaults when it has none.
+func RouteConfigFrom(ctx context.Context) RouteConfig {
+	if c, ok := ctx.Value(routeConfigKey).(*RouteConfig); ok {
+		return *c
+	}
+	return defaultRouteConfig
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Routes_ShouldPassOptionsOfMatchedRoute(t *testing.T) {
+	// given
+	routes := NewRoutes()
+	r := mux.NewRouter()
+	r.Use(routes.Handler)
+	var got []RouteConfig
+	handler := func(w http.ResponseWriter, r *http.Request) { got = append(got, RouteConfigFrom(r.Context())) }
+	routes.Route(r.HandleFunc("/api/health", handler), WithPriority(PriorityCritical))
+	routes.Route(r.HandleFunc("/api/items", handler), WithPermissions("items:write"), WithoutAudit())
+	r.HandleFunc("/api/version", handler)
+
+	// when
+	for _, path := range []string{"/api/health", "/api/items", "/api/version"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, nil))
+	}
+
+	// then
+	assert.Equal(t, []RouteConfig{
+		{Priority: PriorityCritical},
+		{Priority: PriorityNormal, Protected: true, Permissions: []string{"items:write"}, SkipAudit: true},
+		{Priority: PriorityNormal},
+	}, got)
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// nonceCSPPlaceholder - placeholder in Content-Security-Policy replaced with per-request nonce.
+const nonceCSPPlaceholder = "{nonce}"
+
+// SecurityHeadersConfig - values of the security response headers, empty values aren't sent.
+type SecurityHeadersConfig struct {
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	FrameOptions          string
+	ReferrerPolicy        string
+	PermissionsPolicy     string
+	// ContentSecurityPolicy - {nonce} placeholders are replaced with random nonce of the request,
+	// e.g. script-src 'nonce-{nonce}'
+	ContentSecurityPolicy string
+}
+
+// SecurityHeaders sets security headers on every response of the router. X-Content-Type-Options
+// is always set to nosniff. Routes can change the configuration, see WithSecurityHeaders.
+type SecurityHeaders struct {
+	l   *zap.SugaredLogger
+	cfg SecurityHeadersConfig
+}
+
+func NewSecurityHeaders(l *zap.Logger, cfg SecurityHeadersConfig) *SecurityHeaders {
+	return &SecurityHeaders{l: l.Sugar(), cfg: cfg}
+}
+
+func (s *SecurityHeaders) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := s.cfg
+		if fn := RouteConfigFrom(r.Context()).SecurityHeaders; fn != nil {
+			fn(&cfg)
+		}
+
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		if cfg.HSTSMaxAge > 0 {
+			hsts := fmt.Sprintf("max-age=%d", int(cfg.HSTSMaxAge.Seconds()))
+			if cfg.HSTSIncludeSubdomains {
+				hsts += "; includeSubDomains"
+			}
+			if cfg.HSTSPreload {
+				hsts += "; preload"
+			}
+			h.Set("Strict-Transport-Security", hsts)
+		}
+		setIfNotEmpty(h, "X-Frame-Options", cfg.FrameOptions)
+		setIfNotEmpty(h, "Referrer-Policy", cfg.ReferrerPolicy)
+		setIfNotEmpty(h, "Permissions-Policy", cfg.PermissionsPolicy)
+
+		if csp := cfg.ContentSecurityPolicy; csp != "" {
+			if strings.Contains(csp, nonceCSPPlaceholder) {
+				nonce, err := newNonce()
+				if err != nil {
+					WriteErrJSON(s.l, w, r, err, http.StatusInternalServerError)
+					return
+				}
+				csp = strings.Replace(csp, nonceCSPPlaceholder, nonce, -1)
+				r = r.WithContext(context.WithValue(r.Context(), cspNonceKey, nonce))
+			}
+			h.Set("Content-Security-Policy", csp)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CSPNonce returns nonce of the request's Content-Security-Policy, empty when the policy has none.
+func CSPNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceKey).(string)
+	return nonce
+}
+
+// NonceHTML adds CSP nonce of the request to inline <script> and <style> tags of HTML pages served
+// by h, e.g. Swagger UI which can't be configured to do it. Other responses are passed through.
+func NonceHTML(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce := CSPNonce(r.Context())
+		if nonce == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		nw := &nonceWriter{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(nw, r)
+		nw.finish(nonce)
+	})
+}
+
+// nonceWriter buffers HTML responses, other content is written through.
+type nonceWriter struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+	html        bool
+	decided     bool
+	buf         bytes.Buffer
+}
+
+func (w *nonceWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.code = code
+}
+
+func (w *nonceWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.decided = true
+		ct := w.Header().Get("Content-Type")
+		if ct == "" {
+			ct = http.DetectContentType(p)
+			w.Header().Set("Content-Type", ct)
+		}
+		w.html = strings.HasPrefix(ct, "text/html")
+		if !w.html {
+			w.ResponseWriter.WriteHeader(w.code)
+		}
+	}
+
+	if w.html {
+		return w.buf.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *nonceWriter) finish(nonce string) {
+	if !w.decided {
+		w.ResponseWriter.WriteHeader(w.code)
+		return
+	}
+	if !w.html {
+		return
+	}
+
+	attr := fmt.Sprintf(` nonce="%s"`, nonce)
+	body := bytes.Replace(w.buf.Bytes(), []byte("<script"), []byte("<script"+attr), -1)
+	body = bytes.Replace(body, []byte("<style"), []byte("<style"+attr), -1)
+
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.code)
+	w.ResponseWriter.Write(body)
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "can't generate CSP nonce")
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+func setIfNotEmpty(h http.Header, key, value string) {
+	if value != "" {
+		h.Set(key, value)
+	}
+}
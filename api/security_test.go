@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func Test_SecurityHeaders_ShouldSetHeadersWithRouteOverrides(t *testing.T) {
+	// given
+	sh := NewSecurityHeaders(zap.NewNop(), SecurityHeadersConfig{
+		HSTSMaxAge:            time.Hour,
+		HSTSIncludeSubdomains: true,
+		FrameOptions:          "DENY",
+		ReferrerPolicy:        "no-referrer",
+		PermissionsPolicy:     "camera=()",
+		ContentSecurityPolicy: "default-src 'none'",
+	})
+	routes := NewRoutes()
+	r := mux.NewRouter()
+	r.Use(routes.Handler)
+	r.Use(sh.Handler)
+	r.HandleFunc("/api/version", func(w http.ResponseWriter, r *http.Request) {})
+	routes.Route(r.PathPrefix("/swagger/").Handler(NonceHTML(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<!DOCTYPE html><html><style>body{}</style><script src="./ui.js"></script><script>init()</script></html>`))
+	}))), WithSecurityHeaders(func(c *SecurityHeadersConfig) {
+		c.ContentSecurityPolicy = "script-src 'nonce-{nonce}'; style-src 'nonce-{nonce}'"
+	}))
+
+	// when
+	api := httptest.NewRecorder()
+	r.ServeHTTP(api, httptest.NewRequest(http.MethodGet, "/api/version", nil))
+	swagger := httptest.NewRecorder()
+	r.ServeHTTP(swagger, httptest.NewRequest(http.MethodGet, "/swagger/index.html", nil))
+
+	// then
+	assert.Equal(t, "max-age=3600; includeSubDomains", api.Header().Get("Strict-Transport-Security"))
+	assert.Equal(t, "nosniff", api.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "DENY", api.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "no-referrer", api.Header().Get("Referrer-Policy"))
+	assert.Equal(t, "camera=()", api.Header().Get("Permissions-Policy"))
+	assert.Equal(t, "default-src 'none'", api.Header().Get("Content-Security-Policy"))
+
+	m := regexp.MustCompile(`^script-src 'nonce-([^']+)'; style-src 'nonce-([^']+)'$`).FindStringSubmatch(swagger.Header().Get("Content-Security-Policy"))
+	require.Len(t, m, 3)
+	assert.Equal(t, m[1], m[2])
+	assert.Equal(t, "DENY", swagger.Header().Get("X-Frame-Options"), "not overridden headers should be kept")
+	assert.Equal(t, `<!DOCTYPE html><html><style nonce="`+m[1]+`">body{}</style><script nonce="`+m[1]+`" src="./ui.js"></script><script nonce="`+m[1]+`">init()</script></html>`, swagger.Body.String())
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// Timeout returns route middleware which sets deadline d on the request context. When the handler
+// doesn't finish in time, the client gets JSON error: 504 when the deadline was exceeded or 503 when
+// the request was canceled for other reason. Whatever the handler writes after that is discarded.
+func Timeout(l *zap.Logger, d time.Duration) mux.MiddlewareFunc {
+	ls := l.Sugar()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			r = r.WithContext(ctx)
+
+			tw := &timeoutWriter{w: w, h: make(http.Header)}
+			done := make(chan struct{})
+			panicChan := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicChan <- p
+					}
+				}()
+				next.ServeHTTP(tw, r)
+				close(done)
+			}()
+
+			select {
+			case p := <-panicChan:
+				panic(p)
+			case <-done:
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				tw.flush()
+			case <-ctx.Done():
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				tw.timedOut = true
+				markTimedOut(r.Context())
+
+				if ctx.Err() == context.DeadlineExceeded {
+					WriteErrJSON(ls, w, r, errors.New("request timeout exceeded"), http.StatusGatewayTimeout)
+				} else {
+					WriteErrJSON(ls, w, r, errors.New("request canceled"), http.StatusServiceUnavailable)
+				}
+			}
+		})
+	}
+}
+
+// RouteTimeout returns router middleware which applies Timeout with the deadline declared by the route
+// with WithTimeout. Requests of other routes have no deadline.
+func RouteTimeout(l *zap.Logger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := RouteConfigFrom(r.Context()).Timeout
+			if d <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			Timeout(l, d)(next).ServeHTTP(w, r)
+		})
+	}
+}
+
+// markTimedOut lets the metrics middleware know the request timed out.
+func markTimedOut(ctx context.Context) {
+	if s := getRequestState(ctx); s != nil {
+		atomic.StoreInt32(&s.timedOut, 1)
+	}
+}
+
+func timedOut(ctx context.Context) bool {
+	s := getRequestState(ctx)
+	return s != nil && atomic.LoadInt32(&s.timedOut) == 1
+}
+
+// timeoutWriter buffers the response until the handler finishes, so it can be replaced by the error.
+type timeoutWriter struct {
+	w http.ResponseWriter
+	h http.Header
+
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.h }
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	return tw.buf.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut || tw.code != 0 {
+		return
+	}
+	tw.code = code
+}
+
+func (tw *timeoutWriter) flush() {
+	dst := tw.w.Header()
+	for k, vv := range tw.h {
+		dst[k] = vv
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	tw.w.WriteHeader(tw.code)
+	tw.w.Write(tw.buf.Bytes())
+}
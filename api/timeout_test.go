@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func Test_Timeout_ShouldPassResponseOfFinishedHandler(t *testing.T) {
+	// given
+	h := Timeout(zap.NewNop(), time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := r.Context().Deadline()
+		assert.True(t, ok, "handler context should have deadline")
+		w.Header().Set("X-Test", "yes")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+	w := httptest.NewRecorder()
+
+	// when
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/items", nil))
+
+	// then
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "yes", w.Header().Get("X-Test"))
+	assert.Equal(t, "created", w.Body.String())
+}
+
+func Test_Timeout_ShouldRespondWithError(t *testing.T) {
+	tests := []struct {
+		name   string
+		cancel bool
+		status int
+	}{
+		{name: "deadline exceeded", status: http.StatusGatewayTimeout},
+		{name: "request canceled", cancel: true, status: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			late := make(chan error, 1)
+			h := Timeout(zap.NewNop(), 20*time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+				time.Sleep(10 * time.Millisecond)
+				w.Header().Set("X-Late", "yes")
+				_, err := w.Write([]byte("late"))
+				late <- err
+			}))
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+			w := httptest.NewRecorder()
+
+			// when
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/items", nil).WithContext(ctx))
+
+			// then
+			assert.Equal(t, tt.status, w.Code)
+			assert.Contains(t, w.Body.String(), `"httpStatusCode":`)
+			assert.Equal(t, http.ErrHandlerTimeout, <-late, "writes after the timeout should be discarded")
+			assert.NotContains(t, w.Body.String(), "late")
+			assert.Empty(t, w.Header().Get("X-Late"))
+		})
+	}
+}
+
+func Test_Timeout_ShouldReportTimeoutMetricLabel(t *testing.T) {
+	// given
+	prom := &MetricsMiddleware{
+		Histogram: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "duration"}, []string{"method", "path", "status"}),
+		Counter:   prometheus.NewCounterVec(prometheus.CounterOpts{Name: "total"}, []string{"status"}),
+	}
+	r := mux.NewRouter()
+	r.Use(prom.Handler)
+	r.Handle("/api/slow", Timeout(zap.NewNop(), 10*time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})))
+
+	// when
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/slow", nil))
+
+	// then
+	assert.Equal(t, float64(1), testutil.ToFloat64(prom.Counter.WithLabelValues("timeout")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(prom.Counter.WithLabelValues("504")))
+}
+
+func Test_RouteTimeout_ShouldApplyDeadlineDeclaredByRoute(t *testing.T) {
+	// given
+	routes := NewRoutes()
+	r := mux.NewRouter()
+	r.Use(routes.Handler)
+	r.Use(RouteTimeout(zap.NewNop()))
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); ok {
+			<-r.Context().Done()
+		}
+	}
+	routes.Route(r.HandleFunc("/api/slow", handler), WithTimeout(10*time.Millisecond))
+	r.HandleFunc("/api/items", handler)
+
+	// when
+	slow, items := httptest.NewRecorder(), httptest.NewRecorder()
+	r.ServeHTTP(slow, httptest.NewRequest(http.MethodGet, "/api/slow", nil))
+	r.ServeHTTP(items, httptest.NewRequest(http.MethodGet, "/api/items", nil))
+
+	// then
+	assert.Equal(t, http.StatusGatewayTimeout, slow.Code)
+	assert.Equal(t, http.StatusOK, items.Code)
+}
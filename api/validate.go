@@ -0,0 +1,197 @@
+package api
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validate checks struct fields against rules from `validate` tags and returns all failures.
+// Fields are named after their JSON names. Supported rules:
+//
+//	required   - value must not be zero (pointers must not be nil)
+//	min=N      - min length of strings, slices and maps or min value of numbers
+//	max=N      - max length of strings, slices and maps or max value of numbers
+//	oneof=a b  - value must be one of the space separated values
+//	email      - string must be an email address
+//	url        - string must be an absolute URL
+//
+// Absent fields - nil pointers, slices and maps, and zero values of fields with the omitempty JSON
+// option - are checked only by the required rule. Zero values of other fields are validated like any
+// other value, e.g. age 0 fails min=18.
+//
+// Nested structs, pointers to structs and slices of structs are validated recursively.
+func Validate(v interface{}) []FieldError {
+	var errs []FieldError
+	validateValue(reflect.ValueOf(v), "", &errs)
+	return errs
+}
+
+func validateValue(v reflect.Value, path string, errs *[]FieldError) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" { // unexported
+				continue
+			}
+			name, omitEmpty := jsonName(f)
+			if name == "-" {
+				continue
+			}
+			fieldPath := joinPath(path, name)
+			fv := v.Field(i)
+
+			if tag := f.Tag.Get("validate"); tag != "" {
+				before := len(*errs)
+				validateField(fv, fieldPath, tag, omitEmpty, errs)
+				// don't report nested errors of a missing or invalid value
+				if len(*errs) > before {
+					continue
+				}
+			}
+			validateValue(fv, fieldPath, errs)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+func validateField(v reflect.Value, path, tag string, omitEmpty bool, errs *[]FieldError) {
+	fail := func(reason, msg string, args ...interface{}) {
+		*errs = append(*errs, FieldError{Field: path, Reason: reason, Msg: fmt.Sprintf(msg, args...)})
+	}
+
+	rules := strings.Split(tag, ",")
+	for _, rule := range rules {
+		if rule == "required" && isZero(v) {
+			fail("required", "is required")
+			return
+		}
+	}
+
+	// optional fields are validated only when set
+	if isNil(v) || (omitEmpty && isZero(v)) {
+		return
+	}
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	for _, rule := range rules {
+		name, param := rule, ""
+		if i := strings.Index(rule, "="); i != -1 {
+			name, param = rule[:i], rule[i+1:]
+		}
+
+		switch name {
+		case "required":
+		case "min", "max":
+			limit, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				panic(fmt.Sprintf("validate: invalid %s param %q of field %s", name, param, path))
+			}
+			size, isLength := measure(v)
+			if (name == "min" && size < limit) || (name == "max" && size > limit) {
+				what := "value"
+				if isLength {
+					what = "length"
+				}
+				relation := "at least"
+				if name == "max" {
+					relation = "at most"
+				}
+				fail(name, "%s must be %s %s", what, relation, param)
+			}
+		case "oneof":
+			s := fmt.Sprint(v.Interface())
+			if !contains(strings.Fields(param), s) {
+				fail("oneof", "must be one of: %s", strings.Join(strings.Fields(param), ", "))
+			}
+		case "email":
+			if a, err := mail.ParseAddress(v.String()); err != nil || a.Address != v.String() {
+				fail("email", "must be a valid email address")
+			}
+		case "url":
+			if u, err := url.Parse(v.String()); err != nil || u.Scheme == "" || u.Host == "" {
+				fail("url", "must be an absolute URL")
+			}
+		default:
+			panic(fmt.Sprintf("validate: unknown rule %q of field %s", name, path))
+		}
+	}
+}
+
+// measure returns length of strings and collections or value of numbers.
+func measure(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), false
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false
+	}
+	panic(fmt.Sprintf("validate: min/max not supported for %s", v.Kind()))
+}
+
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// isNil returns true for absent pointers, interfaces, slices and maps.
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+		return v.IsNil()
+	}
+	return false
+}
+
+// jsonName returns JSON name of the field and whether it has the omitempty option.
+func jsonName(f reflect.StructField) (string, bool) {
+	opts := strings.Split(f.Tag.Get("json"), ",")
+	name := opts[0]
+	if name == "" {
+		name = f.Name
+	}
+	return name, contains(opts[1:], "omitempty")
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
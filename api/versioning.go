@@ -0,0 +1,208 @@
+package api
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var (
+	deprecation = http.CanonicalHeaderKey("Deprecation")
+	sunset      = http.CanonicalHeaderKey("Sunset")
+	link        = http.CanonicalHeaderKey("Link")
+)
+
+// Deprecation - deprecation of the API version or route.
+type Deprecation struct {
+	// Since - time the endpoint was or will be deprecated, sent as Deprecation header (RFC 9745)
+	Since time.Time
+	// Sunset - time the endpoint will be removed, sent as Sunset header (RFC 8594), zero when unknown
+	Sunset time.Time
+	// Link - URL of the migration guide, sent as Link header with deprecation relation
+	Link string
+}
+
+// VersionSpec - API version served by the versioned subrouter.
+type VersionSpec struct {
+	// Name - path segment of the version, e.g. v1
+	Name string
+	// Deprecation - nil unless the whole version is deprecated
+	Deprecation *Deprecation
+}
+
+// Versioning serves API versions on subrouters, e.g. /api/v1 and /api/v2. Requests without version
+// in the path are served by the version negotiated with X-API-Version header or Accept media type,
+// e.g. application/vnd.app.v2+json or application/json; version=2, or by the default version.
+// Responses carry X-API-Version of the served version and Deprecation, Sunset and Link headers
+// of deprecated versions and routes.
+type Versioning struct {
+	l          *zap.SugaredLogger
+	prefix     string
+	versions   map[string]VersionSpec
+	names      []string
+	def        string
+	deprecated *prometheus.CounterVec
+}
+
+// NewVersioning returns versioning of the routes under prefix, e.g. /api/. The last version which
+// isn't deprecated is the default one.
+func NewVersioning(l *zap.Logger, reg prometheus.Registerer, prefix string, versions ...VersionSpec) *Versioning {
+	deprecated := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "http",
+		Name:      "deprecated_requests_total",
+		Help:      "The total number of requests to deprecated API versions and routes.",
+	}, []string{"version", "route"})
+	reg.MustRegister(deprecated)
+
+	v := &Versioning{
+		l:          l.Sugar(),
+		prefix:     strings.TrimSuffix(prefix, "/") + "/",
+		versions:   make(map[string]VersionSpec),
+		deprecated: deprecated,
+	}
+	for _, spec := range versions {
+		v.versions[spec.Name] = spec
+		v.names = append(v.names, spec.Name)
+		if spec.Deprecation == nil || v.def == "" {
+			v.def = spec.Name
+		}
+	}
+
+	return v
+}
+
+// Subrouter returns router of the version routes, e.g. /api/v1/users. Routes are deprecated
+// with WithDeprecation.
+func (v *Versioning) Subrouter(r *mux.Router, version string) *mux.Router {
+	spec, ok := v.versions[version]
+	if !ok {
+		panic("unknown API version: " + version)
+	}
+
+	sr := r.PathPrefix(v.prefix + version + "/").Subrouter()
+	sr.Use(v.handler(spec))
+	return sr
+}
+
+func (v *Versioning) handler(spec VersionSpec) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(xAPIVersion, spec.Name)
+
+			d := spec.Deprecation
+			if rd := RouteConfigFrom(r.Context()).Deprecation; rd != nil {
+				d = rd
+			}
+			if d != nil {
+				v.writeDeprecation(w.Header(), d)
+				tpl := ""
+				if route := mux.CurrentRoute(r); route != nil {
+					tpl, _ = route.GetPathTemplate()
+				}
+				v.deprecated.WithLabelValues(spec.Name, tpl).Inc()
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (v *Versioning) writeDeprecation(h http.Header, d *Deprecation) {
+	if !d.Since.IsZero() {
+		h.Set(deprecation, "@"+strconv.FormatInt(d.Since.Unix(), 10))
+	}
+	if !d.Sunset.IsZero() {
+		h.Set(sunset, d.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if d.Link != "" {
+		h.Add(link, "<"+d.Link+`>; rel="deprecation"; type="text/html"`)
+	}
+}
+
+// Negotiate wraps the router, so requests without version in the path are served by
+// the negotiated version. Routes without version, e.g. /api/health, are served as they are.
+func (v *Versioning) Negotiate(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, v.prefix) || v.hasVersion(r.URL.Path) {
+			router.ServeHTTP(w, r)
+			return
+		}
+		// routers with NotFoundHandler match every request, so the match error tells whether a route matched.
+		// Method mismatch counts when an unversioned route has the path, not the OPTIONS catch-all of CORS.
+		var match mux.RouteMatch
+		if matched := router.Match(r, &match); (matched && match.MatchErr == nil) || len(allowedMethods(router, r)) > 0 {
+			router.ServeHTTP(w, r)
+			return
+		}
+
+		version, err := v.negotiate(r)
+		if err != nil {
+			WriteErrJSON(v.l, w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		// the response depends on the negotiation headers
+		w.Header().Add(vary, "Accept, X-API-Version")
+
+		rewritten := new(http.Request)
+		*rewritten = *r
+		u := *r.URL
+		u.Path = v.prefix + version + "/" + strings.TrimPrefix(r.URL.Path, v.prefix)
+		u.RawPath = ""
+		rewritten.URL = &u
+
+		router.ServeHTTP(w, rewritten)
+	})
+}
+
+func (v *Versioning) hasVersion(path string) bool {
+	segment := strings.TrimPrefix(path, v.prefix)
+	if i := strings.IndexByte(segment, '/'); i >= 0 {
+		segment = segment[:i]
+	}
+	_, ok := v.versions[segment]
+	return ok
+}
+
+// negotiate returns version requested with X-API-Version header or Accept media type or the default one.
+func (v *Versioning) negotiate(r *http.Request) (string, error) {
+	if h := r.Header.Get(xAPIVersion); h != "" {
+		return v.lookup(h)
+	}
+
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		if version, ok := params["version"]; ok {
+			return v.lookup(version)
+		}
+		// application/vnd.<vendor>.<version>+json
+		if strings.HasPrefix(mt, "application/vnd.") && strings.HasSuffix(mt, "+json") {
+			base := strings.TrimSuffix(mt, "+json")
+			if i := strings.LastIndexByte(base, '.'); i > len("application/vnd") {
+				return v.lookup(base[i+1:])
+			}
+		}
+	}
+
+	return v.def, nil
+}
+
+func (v *Versioning) lookup(version string) (string, error) {
+	name := "v" + strings.TrimPrefix(strings.ToLower(strings.TrimSpace(version)), "v")
+	if _, ok := v.versions[name]; !ok {
+		return "", &RequestError{
+			Status: http.StatusBadRequest,
+			Msg:    "unsupported API version " + strconv.Quote(version) + ", supported versions: " + strings.Join(v.names, ", "),
+		}
+	}
+	return name, nil
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+var (
+	v1Sunset = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	v1Since  = time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// newVersionedRouter returns negotiating router, cors registers the OPTIONS catch-all of /api/ the way
+// routes.go does with CORS enabled.
+func newVersionedRouter(cors bool) (http.Handler, *Versioning) {
+	v := NewVersioning(zap.NewNop(), prometheus.NewRegistry(), "/api/",
+		VersionSpec{Name: "v1", Deprecation: &Deprecation{Since: v1Since, Sunset: v1Sunset, Link: "https://example.com/migrate-v2"}},
+		VersionSpec{Name: "v2"},
+	)
+
+	routes := NewRoutes()
+	r := mux.NewRouter()
+	r.Use(routes.Handler)
+	r.Use(VersionMiddleware)
+	served := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}
+	}
+	r.HandleFunc("/api/health", served("health")).Methods(http.MethodGet)
+	v.Subrouter(r, "v1").HandleFunc("/users", served("v1 users")).Methods(http.MethodGet)
+	v2 := v.Subrouter(r, "v2")
+	v2.HandleFunc("/users", served("v2 users")).Methods(http.MethodGet)
+	routes.Route(v2.HandleFunc("/legacy", served("v2 legacy")), WithDeprecation(Deprecation{Since: v1Since}))
+	fallback := NewFallback(zap.NewNop(), r)
+	fallback.Register()
+	if cors {
+		r.PathPrefix("/api/").Methods(http.MethodOptions).HandlerFunc(fallback.Options)
+	}
+
+	return v.Negotiate(r), v
+}
+
+func Test_Versioning_ShouldServeNegotiatedVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		header  map[string]string
+		status  int
+		body    string
+		version string
+	}{
+		{name: "version in path", path: "/api/v1/users", status: http.StatusOK, body: "v1 users", version: "v1"},
+		{name: "default version", path: "/api/users", status: http.StatusOK, body: "v2 users", version: "v2"},
+		{name: "version header", path: "/api/users", header: map[string]string{"X-API-Version": "1"}, status: http.StatusOK, body: "v1 users", version: "v1"},
+		{name: "vendor media type", path: "/api/users", header: map[string]string{"Accept": "application/vnd.app.v1+json"}, status: http.StatusOK, body: "v1 users", version: "v1"},
+		{name: "version media type parameter", path: "/api/users", header: map[string]string{"Accept": "text/html, application/json; version=v1"}, status: http.StatusOK, body: "v1 users", version: "v1"},
+		{name: "unknown version", path: "/api/users", header: map[string]string{"X-API-Version": "v9"}, status: http.StatusBadRequest},
+		{name: "unversioned route", path: "/api/health", header: map[string]string{"X-API-Version": "v1"}, status: http.StatusOK, body: "health", version: APIVersion},
+		{name: "unknown route", path: "/api/unknown", status: http.StatusNotFound},
+		{name: "unversioned route with other method", method: http.MethodPost, path: "/api/health", status: http.StatusMethodNotAllowed},
+		{name: "versioned route with other method", method: http.MethodPost, path: "/api/users", status: http.StatusMethodNotAllowed},
+	}
+
+	for _, cors := range []bool{false, true} {
+		// given
+		router, _ := newVersionedRouter(cors)
+
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s, cors: %t", tt.name, cors), func(t *testing.T) {
+				method := tt.method
+				if method == "" {
+					method = http.MethodGet
+				}
+				req := httptest.NewRequest(method, tt.path, nil)
+				for k, v := range tt.header {
+					req.Header.Set(k, v)
+				}
+				w := httptest.NewRecorder()
+
+				// when
+				router.ServeHTTP(w, req)
+
+				// then
+				assert.Equal(t, tt.status, w.Code)
+				if tt.status == http.StatusOK {
+					assert.Equal(t, tt.body, w.Body.String())
+					assert.Equal(t, tt.version, w.Header().Get("X-API-Version"))
+				}
+			})
+		}
+	}
+}
+
+func Test_Versioning_ShouldSetDeprecationHeaders(t *testing.T) {
+	// given
+	router, v := newVersionedRouter(false)
+
+	tests := []struct {
+		name        string
+		path        string
+		deprecation string
+		sunset      string
+		link        string
+	}{
+		{name: "deprecated version", path: "/api/v1/users", deprecation: "@1861920000", sunset: "Tue, 01 Jan 2030 00:00:00 GMT",
+			link: `<https://example.com/migrate-v2>; rel="deprecation"; type="text/html"`},
+		{name: "deprecated route", path: "/api/v2/legacy", deprecation: "@1861920000"},
+		{name: "current version", path: "/api/v2/users"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			// when
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			// then
+			assert.Equal(t, tt.deprecation, w.Header().Get("Deprecation"))
+			assert.Equal(t, tt.sunset, w.Header().Get("Sunset"))
+			assert.Equal(t, tt.link, w.Header().Get("Link"))
+		})
+	}
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(v.deprecated.WithLabelValues("v1", "/api/v1/users")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(v.deprecated.WithLabelValues("v2", "/api/v2/legacy")))
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrAPIKeyNotFound - returned by APIKeyStore when there is no key with the prefix.
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKey - API key of a machine client. Only SHA-256 hash of the secret part is stored,
+// the public prefix is used to look the key up.
+type APIKey struct {
+	Prefix     string     `json:"prefix"`
+	Hash       []byte     `json:"-"`
+	Owner      string     `json:"owner"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// Active returns true when the key is neither revoked nor expired at t.
+func (k *APIKey) Active(t time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || t.Before(*k.ExpiresAt))
+}
+
+// APIKeyStore interface describe storage of API keys.
+type APIKeyStore interface {
+
+	// Create - stores new key.
+	Create(ctx context.Context, key APIKey) error
+
+	// Get - returns key with the prefix or ErrAPIKeyNotFound.
+	Get(ctx context.Context, prefix string) (*APIKey, error)
+
+	// List - returns keys of the owner, all keys when owner is empty.
+	List(ctx context.Context, owner string) ([]APIKey, error)
+
+	// Rotate - stores the replacement key and makes the old one expire at oldExpiresAt.
+	Rotate(ctx context.Context, prefix string, replacement APIKey, oldExpiresAt time.Time) error
+
+	// Revoke - revokes key with the prefix, returns ErrAPIKeyNotFound when it doesn't exist.
+	Revoke(ctx context.Context, prefix string) error
+
+	// Touch - records the key was used at t.
+	Touch(ctx context.Context, prefix string, t time.Time) error
+}
@@ -0,0 +1,40 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Audit outcomes.
+const (
+	AuditSuccess = "success"
+	AuditDenied  = "denied"
+	AuditFailure = "failure"
+)
+
+// AuditEvent - record of a mutating request: who did what to which resource and with what result.
+type AuditEvent struct {
+	ID            int64           `json:"id"`
+	Time          time.Time       `json:"time"`
+	PrincipalID   string          `json:"principalId"`
+	PrincipalType string          `json:"principalType"`
+	Action        string          `json:"action"` // method and route, e.g. DELETE /admin/apikeys/{prefix}
+	Route         string          `json:"route"`
+	Target        string          `json:"target"` // path of the resource, e.g. /admin/apikeys/ab12cd34
+	RequestID     string          `json:"requestId"`
+	ClientIP      string          `json:"clientIp"`
+	Status        int             `json:"status"`
+	Outcome       string          `json:"outcome"`
+	Summary       json.RawMessage `json:"summary,omitempty"` // change details provided by the handler
+}
+
+// AuditStore interface describe append-only storage of audit events.
+type AuditStore interface {
+
+	// Append - stores the events.
+	Append(ctx context.Context, events []AuditEvent) error
+
+	// List - returns page of the events, one event more than the limit - see postgres.PageQuery.
+	List(ctx context.Context, req PageRequest) ([]AuditEvent, error)
+}
@@ -0,0 +1,10 @@
+package app
+
+import "context"
+
+// RoleStore interface describe storage of the role to permissions mapping.
+type RoleStore interface {
+
+	// RolePermissions - returns permissions granted by each role.
+	RolePermissions(ctx context.Context) (map[string][]string, error)
+}
@@ -0,0 +1,40 @@
+package app
+
+import (
+	"context"
+	"time"
+)
+
+// IdempotencyRecord - request made with an idempotency key and its response once completed.
+type IdempotencyRecord struct {
+	Key string
+	// Fingerprint - hash of the request, the key can't be reused with a different request
+	Fingerprint string
+	// Completed - false while the request is in progress
+	Completed bool
+	Status    int
+	Header    map[string][]string
+	Body      []byte
+	// BodyOmitted - the response body was too large to store, the response can't be replayed
+	BodyOmitted bool
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// IdempotencyStore interface describe storage of idempotency keys shared by all replicas.
+type IdempotencyStore interface {
+
+	// Begin - saves in-progress record unless the key has an unexpired one. Records in progress since
+	// before staleBefore are replaced - their request is considered lost. Returns true when the record
+	// was saved, otherwise the existing record.
+	Begin(ctx context.Context, rec IdempotencyRecord, staleBefore time.Time) (bool, *IdempotencyRecord, error)
+
+	// Complete - stores response of the in-progress record.
+	Complete(ctx context.Context, rec IdempotencyRecord) error
+
+	// Release - deletes in-progress record of the key, so the request can be retried.
+	Release(ctx context.Context, key string) error
+
+	// DeleteExpired - deletes records expired before now, returns number of deleted records.
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
@@ -8,3 +8,10 @@ type Repository interface {
 	// OK - returns bool flag whether connection to databse is up and running.
 	OK(context.Context) (bool, error)
 }
+
+// CircuitStater interface is implemented by repositories guarded by circuit breaker.
+type CircuitStater interface {
+
+	// CircuitState - returns state of the circuit: closed, open or half-open.
+	CircuitState() string
+}
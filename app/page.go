@@ -0,0 +1,43 @@
+package app
+
+// Filter operators.
+const (
+	FilterEq     = "eq"
+	FilterNe     = "ne"
+	FilterLt     = "lt"
+	FilterLte    = "lte"
+	FilterGt     = "gt"
+	FilterGte    = "gte"
+	FilterIn     = "in"
+	FilterPrefix = "prefix"
+)
+
+// Sort - order of the list by the field.
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+// Filter - condition on the field. Values has more than one value for FilterIn only.
+type Filter struct {
+	Field  string
+	Op     string
+	Values []string
+}
+
+// Cursor - position in the list, values of the sort fields of the boundary item.
+type Cursor struct {
+	Values []string
+	// Backward - page of the items before the position instead of after it
+	Backward bool
+}
+
+// PageRequest - keyset pagination request of a list.
+type PageRequest struct {
+	Limit int
+	// Sort - order of the items, the last field is unique, so the order is total
+	Sort    []Sort
+	Filters []Filter
+	// Cursor - nil for the first page
+	Cursor *Cursor
+}
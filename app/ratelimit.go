@@ -0,0 +1,30 @@
+package app
+
+import (
+	"context"
+	"time"
+)
+
+// RateLimit - number of requests allowed in a time window.
+type RateLimit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// RateLimitResult - outcome of taking a single request from the limit.
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration // time until the limit is fully restored
+	RetryAfter time.Duration // time to wait before the next request is allowed, set when not allowed
+}
+
+// RateLimitStore interface describe storage keeping rate limit state of the keys.
+type RateLimitStore interface {
+
+	// Take - counts request of the key against the limit.
+	Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+
+	// DeleteExpired - deletes state of the keys not counted since before, returns number of deleted keys.
+	DeleteExpired(ctx context.Context, before time.Time) (int64, error)
+}
@@ -0,0 +1,224 @@
+// Package audit writes audit events asynchronously to the store, falling back to a local file when
+// the store is failing or the buffer is full, so events aren't lost.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mateuszdyminski/go-template/app"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Config - audit writer settings.
+type Config struct {
+	// BufferSize - events waiting to be stored, when full they go straight to the fallback file
+	BufferSize int
+	// BatchSize - max events stored with a single call
+	BatchSize int
+	// FlushInterval - max time an event waits for a batch to fill up
+	FlushInterval time.Duration
+	// FallbackFile - JSON lines file receiving events the store didn't accept
+	FallbackFile string
+	// StoreTimeout - timeout of a single store call
+	StoreTimeout time.Duration
+}
+
+// Metrics - audit writer metrics.
+type Metrics struct {
+	Events *prometheus.CounterVec
+}
+
+// NewMetrics creates audit writer metrics and registers them with reg.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	events := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "audit",
+		Name:      "events_total",
+		Help:      "The total number of audit events by destination: store, fallback or lost.",
+	}, []string{"destination"})
+	reg.MustRegister(events)
+
+	return &Metrics{Events: events}
+}
+
+// Writer records audit events in batches in the background. Record never blocks the request.
+type Writer struct {
+	l     *zap.SugaredLogger
+	store app.AuditStore
+	cfg   Config
+	m     *Metrics
+
+	mu     sync.RWMutex // guards closing of events
+	closed bool
+	events chan app.AuditEvent
+	done   chan struct{}
+
+	fileMu     sync.Mutex
+	file       *os.File
+	fileClosed bool // set by Close, later fallback writes close the file right away
+}
+
+// NewWriter returns writer storing events in the background until Close is called.
+func NewWriter(l *zap.Logger, store app.AuditStore, cfg Config, m *Metrics) *Writer {
+	w := &Writer{
+		l:      l.Sugar(),
+		store:  store,
+		cfg:    cfg,
+		m:      m,
+		events: make(chan app.AuditEvent, cfg.BufferSize),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+
+	return w
+}
+
+// Record queues the event, it's written to the fallback file right away when the queue is full
+// or the writer is closed.
+func (w *Writer) Record(e app.AuditEvent) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		w.fallback([]app.AuditEvent{e}, errors.New("audit writer closed"))
+		return
+	}
+	select {
+	case w.events <- e:
+	default:
+		w.fallback([]app.AuditEvent{e}, errors.New("audit buffer full"))
+	}
+}
+
+// Close stops accepting events and waits until the queued ones are stored or ctx is done.
+// Events still queued when ctx is done are written to the fallback file. The fallback file is closed
+// in both cases, the batch being stored when ctx is done reopens it only to append the events on failure.
+func (w *Writer) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.events)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return w.closeFile()
+	case <-ctx.Done():
+		var left []app.AuditEvent
+		for e := range w.events {
+			left = append(left, e)
+		}
+		w.fallback(left, ctx.Err())
+		if err := w.closeFile(); err != nil {
+			w.l.Warnw("can't close audit fallback file", "err", err)
+		}
+		return errors.Wrap(ctx.Err(), "audit events not fully stored")
+	}
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]app.AuditEvent, 0, w.cfg.BatchSize)
+	for {
+		select {
+		case e, ok := <-w.events:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) >= w.cfg.BatchSize {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			w.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (w *Writer) flush(batch []app.AuditEvent) {
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), w.cfg.StoreTimeout)
+	defer cancel()
+
+	if err := w.store.Append(ctx, batch); err != nil {
+		w.fallback(batch, err)
+		return
+	}
+	w.m.Events.WithLabelValues("store").Add(float64(len(batch)))
+}
+
+// fallback appends events to the fallback file as JSON lines, events are logged when it fails too.
+func (w *Writer) fallback(events []app.AuditEvent, cause error) {
+	if len(events) == 0 {
+		return
+	}
+	w.l.Warnw("writing audit events to fallback file", "count", len(events), "file", w.cfg.FallbackFile, "err", cause)
+
+	w.fileMu.Lock()
+	defer w.fileMu.Unlock()
+
+	err := w.openFile()
+	for _, e := range events {
+		if err == nil {
+			var line []byte
+			if line, err = json.Marshal(e); err == nil {
+				_, err = w.file.Write(append(line, '\n'))
+			}
+		}
+		if err != nil {
+			w.m.Events.WithLabelValues("lost").Inc()
+			w.l.Errorw("audit event lost", "event", e, "err", err)
+			continue
+		}
+		w.m.Events.WithLabelValues("fallback").Inc()
+	}
+
+	if w.fileClosed && w.file != nil {
+		if err := w.file.Close(); err != nil {
+			w.l.Warnw("can't close audit fallback file", "err", err)
+		}
+		w.file = nil
+	}
+}
+
+func (w *Writer) openFile() error {
+	if w.file != nil {
+		return nil
+	}
+	f, err := os.OpenFile(w.cfg.FallbackFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Wrap(err, "can't open audit fallback file")
+	}
+	w.file = f
+	return nil
+}
+
+func (w *Writer) closeFile() error {
+	w.fileMu.Lock()
+	defer w.fileMu.Unlock()
+
+	w.fileClosed = true
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
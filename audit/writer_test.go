@@ -0,0 +1,180 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mateuszdyminski/go-template/app"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeStore struct {
+	mu      sync.Mutex
+	batches [][]app.AuditEvent
+	err     error
+}
+
+func (f *fakeStore) Append(ctx context.Context, events []app.AuditEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return f.err
+	}
+	f.batches = append(f.batches, append([]app.AuditEvent(nil), events...))
+	return nil
+}
+
+func (f *fakeStore) List(ctx context.Context, req app.PageRequest) ([]app.AuditEvent, error) {
+	return nil, nil
+}
+
+func newTestWriter(t *testing.T, store app.AuditStore, cfg Config) (*Writer, string) {
+	cfg.FallbackFile = filepath.Join(t.TempDir(), "fallback.jsonl")
+	cfg.StoreTimeout = time.Second
+	return NewWriter(zap.NewNop(), store, cfg, NewMetrics(prometheus.NewRegistry())), cfg.FallbackFile
+}
+
+func readFallback(t *testing.T, file string) []app.AuditEvent {
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var events []app.AuditEvent
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var e app.AuditEvent
+		require.NoError(t, json.Unmarshal(s.Bytes(), &e))
+		events = append(events, e)
+	}
+	return events
+}
+
+func Test_Writer_ShouldStoreEventsInBatches(t *testing.T) {
+	// given
+	store := &fakeStore{}
+	w, _ := newTestWriter(t, store, Config{BufferSize: 10, BatchSize: 2, FlushInterval: time.Hour})
+
+	// when
+	for i := 1; i <= 5; i++ {
+		w.Record(app.AuditEvent{RequestID: string(rune('0' + i))})
+	}
+	require.NoError(t, w.Close(context.Background()))
+
+	// then
+	require.Len(t, store.batches, 3)
+	assert.Len(t, store.batches[0], 2)
+	assert.Len(t, store.batches[1], 2)
+	assert.Equal(t, "5", store.batches[2][0].RequestID)
+}
+
+func Test_Writer_ShouldFlushOnInterval(t *testing.T) {
+	// given
+	store := &fakeStore{}
+	w, _ := newTestWriter(t, store, Config{BufferSize: 10, BatchSize: 100, FlushInterval: 10 * time.Millisecond})
+	defer w.Close(context.Background())
+
+	// when
+	w.Record(app.AuditEvent{RequestID: "1"})
+
+	// then
+	stored := func() int {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return len(store.batches)
+	}
+	for deadline := time.Now().Add(time.Second); stored() == 0 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, 1, stored())
+}
+
+func Test_Writer_ShouldFallBackToFileWhenStoreFails(t *testing.T) {
+	// given
+	store := &fakeStore{err: errors.New("db is down")}
+	w, file := newTestWriter(t, store, Config{BufferSize: 10, BatchSize: 10, FlushInterval: time.Hour})
+
+	// when
+	w.Record(app.AuditEvent{RequestID: "1", Action: "DELETE /api/items/{id}"})
+	w.Record(app.AuditEvent{RequestID: "2"})
+	require.NoError(t, w.Close(context.Background()))
+
+	// then
+	events := readFallback(t, file)
+	require.Len(t, events, 2)
+	assert.Equal(t, "DELETE /api/items/{id}", events[0].Action)
+	assert.Equal(t, "2", events[1].RequestID)
+}
+
+func Test_Writer_ShouldFallBackToFileWhenClosed(t *testing.T) {
+	// given
+	store := &fakeStore{}
+	w, file := newTestWriter(t, store, Config{BufferSize: 10, BatchSize: 10, FlushInterval: time.Hour})
+	require.NoError(t, w.Close(context.Background()))
+
+	// when
+	w.Record(app.AuditEvent{RequestID: "late"})
+
+	// then
+	events := readFallback(t, file)
+	require.Len(t, events, 1)
+	assert.Equal(t, "late", events[0].RequestID)
+	assert.Empty(t, store.batches)
+}
+
+type blockingStore struct {
+	release chan struct{}
+}
+
+func (b *blockingStore) Append(ctx context.Context, events []app.AuditEvent) error {
+	<-b.release
+	return errors.New("db is down")
+}
+
+func (b *blockingStore) List(ctx context.Context, req app.PageRequest) ([]app.AuditEvent, error) {
+	return nil, nil
+}
+
+func Test_Writer_ShouldCloseFallbackFileWhenCloseTimesOut(t *testing.T) {
+	// given
+	store := &blockingStore{release: make(chan struct{})}
+	w, file := newTestWriter(t, store, Config{BufferSize: 10, BatchSize: 1, FlushInterval: time.Hour})
+	w.Record(app.AuditEvent{RequestID: "stuck"})
+	w.Record(app.AuditEvent{RequestID: "queued"})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// when
+	err := w.Close(ctx)
+
+	// then
+	assert.Error(t, err)
+	w.fileMu.Lock()
+	assert.Nil(t, w.file, "fallback file should be closed")
+	w.fileMu.Unlock()
+
+	// when the stuck batch fails after Close returned
+	close(store.release)
+	<-w.done
+
+	// then
+	w.fileMu.Lock()
+	assert.Nil(t, w.file, "fallback file shouldn't stay open")
+	w.fileMu.Unlock()
+	var ids []string
+	for _, e := range readFallback(t, file) {
+		ids = append(ids, e.RequestID)
+	}
+	assert.ElementsMatch(t, []string{"stuck", "queued"}, ids)
+}
@@ -0,0 +1,288 @@
+// Package auth verifies JWT bearer tokens signed with keys published as JSON Web Key Set.
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// minimal time between attempts to load the key set, protects the JWKS endpoint against tokens
+// with random kids and against retries of every request while it's failing
+const minRefreshInterval = 30 * time.Second
+
+// JWKS - JSON Web Key Set loaded from URL or local file, cached and refreshed periodically.
+// Keys are also refreshed when a token signed with an unknown key ID shows up, to handle rotation.
+// Refreshes run in the background, one at a time and at most once per minRefreshInterval, verification
+// keeps using the old keys meanwhile. Only the first load and refreshes looking for an unknown key ID
+// are waited for.
+type JWKS struct {
+	source  string
+	refresh time.Duration
+	client  *http.Client
+	now     func() time.Time
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	lastErr     error         // error of the last load
+	loading     chan struct{} // closed when the load in progress finishes, nil when there is none
+	modTime     time.Time     // accessed by the loading goroutine only
+}
+
+// NewJWKS returns key set loaded from source - http(s) URL or file path - refreshed every refresh interval.
+func NewJWKS(source string, refresh time.Duration) *JWKS {
+	return &JWKS{
+		source:  source,
+		refresh: refresh,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		now:     time.Now,
+	}
+}
+
+// Key returns public key with the kid. Empty kid matches the only key of the set.
+func (j *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	if j.keys == nil || j.now().Sub(j.fetchedAt) >= j.refresh {
+		j.startLoad()
+	}
+	loading, loaded := j.loading, j.keys != nil
+	j.mu.Unlock()
+
+	if !loaded {
+		if err := j.wait(ctx, loading); err != nil {
+			return nil, err
+		}
+	}
+
+	if key, ok := j.lookup(kid); ok {
+		return key, nil
+	}
+
+	// the key might have been rotated
+	j.mu.Lock()
+	j.startLoad()
+	loading = j.loading
+	j.mu.Unlock()
+
+	if err := j.wait(ctx, loading); err != nil {
+		return nil, err
+	}
+	if key, ok := j.lookup(kid); ok {
+		return key, nil
+	}
+
+	return nil, errors.Errorf("unknown key ID %q", kid)
+}
+
+func (j *JWKS) lookup(kid string) (crypto.PublicKey, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if kid == "" && len(j.keys) == 1 {
+		for _, k := range j.keys {
+			return k, true
+		}
+	}
+	key, ok := j.keys[kid]
+	return key, ok
+}
+
+// startLoad loads the key set in the background, unless it's being loaded already or the last attempt
+// was made less than minRefreshInterval ago - failing source isn't hit by every request.
+// It must be called with the lock held.
+func (j *JWKS) startLoad() {
+	now := j.now()
+	if j.loading != nil || now.Sub(j.attemptedAt) < minRefreshInterval {
+		return
+	}
+	j.attemptedAt = now
+
+	done := make(chan struct{})
+	j.loading = done
+	go func() {
+		defer close(done)
+		// the load outlives the request which started it, it's bounded by the client timeout
+		keys, err := j.load(context.Background())
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		j.loading = nil
+		j.lastErr = err
+		if err != nil {
+			return
+		}
+		if keys != nil {
+			j.keys = keys
+		}
+		j.fetchedAt = j.now()
+	}()
+}
+
+// wait waits for the load to finish and returns error of the last load.
+func (j *JWKS) wait(ctx context.Context, loading <-chan struct{}) error {
+	if loading != nil {
+		select {
+		case <-loading:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.lastErr
+}
+
+// load fetches the key set, it returns nil keys when the file wasn't modified since the last load.
+func (j *JWKS) load(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	if strings.HasPrefix(j.source, "http://") || strings.HasPrefix(j.source, "https://") {
+		body, err := j.fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return ParseJWKS(body)
+	}
+
+	fi, err := os.Stat(j.source)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read JWKS file")
+	}
+	if fi.ModTime().Equal(j.modTime) {
+		return nil, nil
+	}
+	body, err := ioutil.ReadFile(j.source)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read JWKS file")
+	}
+	keys, err := ParseJWKS(body)
+	if err != nil {
+		return nil, err
+	}
+	j.modTime = fi.ModTime()
+
+	return keys, nil
+}
+
+func (j *JWKS) fetch(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, j.source, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid JWKS URL")
+	}
+
+	resp, err := j.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't fetch JWKS")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("can't fetch JWKS, status: %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read JWKS")
+	}
+
+	return body, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS returns signature verification keys of the set by their IDs.
+// Keys of unsupported types and encryption keys are skipped.
+func ParseJWKS(body []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, errors.Wrap(err, "invalid JWKS")
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid key %q", k.Kid)
+		}
+		if key != nil {
+			keys[k.Kid] = key
+		}
+	}
+
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch {
+	case k.Kty == "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !key.Curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return key, nil
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid base64url number")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
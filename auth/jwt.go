@@ -0,0 +1,268 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Claims - verified claims of the token.
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	Scopes    []string
+	Roles     []string
+	// Raw - all claims of the token, numbers are json.Number.
+	Raw map[string]interface{}
+}
+
+// HasScope returns true when the token was granted the scope.
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// KeySource returns the public key identified by the kid.
+type KeySource interface {
+	Key(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+// Verifier verifies signature and registered claims of JWTs.
+type Verifier struct {
+	keys     KeySource
+	issuer   string
+	audience string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+// NewVerifier returns verifier accepting RS256, ES256 and EdDSA tokens signed with the keys.
+// Empty issuer or audience are not checked. Leeway is the allowed clock skew.
+func NewVerifier(keys KeySource, issuer, audience string, leeway time.Duration) *Verifier {
+	return &Verifier{
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+		leeway:   leeway,
+		now:      time.Now,
+	}
+}
+
+// Verify returns claims of the valid token.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errors.Wrap(err, "malformed token header")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+
+	key, err := v.keys.Key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return nil, errors.Wrap(err, "malformed token claims")
+	}
+
+	claims, err := parseClaims(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := v.validate(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (v *Verifier) validate(c *Claims) error {
+	now := v.now()
+
+	if c.ExpiresAt.IsZero() {
+		return errors.New("token has no expiration time")
+	}
+	if now.After(c.ExpiresAt.Add(v.leeway)) {
+		return errors.New("token expired")
+	}
+	if !c.NotBefore.IsZero() && now.Add(v.leeway).Before(c.NotBefore) {
+		return errors.New("token not valid yet")
+	}
+	if !c.IssuedAt.IsZero() && now.Add(v.leeway).Before(c.IssuedAt) {
+		return errors.New("token issued in the future")
+	}
+	if v.issuer != "" && c.Issuer != v.issuer {
+		return errors.Errorf("invalid token issuer %q", c.Issuer)
+	}
+	if v.audience != "" && !contains(c.Audience, v.audience) {
+		return errors.New("token not issued for this audience")
+	}
+
+	return nil
+}
+
+// verifySignature checks the signature, the key type must match the algorithm to prevent
+// algorithm confusion.
+func verifySignature(alg string, key crypto.PublicKey, input string, sig []byte) error {
+	invalid := errors.New("invalid token signature")
+
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key doesn't match RS256 algorithm")
+		}
+		hash := sha256.Sum256([]byte(input))
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], sig); err != nil {
+			return invalid
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("key doesn't match ES256 algorithm")
+		}
+		if len(sig) != 64 {
+			return invalid
+		}
+		hash := sha256.Sum256([]byte(input))
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, hash[:], r, s) {
+			return invalid
+		}
+	case "EdDSA":
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return errors.New("key doesn't match EdDSA algorithm")
+		}
+		if !ed25519.Verify(pub, []byte(input), sig) {
+			return invalid
+		}
+	default:
+		return errors.Errorf("unsupported token algorithm %q", alg)
+	}
+
+	return nil
+}
+
+func parseClaims(raw map[string]interface{}) (*Claims, error) {
+	c := &Claims{Raw: raw}
+	c.Issuer, _ = raw["iss"].(string)
+	c.Subject, _ = raw["sub"].(string)
+	c.Audience = stringOrList(raw["aud"])
+	c.Roles = stringOrList(raw["roles"])
+
+	// "scope" is space delimited (RFC 8693), some providers use "scp" list instead
+	if scope, ok := raw["scope"].(string); ok {
+		c.Scopes = strings.Fields(scope)
+	} else {
+		c.Scopes = stringOrList(raw["scp"])
+	}
+
+	var err error
+	for claim, dst := range map[string]*time.Time{"exp": &c.ExpiresAt, "nbf": &c.NotBefore, "iat": &c.IssuedAt} {
+		if *dst, err = numericDate(raw[claim]); err != nil {
+			return nil, errors.Wrapf(err, "invalid %s claim", claim)
+		}
+	}
+
+	return c, nil
+}
+
+// maxNumericDate - seconds since epoch which float64 represents exactly, later dates are rejected
+const maxNumericDate = 1 << 53
+
+func numericDate(v interface{}) (time.Time, error) {
+	if v == nil {
+		return time.Time{}, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, errors.New("not a number")
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if math.Abs(f) > maxNumericDate {
+		return time.Time{}, errors.New("out of range")
+	}
+	// nanoseconds since epoch overflow int64 in 2262, seconds don't
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*float64(time.Second))), nil
+}
+
+func stringOrList(v interface{}) []string {
+	switch t := v.(type) {
+	case string:
+		if strings.Contains(t, " ") {
+			return strings.Fields(t)
+		}
+		return []string{t}
+	case []interface{}:
+		list := make([]string, 0, len(t))
+		for _, s := range t {
+			if str, ok := s.(string); ok {
+				list = append(list, str)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+func decodeSegment(seg string, dst interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(dst)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
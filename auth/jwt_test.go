@@ -0,0 +1,301 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Unix(1600000000, 0)
+
+type testKey struct {
+	kid  string
+	alg  string
+	priv crypto.Signer
+}
+
+func newTestKeys(t *testing.T) []testKey {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	return []testKey{
+		{kid: "rsa", alg: "RS256", priv: rsaKey},
+		{kid: "ec", alg: "ES256", priv: ecKey},
+		{kid: "ed", alg: "EdDSA", priv: edKey},
+	}
+}
+
+func writeJWKS(t *testing.T, path string, keys ...testKey) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for _, k := range keys {
+		switch pub := k.priv.Public().(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, map[string]string{"kty": "RSA", "kid": k.kid, "use": "sig",
+				"n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes())})
+		case *ecdsa.PublicKey:
+			set.Keys = append(set.Keys, map[string]string{"kty": "EC", "kid": k.kid, "crv": "P-256",
+				"x": b64(pub.X.FillBytes(make([]byte, 32))), "y": b64(pub.Y.FillBytes(make([]byte, 32)))})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, map[string]string{"kty": "OKP", "kid": k.kid, "crv": "Ed25519", "x": b64(pub)})
+		}
+	}
+
+	body, err := json.Marshal(set)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path, body, 0600))
+}
+
+func sign(t *testing.T, k testKey, alg string, claims map[string]interface{}) string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": k.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := b64(header) + "." + b64(payload)
+	hash := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	var err error
+	switch priv := k.priv.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, hash[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, priv, hash[:])
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(priv, []byte(input))
+	}
+	require.NoError(t, err)
+
+	return input + "." + b64(sig)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   "https://issuer.example.com",
+		"sub":   "user-1",
+		"aud":   []string{"other", "go-template"},
+		"exp":   now.Add(time.Minute).Unix(),
+		"iat":   now.Unix(),
+		"scope": "read write",
+	}
+}
+
+func newTestVerifier(t *testing.T, keys ...testKey) (*Verifier, *JWKS, string) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, keys...)
+
+	jwks := NewJWKS(path, time.Hour)
+	jwks.now = func() time.Time { return now }
+	v := NewVerifier(jwks, "https://issuer.example.com", "go-template", 30*time.Second)
+	v.now = func() time.Time { return now }
+
+	return v, jwks, path
+}
+
+func Test_Verifier_ShouldAcceptTokensOfSupportedAlgorithms(t *testing.T) {
+	// given
+	keys := newTestKeys(t)
+	v, _, _ := newTestVerifier(t, keys...)
+
+	for _, k := range keys {
+		t.Run(k.alg, func(t *testing.T) {
+			// when
+			claims, err := v.Verify(context.Background(), sign(t, k, k.alg, validClaims()))
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, "user-1", claims.Subject)
+			assert.Equal(t, []string{"read", "write"}, claims.Scopes)
+			assert.True(t, claims.HasScope("write"))
+		})
+	}
+}
+
+func Test_Verifier_ShouldRejectInvalidTokens(t *testing.T) {
+	// given
+	keys := newTestKeys(t)
+	v, _, _ := newTestVerifier(t, keys...)
+	with := func(claim string, value interface{}) map[string]interface{} {
+		c := validClaims()
+		if value == nil {
+			delete(c, claim)
+		} else {
+			c[claim] = value
+		}
+		return c
+	}
+
+	tests := map[string]string{
+		"alg none":            sign(t, keys[0], "none", validClaims()),
+		"alg confusion":       sign(t, keys[1], "RS256", validClaims()),
+		"expired":             sign(t, keys[0], "RS256", with("exp", now.Add(-time.Minute).Unix())),
+		"not valid yet":       sign(t, keys[0], "RS256", with("nbf", now.Add(time.Minute).Unix())),
+		"missing expiration":  sign(t, keys[0], "RS256", with("exp", nil)),
+		"wrong issuer":        sign(t, keys[0], "RS256", with("iss", "https://evil.example.com")),
+		"wrong audience":      sign(t, keys[0], "RS256", with("aud", "other")),
+		"unknown key":         sign(t, testKey{kid: "unknown", priv: keys[0].priv}, "RS256", validClaims()),
+		"tampered token":      sign(t, keys[0], "RS256", validClaims())[:100] + "x" + sign(t, keys[0], "RS256", validClaims())[101:],
+		"malformed token":     "not-a-token",
+		"invalid claims type": sign(t, keys[0], "RS256", with("exp", "tomorrow")),
+		"expiration overflow": sign(t, keys[0], "RS256", with("exp", 1e300)),
+	}
+
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			// when
+			_, err := v.Verify(context.Background(), token)
+
+			// then
+			assert.Error(t, err)
+		})
+	}
+}
+
+func Test_Verifier_ShouldAllowClockSkew(t *testing.T) {
+	// given
+	keys := newTestKeys(t)
+	v, _, _ := newTestVerifier(t, keys...)
+	claims := validClaims()
+	claims["exp"] = now.Add(-20 * time.Second).Unix()
+	claims["nbf"] = now.Add(20 * time.Second).Unix()
+
+	// when
+	_, err := v.Verify(context.Background(), sign(t, keys[0], "RS256", claims))
+
+	// then
+	assert.NoError(t, err)
+}
+
+func Test_Verifier_ShouldAcceptExpirationBeyondNanosecondRange(t *testing.T) {
+	// given
+	keys := newTestKeys(t)
+	v, _, _ := newTestVerifier(t, keys...)
+	claims := validClaims()
+	claims["exp"] = 1e12 // year 33658, int64 nanoseconds end in 2262
+
+	// when
+	c, err := v.Verify(context.Background(), sign(t, keys[0], "RS256", claims))
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, int64(1e12), c.ExpiresAt.Unix())
+}
+
+func Test_JWKS_ShouldReloadKeysOnUnknownKeyID(t *testing.T) {
+	// given
+	keys := newTestKeys(t)
+	v, jwks, path := newTestVerifier(t, keys[0])
+	_, err := v.Verify(context.Background(), sign(t, keys[0], "RS256", validClaims()))
+	require.NoError(t, err)
+
+	// when
+	writeJWKS(t, path, keys[0], keys[1])
+	require.NoError(t, os.Chtimes(path, now, now.Add(time.Minute)))
+	_, beforeThrottle := v.Verify(context.Background(), sign(t, keys[1], "ES256", validClaims()))
+	jwks.now = func() time.Time { return now.Add(minRefreshInterval) }
+	_, afterThrottle := v.Verify(context.Background(), sign(t, keys[1], "ES256", validClaims()))
+
+	// then
+	assert.Error(t, beforeThrottle, "reload should be throttled right after the initial load")
+	assert.NoError(t, afterThrottle)
+}
+
+func Test_JWKS_ShouldRefreshInBackgroundAndBackOffOnFailure(t *testing.T) {
+	// given
+	keys := newTestKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, keys[0])
+	var hits int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			http.ServeFile(w, r, path)
+			return
+		}
+		<-release
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	clock := now
+	jwks := NewJWKS(srv.URL, time.Minute)
+	jwks.now = func() time.Time { return clock }
+	_, err := jwks.Key(context.Background(), "rsa")
+	require.NoError(t, err)
+	loaded := func() {
+		jwks.mu.Lock()
+		loading := jwks.loading
+		jwks.mu.Unlock()
+		if loading != nil {
+			<-loading
+		}
+	}
+
+	// when keys are stale and the refresh hangs
+	clock = clock.Add(2 * time.Minute)
+	for i := 0; i < 5; i++ {
+		_, err := jwks.Key(context.Background(), "rsa")
+		assert.NoError(t, err, "old keys should be used while refreshing")
+	}
+	close(release)
+	loaded()
+
+	// then
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+
+	// when refresh failed
+	for i := 0; i < 5; i++ {
+		_, err := jwks.Key(context.Background(), "rsa")
+		assert.NoError(t, err)
+	}
+	loaded()
+
+	// then
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits), "failed refresh shouldn't be retried by every request")
+
+	// when backoff passed
+	clock = clock.Add(minRefreshInterval)
+	_, err = jwks.Key(context.Background(), "rsa")
+	loaded()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&hits))
+}
+
+func Test_ParseJWKS_ShouldSkipEncryptionAndUnsupportedKeys(t *testing.T) {
+	// given
+	body := []byte(`{"keys":[{"kty":"RSA","kid":"enc","use":"enc","n":"AQAB","e":"AQAB"},{"kty":"oct","kid":"hmac","k":"c2VjcmV0"}]}`)
+
+	// when
+	keys, err := ParseJWKS(body)
+
+	// then
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
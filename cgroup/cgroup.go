@@ -0,0 +1,262 @@
+// Package cgroup reads CPU and memory limits imposed on the current process
+// by Linux control groups. Both cgroup v1 and the unified v2 hierarchy are supported.
+package cgroup
+
+import (
+	"bufio"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// v1 reports "no limit" as a huge page-aligned number instead of a keyword.
+const v1UnlimitedMemory = int64(1) << 62
+
+// Limits - resource limits found for the current process.
+type Limits struct {
+	// Version - cgroup version the limits were read from, 0 when no cgroup was found.
+	Version int
+	// CPUQuota - number of CPUs the process may use, 0 when unlimited.
+	CPUQuota float64
+	// MemoryLimit - memory limit in bytes, 0 when unlimited.
+	MemoryLimit int64
+}
+
+// MaxProcs returns the GOMAXPROCS value matching the CPU quota or fallback when the quota is unlimited.
+func (l Limits) MaxProcs(fallback int) int {
+	if l.CPUQuota <= 0 {
+		return fallback
+	}
+
+	procs := int(math.Floor(l.CPUQuota))
+	if procs < 1 {
+		procs = 1
+	}
+
+	return procs
+}
+
+// MemLimit returns the soft memory limit which leaves (1 - ratio) of the cgroup memory limit as headroom.
+// Returns 0 when memory is unlimited.
+func (l Limits) MemLimit(ratio float64) int64 {
+	if l.MemoryLimit <= 0 {
+		return 0
+	}
+
+	return int64(float64(l.MemoryLimit) * ratio)
+}
+
+// Reader reads cgroup limits from the filesystem mounted under root.
+type Reader struct {
+	root string
+}
+
+// NewReader returns Reader which resolves /proc and /sys paths relative to root.
+// Use "/" in production and a fake directory tree in tests.
+func NewReader(root string) *Reader {
+	return &Reader{root: root}
+}
+
+// Read returns limits of the current process. Missing cgroup files are not an error
+// - the corresponding limit is reported as unlimited.
+func (r *Reader) Read() (Limits, error) {
+	paths, err := r.procPaths()
+	if err != nil {
+		return Limits{}, err
+	}
+
+	if r.exists("sys/fs/cgroup/cgroup.controllers") {
+		return r.readV2(paths[""])
+	}
+
+	return r.readV1(paths)
+}
+
+// procPaths parses /proc/self/cgroup into controller -> cgroup path map.
+// The unified (v2) hierarchy is stored under the empty controller name.
+func (r *Reader) procPaths() (map[string]string, error) {
+	f, err := os.Open(r.path("proc/self/cgroup"))
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "can't open /proc/self/cgroup")
+	}
+	defer f.Close()
+
+	paths := make(map[string]string)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(s.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		for _, c := range strings.Split(parts[1], ",") {
+			paths[c] = parts[2]
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrap(err, "can't read /proc/self/cgroup")
+	}
+
+	return paths, nil
+}
+
+func (r *Reader) readV2(group string) (Limits, error) {
+	l := Limits{Version: 2}
+
+	// limits are hierarchical - the effective one is the lowest on the way up to the root
+	for _, dir := range ancestors("sys/fs/cgroup", group) {
+		quota, err := r.readV2CPU(dir)
+		if err != nil {
+			return Limits{}, err
+		}
+		if quota > 0 && (l.CPUQuota == 0 || quota < l.CPUQuota) {
+			l.CPUQuota = quota
+		}
+
+		mem, err := r.readInt(path.Join(dir, "memory.max"))
+		if err != nil {
+			return Limits{}, err
+		}
+		if mem > 0 && (l.MemoryLimit == 0 || mem < l.MemoryLimit) {
+			l.MemoryLimit = mem
+		}
+	}
+
+	return l, nil
+}
+
+// readV2CPU parses cpu.max which has format "$MAX $PERIOD" where $MAX may be "max".
+func (r *Reader) readV2CPU(dir string) (float64, error) {
+	content, err := r.readFile(path.Join(dir, "cpu.max"))
+	if err != nil || content == "" {
+		return 0, err
+	}
+
+	fields := strings.Fields(content)
+	if len(fields) != 2 {
+		return 0, errors.Errorf("invalid cpu.max format: %q", content)
+	}
+	if fields[0] == "max" {
+		return 0, nil
+	}
+
+	quota, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid cpu.max quota: %q", content)
+	}
+	period, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || period <= 0 {
+		return 0, errors.Errorf("invalid cpu.max period: %q", content)
+	}
+
+	return quota / period, nil
+}
+
+func (r *Reader) readV1(paths map[string]string) (Limits, error) {
+	l := Limits{}
+
+	if dir, ok := r.v1Dir(paths, "cpu", "cpu,cpuacct", "cpu"); ok {
+		l.Version = 1
+		quota, err := r.readInt(path.Join(dir, "cpu.cfs_quota_us"))
+		if err != nil {
+			return Limits{}, err
+		}
+		period, err := r.readInt(path.Join(dir, "cpu.cfs_period_us"))
+		if err != nil {
+			return Limits{}, err
+		}
+		// quota is -1 when unlimited
+		if quota > 0 && period > 0 {
+			l.CPUQuota = float64(quota) / float64(period)
+		}
+	}
+
+	if dir, ok := r.v1Dir(paths, "memory", "memory"); ok {
+		l.Version = 1
+		mem, err := r.readInt(path.Join(dir, "memory.limit_in_bytes"))
+		if err != nil {
+			return Limits{}, err
+		}
+		if mem > 0 && mem < v1UnlimitedMemory {
+			l.MemoryLimit = mem
+		}
+	}
+
+	return l, nil
+}
+
+// v1Dir returns directory of the controller's cgroup. Inside a container the path from
+// /proc/self/cgroup usually belongs to the host namespace, so the mount point itself is used as a fallback.
+func (r *Reader) v1Dir(paths map[string]string, controller string, mounts ...string) (string, bool) {
+	for _, m := range mounts {
+		mount := path.Join("sys/fs/cgroup", m)
+		if !r.exists(mount) {
+			continue
+		}
+		if group, ok := paths[controller]; ok {
+			if dir := path.Join(mount, group); r.exists(dir) {
+				return dir, true
+			}
+		}
+		return mount, true
+	}
+
+	return "", false
+}
+
+// readInt reads a single integer from the file. Returns 0 when the file is missing or contains "max".
+func (r *Reader) readInt(name string) (int64, error) {
+	content, err := r.readFile(name)
+	if err != nil || content == "" || content == "max" {
+		return 0, err
+	}
+
+	v, err := strconv.ParseInt(content, 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid value in %s", name)
+	}
+
+	return v, nil
+}
+
+// readFile returns trimmed content of the file or empty string when the file doesn't exist.
+func (r *Reader) readFile(name string) (string, error) {
+	b, err := ioutil.ReadFile(r.path(name))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "can't read %s", name)
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (r *Reader) exists(name string) bool {
+	_, err := os.Stat(r.path(name))
+	return err == nil
+}
+
+func (r *Reader) path(name string) string {
+	return filepath.Join(r.root, filepath.FromSlash(name))
+}
+
+// ancestors returns mount/group and all its parent directories up to mount.
+func ancestors(mount, group string) []string {
+	dirs := []string{}
+	for group = path.Clean("/" + group); ; group = path.Dir(group) {
+		dirs = append(dirs, path.Join(mount, group))
+		if group == "/" {
+			return dirs
+		}
+	}
+}
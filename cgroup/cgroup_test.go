@@ -0,0 +1,129 @@
+package cgroup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeFS creates fake root filesystem with given files.
+func fakeFS(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("can't create dir: %s", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("can't write file: %s", err)
+		}
+	}
+
+	return root
+}
+
+func Test_Read_ShouldReturnV2Limits(t *testing.T) {
+	// given
+	root := fakeFS(t, map[string]string{
+		"proc/self/cgroup":                 "0::/\n",
+		"sys/fs/cgroup/cgroup.controllers": "cpu memory\n",
+		"sys/fs/cgroup/cpu.max":            "250000 100000\n",
+		"sys/fs/cgroup/memory.max":         "536870912\n",
+	})
+
+	// when
+	limits, err := NewReader(root).Read()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, Limits{Version: 2, CPUQuota: 2.5, MemoryLimit: 536870912}, limits)
+	assert.Equal(t, 2, limits.MaxProcs(8))
+	assert.Equal(t, int64(483183820), limits.MemLimit(0.9))
+}
+
+func Test_Read_ShouldReturnLowestV2LimitsInHierarchy(t *testing.T) {
+	// given
+	root := fakeFS(t, map[string]string{
+		"proc/self/cgroup":                            "0::/app/worker\n",
+		"sys/fs/cgroup/cgroup.controllers":            "cpu memory\n",
+		"sys/fs/cgroup/app/cpu.max":                   "50000 100000\n",
+		"sys/fs/cgroup/app/memory.max":                "max\n",
+		"sys/fs/cgroup/app/worker/cpu.max":            "max 100000\n",
+		"sys/fs/cgroup/app/worker/memory.max":         "1073741824\n",
+		"sys/fs/cgroup/app/worker/cgroup.controllers": "cpu memory\n",
+	})
+
+	// when
+	limits, err := NewReader(root).Read()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, Limits{Version: 2, CPUQuota: 0.5, MemoryLimit: 1073741824}, limits)
+	assert.Equal(t, 1, limits.MaxProcs(8), "quota below one CPU should still give one proc")
+}
+
+func Test_Read_ShouldReturnV1Limits(t *testing.T) {
+	// given
+	root := fakeFS(t, map[string]string{
+		"proc/self/cgroup":                            "4:memory:/docker/abc\n3:cpu,cpuacct:/docker/abc\n",
+		"sys/fs/cgroup/cpu,cpuacct/cpu.cfs_quota_us":  "400000\n",
+		"sys/fs/cgroup/cpu,cpuacct/cpu.cfs_period_us": "100000\n",
+		"sys/fs/cgroup/memory/memory.limit_in_bytes":  "268435456\n",
+	})
+
+	// when
+	limits, err := NewReader(root).Read()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, Limits{Version: 1, CPUQuota: 4, MemoryLimit: 268435456}, limits)
+}
+
+func Test_Read_ShouldReturnUnlimitedForV1Defaults(t *testing.T) {
+	// given
+	root := fakeFS(t, map[string]string{
+		"proc/self/cgroup":                            "4:memory:/\n3:cpu,cpuacct:/\n",
+		"sys/fs/cgroup/cpu,cpuacct/cpu.cfs_quota_us":  "-1\n",
+		"sys/fs/cgroup/cpu,cpuacct/cpu.cfs_period_us": "100000\n",
+		"sys/fs/cgroup/memory/memory.limit_in_bytes":  "9223372036854771712\n",
+	})
+
+	// when
+	limits, err := NewReader(root).Read()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, Limits{Version: 1}, limits)
+	assert.Equal(t, 8, limits.MaxProcs(8))
+	assert.Equal(t, int64(0), limits.MemLimit(0.9))
+}
+
+func Test_Read_ShouldReturnNoLimitsWithoutCgroups(t *testing.T) {
+	// given
+	root := fakeFS(t, map[string]string{})
+
+	// when
+	limits, err := NewReader(root).Read()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, Limits{}, limits)
+}
+
+func Test_Read_ShouldReturnErrorOnInvalidContent(t *testing.T) {
+	// given
+	root := fakeFS(t, map[string]string{
+		"proc/self/cgroup":                 "0::/\n",
+		"sys/fs/cgroup/cgroup.controllers": "cpu memory\n",
+		"sys/fs/cgroup/cpu.max":            "abc\n",
+	})
+
+	// when
+	_, err := NewReader(root).Read()
+
+	// then
+	assert.Error(t, err)
+}
@@ -1,59 +1,366 @@
 package main
 
 import (
+	"strings"
+
+	"github.com/mateuszdyminski/go-template/api"
+	"github.com/mateuszdyminski/go-template/redact"
+	"github.com/mateuszdyminski/go-template/repository/postgres"
+
+	"github.com/pkg/errors"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
 type config struct {
-	httpPort            int
-	httpPprofPort       int
-	httpGracefulTimeout int
-	httpGracefulSleep   int
-	pgHost              string
-	pgPort              int
-	pgUser              string
-	pgDBName            string
-	pgPassword          string
+	httpPort              int      `config:"http_port"`
+	httpAdminPort         int      `config:"http_admin_port"`
+	httpAdminHost         string   `config:"http_admin_host"`
+	httpAdminToken        string   `config:"http_admin_token" secret:"true"`
+	httpGracefulTimeout   int      `config:"http_graceful_timeout"`
+	httpDrainQuietPeriod  int      `config:"http_drain_quiet_period"`
+	httpDrainTimeout      int      `config:"http_drain_timeout"`
+	httpUpgradeTimeout    int      `config:"http_upgrade_timeout"`
+	httpReadTimeout       int      `config:"http_read_timeout"`
+	httpReadHeaderTimeout int      `config:"http_read_header_timeout"`
+	httpWriteTimeout      int      `config:"http_write_timeout"`
+	httpIdleTimeout       int      `config:"http_idle_timeout"`
+	httpMaxHeaderBytes    int      `config:"http_max_header_bytes"`
+	httpMaxConns          int      `config:"http_max_conns"`
+	httpMaxConnsPerIP     int      `config:"http_max_conns_per_ip"`
+	httpAdminMaxConns     int      `config:"http_admin_max_conns"`
+	httpTrustedProxies    []string `config:"http_trusted_proxies"`
+	httpProxyProtocol     bool     `config:"http_proxy_protocol"`
+	rateLimitRequests     int      `config:"ratelimit_requests"`
+	rateLimitWindow       int      `config:"ratelimit_window"`
+	rateLimitKey          string   `config:"ratelimit_key"`
+	rateLimitStore        string   `config:"ratelimit_store"`
+	concurrencyInitial    int      `config:"concurrency_initial_limit"`
+	concurrencyMin        int      `config:"concurrency_min_limit"`
+	concurrencyMax        int      `config:"concurrency_max_limit"`
+	concurrencyLatency    int      `config:"concurrency_target_latency_ms"`
+	concurrencyBackoff    float64  `config:"concurrency_backoff"`
+	breakerFailures       int      `config:"postgres_breaker_failure_threshold"`
+	breakerOpenTimeout    int      `config:"postgres_breaker_open_timeout"`
+	breakerHalfOpenCalls  int      `config:"postgres_breaker_half_open_calls"`
+	breakerSuccesses      int      `config:"postgres_breaker_success_threshold"`
+	jwtJWKSURL            string   `config:"jwt_jwks_url"`
+	jwtJWKSFile           string   `config:"jwt_jwks_file"`
+	jwtJWKSRefresh        int      `config:"jwt_jwks_refresh"`
+	jwtIssuer             string   `config:"jwt_issuer"`
+	jwtAudience           string   `config:"jwt_audience"`
+	jwtClockSkew          int      `config:"jwt_clock_skew"`
+	apiKeyEnabled         bool     `config:"apikey_enabled"`
+	apiKeyCacheTTL        int      `config:"apikey_cache_ttl"`
+	authzRoles            string   `config:"authz_roles"`
+	authzStore            string   `config:"authz_store"`
+	authzRefresh          int      `config:"authz_refresh"`
+	corsAllowedOrigins    []string `config:"cors_allowed_origins"`
+	corsAllowedMethods    []string `config:"cors_allowed_methods"`
+	corsAllowedHeaders    []string `config:"cors_allowed_headers"`
+	corsExposedHeaders    []string `config:"cors_exposed_headers"`
+	corsAllowCredentials  bool     `config:"cors_allow_credentials"`
+	corsMaxAge            int      `config:"cors_max_age"`
+	hstsMaxAge            int      `config:"security_hsts_max_age"`
+	hstsIncludeSubdomains bool     `config:"security_hsts_include_subdomains"`
+	hstsPreload           bool     `config:"security_hsts_preload"`
+	frameOptions          string   `config:"security_frame_options"`
+	referrerPolicy        string   `config:"security_referrer_policy"`
+	permissionsPolicy     string   `config:"security_permissions_policy"`
+	csp                   string   `config:"security_csp"`
+	swaggerCSP            string   `config:"security_swagger_csp"`
+	accessLogFormat       string   `config:"access_log_format"`
+	accessLogSampleRate   float64  `config:"access_log_sample_rate"`
+	accessLogSlowMs       int      `config:"access_log_slow_ms"`
+	accessLogOutput       string   `config:"access_log_output"`
+	captureMaxBytes       int      `config:"capture_max_bytes"`
+	captureBufferSize     int      `config:"capture_buffer_size"`
+	captureSecret         string   `config:"capture_secret" secret:"true"`
+	captureRoutes         []string `config:"capture_routes"`
+	captureAccessLog      bool     `config:"capture_access_log"`
+	auditEnabled          bool     `config:"audit_enabled"`
+	auditBufferSize       int      `config:"audit_buffer_size"`
+	auditBatchSize        int      `config:"audit_batch_size"`
+	auditFlushIntervalMs  int      `config:"audit_flush_interval_ms"`
+	auditFallbackFile     string   `config:"audit_fallback_file"`
+	paginationSecret      string   `config:"pagination_secret" secret:"true"`
+	idempotencyEnabled    bool     `config:"idempotency_enabled"`
+	idempotencyTTL        int      `config:"idempotency_ttl"`
+	idempotencyLock       int      `config:"idempotency_lock_timeout"`
+	idempotencyMaxBytes   int      `config:"idempotency_max_body_bytes"`
+	redactHeaders         []string `config:"redact_headers"`
+	redactQueryParams     []string `config:"redact_query_params"`
+	redactFields          []string `config:"redact_fields"`
+	pgHost                string   `config:"postgres_host"`
+	pgPort                int      `config:"postgres_port"`
+	pgUser                string   `config:"postgres_user"`
+	pgDBName              string   `config:"postgres_dbname"`
+	pgPassword            string   `config:"postgres_password" secret:"true"`
+	runtimeMaxProcs       int      `config:"runtime_max_procs"`
+	runtimeMemLimit       int64    `config:"runtime_mem_limit"`
+	runtimeMemLimitRatio  float64  `config:"runtime_mem_limit_ratio"`
 }
 
 func loadConfig(l *zap.Logger) (*config, error) {
 	viper.SetEnvPrefix("APP") // Set the environment prefix to APP_*
 	viper.AutomaticEnv()      // Automatically search for environment variables
 
+	viper.SetDefault("http_admin_host", "127.0.0.1")
+	viper.SetDefault("http_drain_quiet_period", 1)
+	viper.SetDefault("http_drain_timeout", 15)
+	viper.SetDefault("http_upgrade_timeout", 30)
+	viper.SetDefault("http_read_timeout", 5)
+	viper.SetDefault("http_read_header_timeout", 2)
+	viper.SetDefault("http_write_timeout", 60)
+	viper.SetDefault("http_idle_timeout", 15)
+	viper.SetDefault("http_max_header_bytes", 1<<20)
+	viper.SetDefault("ratelimit_window", 60)
+	viper.SetDefault("ratelimit_key", "ip")
+	viper.SetDefault("ratelimit_store", "memory")
+	viper.SetDefault("concurrency_min_limit", 1)
+	viper.SetDefault("concurrency_max_limit", 1000)
+	viper.SetDefault("concurrency_target_latency_ms", 500)
+	viper.SetDefault("concurrency_backoff", 0.9)
+	viper.SetDefault("postgres_breaker_failure_threshold", 5)
+	viper.SetDefault("postgres_breaker_open_timeout", 10)
+	viper.SetDefault("postgres_breaker_half_open_calls", 1)
+	viper.SetDefault("postgres_breaker_success_threshold", 1)
+	viper.SetDefault("jwt_jwks_refresh", 300)
+	viper.SetDefault("jwt_clock_skew", 30)
+	viper.SetDefault("apikey_cache_ttl", 30)
+	viper.SetDefault("authz_store", "config")
+	viper.SetDefault("authz_refresh", 60)
+	viper.SetDefault("cors_allowed_methods", "GET,POST,PUT,PATCH,DELETE")
+	viper.SetDefault("cors_allowed_headers", "Content-Type,Authorization,X-API-Key,X-Request-Id,X-Request-Priority")
+	viper.SetDefault("cors_exposed_headers", "X-Request-Id,X-API-Version,RateLimit-Limit,RateLimit-Remaining,RateLimit-Reset,Retry-After")
+	viper.SetDefault("cors_max_age", 600)
+	viper.SetDefault("security_hsts_max_age", 63072000)
+	viper.SetDefault("security_hsts_include_subdomains", true)
+	viper.SetDefault("security_frame_options", "DENY")
+	viper.SetDefault("security_referrer_policy", "no-referrer")
+	viper.SetDefault("security_permissions_policy", "camera=(), microphone=(), geolocation=(), payment=()")
+	viper.SetDefault("security_csp", "default-src 'none'; frame-ancestors 'none'")
+	viper.SetDefault("security_swagger_csp", "default-src 'self'; script-src 'self' 'nonce-{nonce}'; "+
+		"style-src 'self' 'nonce-{nonce}' https://fonts.googleapis.com; style-src-attr 'unsafe-inline'; "+
+		"font-src 'self' https://fonts.gstatic.com; img-src 'self' data:; frame-ancestors 'none'")
+	viper.SetDefault("access_log_format", "json")
+	viper.SetDefault("access_log_sample_rate", 1.0)
+	viper.SetDefault("access_log_slow_ms", 1000)
+	viper.SetDefault("capture_max_bytes", 4096)
+	viper.SetDefault("capture_buffer_size", 50)
+	viper.SetDefault("audit_buffer_size", 1000)
+	viper.SetDefault("audit_batch_size", 100)
+	viper.SetDefault("audit_flush_interval_ms", 1000)
+	viper.SetDefault("audit_fallback_file", "/tmp/audit-fallback.jsonl")
+	viper.SetDefault("idempotency_ttl", 86400)
+	viper.SetDefault("idempotency_lock_timeout", 60)
+	viper.SetDefault("idempotency_max_body_bytes", api.DefaultMaxBodyBytes)
+	viper.SetDefault("runtime_mem_limit_ratio", 0.9)
+
 	config := &config{
-		httpPort:            viper.GetInt("http_port"),
-		httpPprofPort:       viper.GetInt("http_pprof_port"),
-		httpGracefulTimeout: viper.GetInt("http_graceful_timeout"),
-		httpGracefulSleep:   viper.GetInt("http_graceful_sleep"),
-		pgHost:              viper.GetString("postgres_host"),
-		pgPort:              viper.GetInt("postgres_port"),
-		pgUser:              viper.GetString("postgres_user"),
-		pgDBName:            viper.GetString("postgres_dbname"),
-		pgPassword:          viper.GetString("postgres_password"),
+		httpPort:              viper.GetInt("http_port"),
+		httpAdminPort:         viper.GetInt("http_admin_port"),
+		httpAdminHost:         viper.GetString("http_admin_host"),
+		httpAdminToken:        viper.GetString("http_admin_token"),
+		httpGracefulTimeout:   viper.GetInt("http_graceful_timeout"),
+		httpDrainQuietPeriod:  viper.GetInt("http_drain_quiet_period"),
+		httpDrainTimeout:      viper.GetInt("http_drain_timeout"),
+		httpUpgradeTimeout:    viper.GetInt("http_upgrade_timeout"),
+		httpReadTimeout:       viper.GetInt("http_read_timeout"),
+		httpReadHeaderTimeout: viper.GetInt("http_read_header_timeout"),
+		httpWriteTimeout:      viper.GetInt("http_write_timeout"),
+		httpIdleTimeout:       viper.GetInt("http_idle_timeout"),
+		httpMaxHeaderBytes:    viper.GetInt("http_max_header_bytes"),
+		httpMaxConns:          viper.GetInt("http_max_conns"),
+		httpMaxConnsPerIP:     viper.GetInt("http_max_conns_per_ip"),
+		httpAdminMaxConns:     viper.GetInt("http_admin_max_conns"),
+		httpTrustedProxies:    api.SplitList(viper.GetString("http_trusted_proxies")),
+		httpProxyProtocol:     viper.GetBool("http_proxy_protocol"),
+		rateLimitRequests:     viper.GetInt("ratelimit_requests"),
+		rateLimitWindow:       viper.GetInt("ratelimit_window"),
+		rateLimitKey:          viper.GetString("ratelimit_key"),
+		rateLimitStore:        viper.GetString("ratelimit_store"),
+		concurrencyInitial:    viper.GetInt("concurrency_initial_limit"),
+		concurrencyMin:        viper.GetInt("concurrency_min_limit"),
+		concurrencyMax:        viper.GetInt("concurrency_max_limit"),
+		concurrencyLatency:    viper.GetInt("concurrency_target_latency_ms"),
+		concurrencyBackoff:    viper.GetFloat64("concurrency_backoff"),
+		breakerFailures:       viper.GetInt("postgres_breaker_failure_threshold"),
+		breakerOpenTimeout:    viper.GetInt("postgres_breaker_open_timeout"),
+		breakerHalfOpenCalls:  viper.GetInt("postgres_breaker_half_open_calls"),
+		breakerSuccesses:      viper.GetInt("postgres_breaker_success_threshold"),
+		jwtJWKSURL:            viper.GetString("jwt_jwks_url"),
+		jwtJWKSFile:           viper.GetString("jwt_jwks_file"),
+		jwtJWKSRefresh:        viper.GetInt("jwt_jwks_refresh"),
+		jwtIssuer:             viper.GetString("jwt_issuer"),
+		jwtAudience:           viper.GetString("jwt_audience"),
+		jwtClockSkew:          viper.GetInt("jwt_clock_skew"),
+		apiKeyEnabled:         viper.GetBool("apikey_enabled"),
+		apiKeyCacheTTL:        viper.GetInt("apikey_cache_ttl"),
+		authzRoles:            viper.GetString("authz_roles"),
+		authzStore:            viper.GetString("authz_store"),
+		authzRefresh:          viper.GetInt("authz_refresh"),
+		corsAllowedOrigins:    api.SplitList(viper.GetString("cors_allowed_origins")),
+		corsAllowedMethods:    api.SplitList(viper.GetString("cors_allowed_methods")),
+		corsAllowedHeaders:    api.SplitList(viper.GetString("cors_allowed_headers")),
+		corsExposedHeaders:    api.SplitList(viper.GetString("cors_exposed_headers")),
+		corsAllowCredentials:  viper.GetBool("cors_allow_credentials"),
+		corsMaxAge:            viper.GetInt("cors_max_age"),
+		hstsMaxAge:            viper.GetInt("security_hsts_max_age"),
+		hstsIncludeSubdomains: viper.GetBool("security_hsts_include_subdomains"),
+		hstsPreload:           viper.GetBool("security_hsts_preload"),
+		frameOptions:          viper.GetString("security_frame_options"),
+		referrerPolicy:        viper.GetString("security_referrer_policy"),
+		permissionsPolicy:     viper.GetString("security_permissions_policy"),
+		csp:                   viper.GetString("security_csp"),
+		swaggerCSP:            viper.GetString("security_swagger_csp"),
+		accessLogFormat:       viper.GetString("access_log_format"),
+		accessLogSampleRate:   viper.GetFloat64("access_log_sample_rate"),
+		accessLogSlowMs:       viper.GetInt("access_log_slow_ms"),
+		accessLogOutput:       viper.GetString("access_log_output"),
+		captureMaxBytes:       viper.GetInt("capture_max_bytes"),
+		captureBufferSize:     viper.GetInt("capture_buffer_size"),
+		captureSecret:         viper.GetString("capture_secret"),
+		captureRoutes:         api.SplitList(viper.GetString("capture_routes")),
+		captureAccessLog:      viper.GetBool("capture_access_log"),
+		auditEnabled:          viper.GetBool("audit_enabled"),
+		auditBufferSize:       viper.GetInt("audit_buffer_size"),
+		auditBatchSize:        viper.GetInt("audit_batch_size"),
+		auditFlushIntervalMs:  viper.GetInt("audit_flush_interval_ms"),
+		auditFallbackFile:     viper.GetString("audit_fallback_file"),
+		paginationSecret:      viper.GetString("pagination_secret"),
+		idempotencyEnabled:    viper.GetBool("idempotency_enabled"),
+		idempotencyTTL:        viper.GetInt("idempotency_ttl"),
+		idempotencyLock:       viper.GetInt("idempotency_lock_timeout"),
+		idempotencyMaxBytes:   viper.GetInt("idempotency_max_body_bytes"),
+		redactHeaders:         api.SplitList(viper.GetString("redact_headers")),
+		redactQueryParams:     api.SplitList(viper.GetString("redact_query_params")),
+		redactFields:          api.SplitList(viper.GetString("redact_fields")),
+		pgHost:                viper.GetString("postgres_host"),
+		pgPort:                viper.GetInt("postgres_port"),
+		pgUser:                viper.GetString("postgres_user"),
+		pgDBName:              viper.GetString("postgres_dbname"),
+		pgPassword:            viper.GetString("postgres_password"),
+		runtimeMaxProcs:       viper.GetInt("runtime_max_procs"),
+		runtimeMemLimit:       viper.GetInt64("runtime_mem_limit"),
+		runtimeMemLimitRatio:  viper.GetFloat64("runtime_mem_limit_ratio"),
+	}
+
+	// admin port used to serve only pprof endpoints - keep the old name working
+	if config.httpAdminPort == 0 {
+		config.httpAdminPort = viper.GetInt("http_pprof_port")
 	}
 
 	config.print(l.Sugar())
 
+	if config.runtimeMemLimitRatio <= 0 || config.runtimeMemLimitRatio > 1 {
+		return nil, errors.Errorf("runtime_mem_limit_ratio must be in (0, 1] range, got: %v", config.runtimeMemLimitRatio)
+	}
+
+	if config.concurrencyInitial > 0 {
+		if config.concurrencyMin < 1 || config.concurrencyMin > config.concurrencyInitial || config.concurrencyInitial > config.concurrencyMax {
+			return nil, errors.Errorf("concurrency limits must satisfy 1 <= min <= initial <= max, got: %d, %d, %d",
+				config.concurrencyMin, config.concurrencyInitial, config.concurrencyMax)
+		}
+		if config.concurrencyBackoff <= 0 || config.concurrencyBackoff >= 1 {
+			return nil, errors.Errorf("concurrency_backoff must be in (0, 1) range, got: %v", config.concurrencyBackoff)
+		}
+	}
+
+	if config.breakerFailures > 0 && (config.breakerHalfOpenCalls < 1 || config.breakerSuccesses < 1) {
+		return nil, errors.New("postgres_breaker_half_open_calls and postgres_breaker_success_threshold must be positive")
+	}
+
+	if config.jwtJWKSURL != "" && config.jwtJWKSFile != "" {
+		return nil, errors.New("only one of jwt_jwks_url and jwt_jwks_file can be set")
+	}
+
+	if config.jwtKeySource() != "" && config.jwtJWKSRefresh <= 0 {
+		return nil, errors.Errorf("jwt_jwks_refresh must be positive, got: %d", config.jwtJWKSRefresh)
+	}
+
+	if config.apiKeyEnabled && config.apiKeyCacheTTL < 0 {
+		return nil, errors.Errorf("apikey_cache_ttl can't be negative, got: %d", config.apiKeyCacheTTL)
+	}
+
+	if config.httpProxyProtocol && len(config.httpTrustedProxies) == 0 {
+		return nil, errors.New("http_proxy_protocol requires http_trusted_proxies")
+	}
+
+	for _, o := range config.corsAllowedOrigins {
+		if o == "*" && config.corsAllowCredentials {
+			return nil, errors.New("cors_allowed_origins can't allow any origin (*) with cors_allow_credentials")
+		}
+		if strings.Count(o, "*") > 1 {
+			return nil, errors.Errorf("cors_allowed_origins pattern can contain a single wildcard, got: %q", o)
+		}
+	}
+
+	switch config.accessLogFormat {
+	case api.AccessLogJSON, api.AccessLogCombined, api.AccessLogLogfmt:
+	default:
+		return nil, errors.Errorf("access_log_format must be one of json, combined, logfmt, got: %q", config.accessLogFormat)
+	}
+
+	if config.accessLogSampleRate < 0 || config.accessLogSampleRate > 1 {
+		return nil, errors.Errorf("access_log_sample_rate must be in [0, 1] range, got: %v", config.accessLogSampleRate)
+	}
+
+	if config.captureEnabled() && (config.captureMaxBytes <= 0 || config.captureBufferSize < 0) {
+		return nil, errors.Errorf("capture_max_bytes must be positive and capture_buffer_size can't be negative, got: %d, %d",
+			config.captureMaxBytes, config.captureBufferSize)
+	}
+
+	if config.auditEnabled {
+		if config.auditBufferSize <= 0 || config.auditBatchSize <= 0 || config.auditFlushIntervalMs <= 0 {
+			return nil, errors.Errorf("audit_buffer_size, audit_batch_size and audit_flush_interval_ms must be positive, got: %d, %d, %d",
+				config.auditBufferSize, config.auditBatchSize, config.auditFlushIntervalMs)
+		}
+		if config.auditBatchSize > postgres.MaxAuditBatch {
+			return nil, errors.Errorf("audit_batch_size can't be greater than %d, got: %d", postgres.MaxAuditBatch, config.auditBatchSize)
+		}
+		if config.auditFallbackFile == "" {
+			return nil, errors.New("audit_fallback_file is required when audit_enabled is set")
+		}
+	}
+
+	if config.idempotencyEnabled && (config.idempotencyTTL <= 0 || config.idempotencyLock <= 0 || config.idempotencyMaxBytes <= 0) {
+		return nil, errors.Errorf("idempotency_ttl, idempotency_lock_timeout and idempotency_max_body_bytes must be positive, got: %d, %d, %d",
+			config.idempotencyTTL, config.idempotencyLock, config.idempotencyMaxBytes)
+	}
+
+	if config.rateLimitRequests > 0 && config.rateLimitWindow <= 0 {
+		return nil, errors.Errorf("ratelimit_window must be positive, got: %d", config.rateLimitWindow)
+	}
+
 	return config, nil
 }
 
+// print logs values of the fields tagged with config key, secrets are masked.
 func (c *config) print(l *zap.SugaredLogger) {
-	l.Infow("config value", "http_port", c.httpPort)
-	l.Infow("config value", "http_pprof_port", c.httpPprofPort)
-	l.Infow("config value", "http_graceful_timeout", c.httpGracefulTimeout)
-	l.Infow("config value", "http_graceful_sleep", c.httpGracefulSleep)
-	l.Infow("config value", "postgres_host", c.pgHost)
-	l.Infow("config value", "postgres_port", c.pgPort)
-	l.Infow("config value", "postgres_user", c.pgUser)
-	l.Infow("config value", "postgres_dbname", c.pgDBName)
-	l.Infow("config value", "postgres_password", maskLeft(c.pgPassword, 4))
+	for _, f := range redact.StructFields(c, "config") {
+		l.Infow("config value", f)
+	}
+}
+
+// captureEnabled returns true when bodies of some requests are captured.
+func (c *config) captureEnabled() bool {
+	return c.captureSecret != "" || len(c.captureRoutes) > 0
+}
+
+// redactor returns redactor of the sensitive names configured in addition to the defaults.
+func (c *config) redactor() *redact.Redactor {
+	return redact.New(redact.Config{
+		Headers:     c.redactHeaders,
+		QueryParams: c.redactQueryParams,
+		Fields:      c.redactFields,
+	})
 }
 
-func maskLeft(s string, l int) string {
-	rs := []rune(s)
-	for i := 0; i < len(rs)-l; i++ {
-		rs[i] = 'X'
+// jwtKeySource returns JWKS URL or file, empty when JWT authentication is disabled.
+func (c *config) jwtKeySource() string {
+	if c.jwtJWKSURL != "" {
+		return c.jwtJWKSURL
 	}
-	return string(rs)
+	return c.jwtJWKSFile
 }
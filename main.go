@@ -4,14 +4,20 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
+	"github.com/mateuszdyminski/go-template/api"
+	"github.com/mateuszdyminski/go-template/redact"
 	"github.com/mateuszdyminski/go-template/repository/postgres"
+	"github.com/mateuszdyminski/go-template/upgrade"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
@@ -22,8 +28,16 @@ import (
 // @contact.email dyminski@gmail.com
 
 // @BasePath /
+
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+
+// @securityDefinitions.apikey APIKeyAuth
+// @in header
+// @name X-API-Key
 func main() {
-	logger := initLogger()
+	logger := initLogger(redact.New(redact.Config{}))
 	ls := logger.Sugar()
 
 	cfg, err := loadConfig(logger)
@@ -31,55 +45,129 @@ func main() {
 		ls.Fatalw("can't load configuration", "err", err)
 	}
 
-	repo, err := postgres.NewPostgresRepository(cfg.pgHost, cfg.pgPort, cfg.pgUser, cfg.pgPassword, cfg.pgDBName)
+	// mask also the sensitive names from config from now on
+	logger = initLogger(cfg.redactor())
+	ls = logger.Sugar()
+	// used by app.LoggerFrom outside of requests
+	zap.ReplaceGlobals(logger)
+
+	// adapt GOMAXPROCS and GOMEMLIMIT to the container limits
+	tuneRuntime(ls, cfg, "/", newRuntimeMetrics(prometheus.DefaultRegisterer))
+
+	db, err := postgres.NewDB(cfg.pgHost, cfg.pgPort, cfg.pgUser, cfg.pgPassword, cfg.pgDBName)
 	if err != nil {
 		ls.Fatalw("can't create repository", "err", err)
 	}
 
 	// wait for SIGTERM or SIGINT
-	cancelCtx := initContext()
+	cancelCtx, cancel := initContext()
+
+	go migrate(cancelCtx, ls, db)
+
+	svc, err := newServices(logger, prometheus.DefaultRegisterer, cfg, db)
+	if err != nil {
+		ls.Fatalw("can't create services", "err", err)
+	}
+	drainer := svc.drainer
+
+	// delete expired idempotency keys and rate limits in the background
+	svc.purge(cancelCtx)
+
+	// listeners are inherited from the previous process during binary upgrade
+	upg, err := upgrade.New(logger, time.Duration(cfg.httpUpgradeTimeout)*time.Second)
+	if err != nil {
+		ls.Fatalw("can't inherit listeners", "err", err)
+	}
 
-	router := newRouter(cancelCtx, logger, repo)
+	router := newRouter(cancelCtx, logger, prometheus.DefaultRegisterer, cfg, svc)
 
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.httpPort),
-		Handler:      router,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 1 * time.Minute,
-		IdleTimeout:  15 * time.Second,
+		Addr:              fmt.Sprintf(":%d", cfg.httpPort),
+		Handler:           drainer.Handler(svc.versioning.Negotiate(router)),
+		ReadTimeout:       time.Duration(cfg.httpReadTimeout) * time.Second,
+		ReadHeaderTimeout: time.Duration(cfg.httpReadHeaderTimeout) * time.Second,
+		WriteTimeout:      time.Duration(cfg.httpWriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(cfg.httpIdleTimeout) * time.Second,
+		MaxHeaderBytes:    cfg.httpMaxHeaderBytes,
+	}
+
+	ln, err := upg.Listen("http", srv.Addr)
+	if err != nil {
+		ls.Fatalw("can't start HTTP server", "err", err)
+	}
+
+	listenerMetrics := api.NewListenerMetrics(prometheus.DefaultRegisterer)
+	ln = api.NewLimitListener(ln, "http", api.ListenerLimits{
+		MaxConns:      cfg.httpMaxConns,
+		MaxConnsPerIP: cfg.httpMaxConnsPerIP,
+	}, listenerMetrics)
+
+	// client address comes from PROXY protocol header of the load balancer,
+	// per-IP connection caps still apply to the load balancer address
+	if cfg.httpProxyProtocol {
+		ln = api.NewProxyProtoListener(logger, ln, svc.proxies, time.Duration(cfg.httpReadHeaderTimeout)*time.Second)
 	}
 
 	// run server in background
 	go func() {
 		ls.Infow("HTTP Server started", "port", cfg.httpPort)
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		if err := srv.Serve(ln); err != http.ErrServerClosed {
 			ls.Fatalw("can't start HTTP server", "err", err)
 		}
 	}()
 
-	// run pprof server in background on different port
-	if cfg.httpPprofPort != 0 {
+	// run admin server (pprof, drain) in background on different port
+	if cfg.httpAdminPort != 0 {
+		// loopback by default - pprof endpoints aren't authenticated
+		adminLn, err := upg.Listen("admin", net.JoinHostPort(cfg.httpAdminHost, strconv.Itoa(cfg.httpAdminPort)))
+		if err != nil {
+			ls.Fatalw("can't start admin HTTP server", "err", err)
+		}
+
+		adminLn = api.NewLimitListener(adminLn, "admin", api.ListenerLimits{MaxConns: cfg.httpAdminMaxConns}, listenerMetrics)
+
+		// no write timeout - profiling and draining take longer than regular requests
+		adminSrv := &http.Server{
+			Handler:           newAdminRouter(logger, svc, router),
+			ReadHeaderTimeout: time.Duration(cfg.httpReadHeaderTimeout) * time.Second,
+			IdleTimeout:       time.Duration(cfg.httpIdleTimeout) * time.Second,
+			MaxHeaderBytes:    cfg.httpMaxHeaderBytes,
+		}
+
 		go func() {
-			ls.Infow("HTTP Server for pprof purpose started", "port", cfg.httpPprofPort)
-			if err := http.ListenAndServe(fmt.Sprintf(":%d", cfg.httpPprofPort), newPprofRouter()); err != nil {
-				ls.Fatalw("can't start pprof HTTP server", "err", err)
+			ls.Infow("HTTP admin server started", "host", cfg.httpAdminHost, "port", cfg.httpAdminPort)
+			if err := adminSrv.Serve(adminLn); err != nil {
+				ls.Fatalw("can't start admin HTTP server", "err", err)
 			}
 		}()
 	}
 
-	<-cancelCtx.Done()
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.httpGracefulTimeout)*time.Second)
-	defer cancel()
+	// let the previous process know it can shut down
+	if err := upg.Ready(); err != nil {
+		ls.Errorw("can't notify previous process about readiness", "err", err)
+	}
 
-	// wait for Kubernetes readiness probe to remove this instance from service
-	// the readiness check interval must be lower than the timeout
+	// upgrade binary on SIGUSR2
+	go watchUpgrades(cancelCtx, cancel, ls, upg)
 
-	// sleep some additional time to drain all ongoing requests - use it only on prod
-	// as long as it's annoing on development
-	if !debug() {
-		time.Sleep(time.Duration(int64(cfg.httpGracefulSleep) * int64(time.Second)))
+	<-cancelCtx.Done()
+
+	if upg.Upgraded() {
+		// the new process accepts connections on the same sockets - stop accepting right away
+		// and let Shutdown wait for ongoing requests
+		ls.Infow("binary upgraded, handing over traffic to the new process")
+	} else {
+		// readiness probe starts failing, so Kubernetes removes this instance from service;
+		// wait until load balancers stop sending traffic and all ongoing requests are finished
+		// (returns immediately when the preStop hook already drained the instance)
+		if err := drainer.Wait(context.Background()); err != nil {
+			ls.Warnw("HTTP server not fully drained", "err", err)
+		}
 	}
 
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), time.Duration(cfg.httpGracefulTimeout)*time.Second)
+	defer cancelShutdown()
+
 	ls.Infow("shutting down HTTP server", "timeout", time.Duration(cfg.httpGracefulTimeout)*time.Second)
 	srv.SetKeepAlivesEnabled(false)
 
@@ -88,9 +176,21 @@ func main() {
 	} else {
 		ls.Infow("HTTP server gracefully stopped")
 	}
+
+	// store audit events of the finished requests
+	if svc.audit != nil {
+		if err := svc.audit.Close(ctx); err != nil {
+			ls.Warnw("audit events written to fallback file", "err", err)
+		}
+	}
+
+	// Shutdown doesn't wait for hijacked connections - give them the rest of the timeout
+	if err := drainer.WaitHijacked(ctx); err != nil {
+		ls.Warnw("closing hijacked connections", "count", drainer.CloseHijacked())
+	}
 }
 
-func initContext() context.Context {
+func initContext() (context.Context, context.CancelFunc) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	c := make(chan os.Signal, 2)
@@ -102,16 +202,40 @@ func initContext() context.Context {
 		os.Exit(1) // second signal. Exit directly.
 	}()
 
-	return ctx
+	return ctx, cancel
+}
+
+// watchUpgrades starts new binary on SIGUSR2 and cancels the context once it's ready,
+// so this process goes through the regular graceful shutdown.
+func watchUpgrades(ctx context.Context, cancel context.CancelFunc, l *zap.SugaredLogger, upg *upgrade.Upgrader) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR2)
+	defer signal.Stop(c)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c:
+			l.Infow("binary upgrade requested")
+			if err := upg.Upgrade(); err != nil {
+				l.Errorw("binary upgrade failed", "err", err)
+				continue
+			}
+			cancel()
+			return
+		}
+	}
 }
 
-func initLogger() *zap.Logger {
+// initLogger returns logger masking secrets found by r in the log entries.
+func initLogger(r *redact.Redactor) *zap.Logger {
 	cfg := zap.NewProductionConfig()
 	if debug() {
 		cfg.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
 	}
 
-	logger, err := cfg.Build()
+	logger, err := cfg.Build(redact.WrapCore(r))
 	if err != nil {
 		log.Fatalf("can't init logger: %s", err)
 	}
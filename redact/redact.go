@@ -0,0 +1,170 @@
+// Package redact masks secrets - credentials in headers, query strings, log fields and error messages -
+// before they reach logs or responses.
+package redact
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Mask replaces redacted values.
+const Mask = "[REDACTED]"
+
+var (
+	// DefaultHeaders - headers always redacted.
+	DefaultHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-API-Key", "X-Auth-Token"}
+	// DefaultQueryParams - query parameters always redacted.
+	DefaultQueryParams = []string{"token", "access_token", "id_token", "refresh_token", "api_key", "apikey", "key",
+		"password", "secret", "client_secret", "signature", "sig", "code"}
+	// DefaultFields - names of log fields and key=value pairs always redacted. Names ending with
+	// one of them are redacted too, e.g. postgres_password.
+	DefaultFields = []string{"password", "passwd", "secret", "token", "authorization", "apikey", "api_key", "dsn", "cookie"}
+)
+
+// Config - sensitive names added to the defaults.
+type Config struct {
+	Headers     []string
+	QueryParams []string
+	Fields      []string
+}
+
+// Redactor masks values of the sensitive headers, query parameters and fields. Names are case insensitive.
+type Redactor struct {
+	headers map[string]bool
+	query   map[string]bool
+	fields  []string // normalized
+
+	userinfo    *regexp.Regexp
+	pairs       *regexp.Regexp
+	params      *regexp.Regexp
+	headerLines *regexp.Regexp
+}
+
+var std = New(Config{})
+
+func New(cfg Config) *Redactor {
+	r := &Redactor{
+		headers:  make(map[string]bool),
+		query:    make(map[string]bool),
+		userinfo: regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^:/@\s]*:)[^@\s/]*@`),
+	}
+	var headers []string
+	for _, h := range append(append([]string{}, DefaultHeaders...), cfg.Headers...) {
+		r.headers[http.CanonicalHeaderKey(h)] = true
+		headers = append(headers, regexp.QuoteMeta(h))
+	}
+
+	var params []string
+	for _, q := range append(append([]string{}, DefaultQueryParams...), cfg.QueryParams...) {
+		r.query[strings.ToLower(q)] = true
+		params = append(params, regexp.QuoteMeta(q))
+	}
+
+	var fields []string
+	for _, f := range append(append([]string{}, DefaultFields...), cfg.Fields...) {
+		r.fields = append(r.fields, normalize(f))
+		fields = append(fields, regexp.QuoteMeta(f))
+	}
+
+	// key=value and "key":"value" pairs with keys ending with a sensitive name, e.g. password=secret in a DSN,
+	// quoted values may be cut off by truncation
+	r.pairs = regexp.MustCompile(`(?i)([\w.-]*(?:` + strings.Join(fields, "|") + `)(?:\s*=\s*|"\s*:\s*))` +
+		`(` + regexp.QuoteMeta(Mask) + `|"[^"]*"?|'[^']*'?|[^\s"',;&}\]]+)`)
+	// sensitive parameters of query strings embedded in the text
+	r.params = regexp.MustCompile(`(?i)([?&](?:` + strings.Join(params, "|") + `)=)[^&#\s"']*`)
+	// sensitive headers of dumped requests, e.g. Authorization: Bearer xyz
+	r.headerLines = regexp.MustCompile(`(?im)^((?:` + strings.Join(headers, "|") + `)[ \t]*:[ \t]*)[^\r\n]*`)
+
+	return r
+}
+
+// Header returns true when header name is sensitive.
+func (r *Redactor) Header(name string) bool {
+	return r.headers[http.CanonicalHeaderKey(name)]
+}
+
+// Headers returns copy of h with values of the sensitive headers masked.
+func (r *Redactor) Headers(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for k, vv := range h {
+		if r.Header(k) {
+			out[k] = []string{Mask}
+			continue
+		}
+		out[k] = append([]string(nil), vv...)
+	}
+	return out
+}
+
+// QueryParam returns true when query parameter name is sensitive.
+func (r *Redactor) QueryParam(name string) bool {
+	return r.query[strings.ToLower(name)] || r.Field(name)
+}
+
+// URI masks values of the sensitive query parameters and userinfo password of the request URI or URL.
+func (r *Redactor) URI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return r.String(uri)
+	}
+
+	changed := false
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), Mask)
+		changed = true
+	}
+	parts := strings.Split(u.RawQuery, "&")
+	for i, p := range parts {
+		name := p
+		if j := strings.IndexByte(p, '='); j != -1 {
+			name = p[:j]
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil && name != "" && r.QueryParam(unescaped) {
+			parts[i] = name + "=" + Mask
+			changed = true
+		}
+	}
+	if !changed {
+		return uri
+	}
+	u.RawQuery = strings.Join(parts, "&")
+
+	return u.String()
+}
+
+// Field returns true when field name is sensitive - it ends with one of the sensitive names, ignoring
+// case and separators, e.g. api-key, apiKey and db_password.
+func (r *Redactor) Field(name string) bool {
+	n := normalize(name)
+	for _, f := range r.fields {
+		if strings.HasSuffix(n, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// String masks secrets found in free text, e.g. error messages: passwords of URLs, values of
+// sensitive key=value pairs, query parameters and header lines.
+func (r *Redactor) String(s string) string {
+	s = r.userinfo.ReplaceAllString(s, "${1}"+Mask+"@")
+	s = r.params.ReplaceAllString(s, "${1}"+Mask)
+	s = r.headerLines.ReplaceAllString(s, "${1}"+Mask)
+	return r.pairs.ReplaceAllString(s, "${1}"+Mask)
+}
+
+// String masks secrets in s using the default names.
+func String(s string) string {
+	return std.String(s)
+}
+
+// URI masks secrets in uri using the default names.
+func URI(uri string) string {
+	return std.URI(uri)
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "", ".", "").Replace(name))
+}
@@ -0,0 +1,59 @@
+package redact
+
+import (
+	"reflect"
+
+	"go.uber.org/zap"
+)
+
+// StructFields returns log fields of v's struct fields tagged with key tag, named after the tag value,
+// e.g. `config:"postgres_password"`. Values of fields tagged `secret:"true"` are masked, unless empty,
+// so it's still visible whether they're set. Unexported fields are supported.
+func StructFields(v interface{}, tag string) []zap.Field {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	rt := rv.Type()
+
+	var fields []zap.Field
+	for i := 0; i < rt.NumField(); i++ {
+		key := rt.Field(i).Tag.Get(tag)
+		if key == "" || key == "-" {
+			continue
+		}
+		fv := rv.Field(i)
+		if rt.Field(i).Tag.Get("secret") == "true" {
+			if fv.IsZero() {
+				fields = append(fields, zap.String(key, ""))
+			} else {
+				fields = append(fields, zap.String(key, Mask))
+			}
+			continue
+		}
+		fields = append(fields, valueField(key, fv))
+	}
+	return fields
+}
+
+// valueField reads the value without Interface, which isn't allowed for unexported fields.
+func valueField(key string, v reflect.Value) zap.Field {
+	switch v.Kind() {
+	case reflect.String:
+		return zap.String(key, v.String())
+	case reflect.Bool:
+		return zap.Bool(key, v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return zap.Int64(key, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return zap.Uint64(key, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return zap.Float64(key, v.Float())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String {
+			list := make([]string, v.Len())
+			for i := range list {
+				list[i] = v.Index(i).String()
+			}
+			return zap.Strings(key, list)
+		}
+	}
+	return zap.String(key, v.Type().String())
+}
@@ -0,0 +1,99 @@
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewCore wraps core, so fields with sensitive names are masked and secrets are removed from
+// messages, string, error and stringer fields before they're encoded. Reflected, object and array
+// fields, e.g. zap.Any with a map, are checked in their JSON form and logged as redacted JSON string
+// when they contain secrets.
+func NewCore(core zapcore.Core, r *Redactor) zapcore.Core {
+	return &redactingCore{Core: core, r: r}
+}
+
+// WrapCore returns logger option redacting output of the logger, see NewCore.
+func WrapCore(r *Redactor) zap.Option {
+	return zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return NewCore(c, r)
+	})
+}
+
+type redactingCore struct {
+	zapcore.Core
+	r *Redactor
+}
+
+func (c *redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactingCore{Core: c.Core.With(c.fields(fields)), r: c.r}
+}
+
+func (c *redactingCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c *redactingCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	e.Message = c.r.String(e.Message)
+	return c.Core.Write(e, c.fields(fields))
+}
+
+func (c *redactingCore) fields(fields []zapcore.Field) []zapcore.Field {
+	out := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		out[i] = c.field(f)
+	}
+	return out
+}
+
+func (c *redactingCore) field(f zapcore.Field) zapcore.Field {
+	if c.r.Field(f.Key) {
+		// empty value shows the secret isn't set
+		if f.Type == zapcore.StringType && f.String == "" {
+			return f
+		}
+		return zap.String(f.Key, Mask)
+	}
+
+	switch f.Type {
+	case zapcore.StringType:
+		f.String = c.r.String(f.String)
+	case zapcore.ErrorType:
+		if err, ok := f.Interface.(error); ok && err != nil {
+			return zap.String(f.Key, c.r.String(err.Error()))
+		}
+	case zapcore.StringerType:
+		if s, ok := f.Interface.(fmt.Stringer); ok && s != nil {
+			return zap.String(f.Key, c.r.String(s.String()))
+		}
+	case zapcore.ByteStringType:
+		if b, ok := f.Interface.([]byte); ok {
+			return zap.ByteString(f.Key, []byte(c.r.String(string(b))))
+		}
+	case zapcore.ReflectType, zapcore.ObjectMarshalerType, zapcore.ArrayMarshalerType:
+		return c.complexField(f)
+	}
+	return f
+}
+
+// complexField redacts JSON form of the field, the field is kept as it is when there's nothing to redact.
+func (c *redactingCore) complexField(f zapcore.Field) zapcore.Field {
+	enc := zapcore.NewMapObjectEncoder()
+	f.AddTo(enc)
+	b, err := json.Marshal(enc.Fields[f.Key])
+	if err != nil {
+		// can't be checked for secrets
+		return zap.String(f.Key, Mask)
+	}
+
+	if s := c.r.String(string(b)); s != string(b) {
+		return zap.String(f.Key, s)
+	}
+	return f
+}
@@ -0,0 +1,115 @@
+package redact
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const secret = "s3cr3t-value"
+
+func newBufferLogger(r *Redactor) (*zap.Logger, *bytes.Buffer) {
+	buf := &bytes.Buffer{}
+	enc := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	return zap.New(NewCore(zapcore.NewCore(enc, zapcore.AddSync(buf), zap.DebugLevel), r)), buf
+}
+
+func Test_NewCore_ShouldKeepSecretsOutOfLogOutput(t *testing.T) {
+	// given
+	l, buf := newBufferLogger(New(Config{Fields: []string{"session"}}))
+	dsn := "postgres://app:" + secret + "@db:5432/app"
+
+	// when
+	l.Info("connecting to "+dsn, zap.String("uri", "/api/items?token="+secret))
+	l.With(zap.String("password", secret)).Warn("with field")
+	l.Error("query failed", zap.Error(errors.Wrap(errors.New("password="+secret), "can't connect")))
+	l.Sugar().Errorw("sugared", "userSession", secret, "apiKey", 42)
+	l.Sugar().Error(errors.Errorf("dial %s: refused", dsn))
+
+	// then
+	out := buf.String()
+	assert.NotContains(t, out, secret)
+	assert.Contains(t, out, `"msg":"connecting to postgres://app:[REDACTED]@db:5432/app"`)
+	assert.Contains(t, out, `"uri":"/api/items?token=[REDACTED]"`)
+	assert.Contains(t, out, `"password":"[REDACTED]"`)
+	assert.Contains(t, out, `"error":"can't connect: password=[REDACTED]"`)
+	assert.Contains(t, out, `"userSession":"[REDACTED]"`)
+	assert.Contains(t, out, `"apiKey":"[REDACTED]"`)
+}
+
+func Test_NewCore_ShouldRedactComplexFields(t *testing.T) {
+	// given
+	l, buf := newBufferLogger(New(Config{}))
+	login := zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		enc.AddString("user", "u1")
+		enc.AddString("password", secret)
+		return nil
+	})
+
+	// when
+	l.Info("complex",
+		zap.Any("requestBody", map[string]interface{}{"user": "u1", "password": secret}),
+		zap.Object("login", login),
+		zap.ByteString("raw", []byte("token="+secret)),
+		zap.Any("items", []int{1, 2}))
+
+	// then
+	out := buf.String()
+	assert.NotContains(t, out, secret)
+	assert.Contains(t, out, `"requestBody":"{\"password\":[REDACTED],\"user\":\"u1\"}"`)
+	assert.Contains(t, out, `"login":"{\"password\":[REDACTED],\"user\":\"u1\"}"`)
+	assert.Contains(t, out, `"raw":"token=[REDACTED]"`)
+	assert.Contains(t, out, `"items":[1,2]`, "fields without secrets should be kept as they are")
+}
+
+func Test_NewCore_ShouldKeepLevelAndOtherFields(t *testing.T) {
+	// given
+	buf := &bytes.Buffer{}
+	enc := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	l := zap.New(NewCore(zapcore.NewCore(enc, zapcore.AddSync(buf), zap.InfoLevel), New(Config{})))
+
+	// when
+	l.Debug("hidden")
+	l.Info("visible", zap.Int("status", 200), zap.String("requestId", "abc"))
+
+	// then
+	out := buf.String()
+	require.NotContains(t, out, "hidden")
+	assert.Contains(t, out, `"status":200`)
+	assert.Contains(t, out, `"requestId":"abc"`)
+}
+
+func Test_StructFields_ShouldMaskSecretFields(t *testing.T) {
+	// given
+	cfg := struct {
+		host     string   `config:"postgres_host"`
+		port     int      `config:"postgres_port"`
+		password string   `config:"postgres_password" secret:"true"`
+		token    string   `config:"api_token" secret:"true"`
+		origins  []string `config:"cors_allowed_origins"`
+		enabled  bool     `config:"apikey_enabled"`
+		ignored  string
+	}{host: "db", port: 5432, password: secret, origins: []string{"https://example.com"}, enabled: true, ignored: "x"}
+	l, buf := newBufferLogger(New(Config{}))
+
+	// when
+	for _, f := range StructFields(&cfg, "config") {
+		l.Info("config value", f)
+	}
+
+	// then
+	out := buf.String()
+	assert.NotContains(t, out, secret)
+	assert.Contains(t, out, `"postgres_host":"db"`)
+	assert.Contains(t, out, `"postgres_port":5432`)
+	assert.Contains(t, out, `"postgres_password":"[REDACTED]"`)
+	assert.Contains(t, out, `"api_token":""`)
+	assert.Contains(t, out, `"cors_allowed_origins":["https://example.com"]`)
+	assert.Contains(t, out, `"apikey_enabled":true`)
+	assert.NotContains(t, out, "ignored")
+}
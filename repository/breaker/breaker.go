@@ -0,0 +1,223 @@
+// Package breaker implements circuit breaker decorator of app.Repository, so handlers
+// fail fast while the database is failing instead of waiting for timeouts.
+package breaker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/mateuszdyminski/go-template/app"
+)
+
+// State - state of the circuit.
+type State int
+
+const (
+	// Closed - calls go through, failures are counted.
+	Closed State = iota
+	// Open - calls fail fast with *OpenError.
+	Open
+	// HalfOpen - limited number of trial calls go through to check whether the DB recovered.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// OpenError - returned without calling the repository while the circuit is open.
+type OpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("circuit breaker is open, retry after %s", e.RetryAfter)
+}
+
+// IsOpen returns true when err was returned because of the open circuit.
+func IsOpen(err error) bool {
+	_, ok := errors.Cause(err).(*OpenError)
+	return ok
+}
+
+// Config - circuit breaker thresholds.
+type Config struct {
+	FailureThreshold int           // consecutive failures opening the circuit
+	OpenTimeout      time.Duration // time the circuit stays open before trial calls
+	HalfOpenMaxCalls int           // concurrent trial calls allowed in half-open state
+	SuccessThreshold int           // successful trial calls closing the circuit
+}
+
+// Repository - app.Repository decorated with circuit breaker.
+type Repository struct {
+	repo app.Repository
+	name string
+	cfg  Config
+	l    *zap.SugaredLogger
+	now  func() time.Time
+
+	mu        sync.Mutex
+	state     State
+	failures  int
+	successes int
+	trials    int
+	openedAt  time.Time
+
+	stateGauge  *prometheus.GaugeVec
+	transitions *prometheus.CounterVec
+	rejected    *prometheus.CounterVec
+}
+
+// NewRepository returns repository which stops calling repo after cfg.FailureThreshold consecutive failures.
+// The name is used in metrics and logs, the metrics are registered with reg.
+func NewRepository(l *zap.Logger, reg prometheus.Registerer, name string, repo app.Repository, cfg Config) *Repository {
+	stateGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "repository",
+		Name:      "circuit_state",
+		Help:      "State of the circuit breaker: 0 - closed, 1 - open, 2 - half-open.",
+	}, []string{"name"})
+	transitions := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "repository",
+		Name:      "circuit_transitions_total",
+		Help:      "The total number of circuit breaker state transitions.",
+	}, []string{"name", "from", "to"})
+	rejected := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "repository",
+		Name:      "circuit_rejected_total",
+		Help:      "The total number of calls rejected by the open circuit breaker.",
+	}, []string{"name"})
+
+	reg.MustRegister(stateGauge, transitions, rejected)
+	stateGauge.WithLabelValues(name).Set(float64(Closed))
+
+	return &Repository{
+		repo:        repo,
+		name:        name,
+		cfg:         cfg,
+		l:           l.Sugar(),
+		now:         time.Now,
+		stateGauge:  stateGauge,
+		transitions: transitions,
+		rejected:    rejected,
+	}
+}
+
+// OK - returns information whether connection to DB is up and running.
+// It implements app.Repository interface.
+func (r *Repository) OK(ctx context.Context) (bool, error) {
+	if err := r.before(); err != nil {
+		return false, err
+	}
+
+	ok, err := r.repo.OK(ctx)
+	r.after(ctx, err)
+
+	return ok, err
+}
+
+// State returns current state of the circuit.
+func (r *Repository) State() State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.state == Open && r.now().Sub(r.openedAt) >= r.cfg.OpenTimeout {
+		return HalfOpen
+	}
+	return r.state
+}
+
+// CircuitState - returns state of the circuit as a string. It implements app.CircuitStater interface.
+func (r *Repository) CircuitState() string {
+	return r.State().String()
+}
+
+// before checks whether the call is allowed.
+func (r *Repository) before() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.state == Open {
+		elapsed := r.now().Sub(r.openedAt)
+		if elapsed < r.cfg.OpenTimeout {
+			r.rejected.WithLabelValues(r.name).Inc()
+			return &OpenError{RetryAfter: r.cfg.OpenTimeout - elapsed}
+		}
+		r.transition(HalfOpen)
+	}
+
+	if r.state == HalfOpen {
+		if r.trials >= r.cfg.HalfOpenMaxCalls {
+			r.rejected.WithLabelValues(r.name).Inc()
+			return &OpenError{}
+		}
+		r.trials++
+	}
+
+	return nil
+}
+
+// after records the call result.
+func (r *Repository) after(ctx context.Context, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// client gave up - says nothing about the DB
+	if err != nil && ctx.Err() == context.Canceled {
+		if r.state == HalfOpen {
+			r.trials--
+		}
+		return
+	}
+
+	// failures are logged with the logger of the request which made the call
+	switch r.state {
+	case Closed:
+		if err == nil {
+			r.failures = 0
+			return
+		}
+		r.failures++
+		if r.failures >= r.cfg.FailureThreshold {
+			app.LoggerFrom(ctx).Sugar().Warnw("circuit breaker opened", "name", r.name, "failures", r.failures, "err", err)
+			r.transition(Open)
+		}
+	case HalfOpen:
+		r.trials--
+		if err != nil {
+			app.LoggerFrom(ctx).Sugar().Warnw("circuit breaker trial call failed", "name", r.name, "err", err)
+			r.transition(Open)
+			return
+		}
+		r.successes++
+		if r.successes >= r.cfg.SuccessThreshold {
+			r.transition(Closed)
+		}
+	}
+}
+
+// transition changes the state, must be called with the lock held.
+func (r *Repository) transition(to State) {
+	from := r.state
+	r.state = to
+	r.failures, r.successes, r.trials = 0, 0, 0
+	if to == Open {
+		r.openedAt = r.now()
+	}
+
+	r.l.Infow("circuit breaker state changed", "name", r.name, "from", from.String(), "to", to.String())
+	r.stateGauge.WithLabelValues(r.name).Set(float64(to))
+	r.transitions.WithLabelValues(r.name, from.String(), to.String()).Inc()
+}
@@ -0,0 +1,109 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type fakeRepository struct {
+	err   error
+	calls int
+}
+
+func (f *fakeRepository) OK(context.Context) (bool, error) {
+	f.calls++
+	return f.err == nil, f.err
+}
+
+func newTestRepository(repo *fakeRepository, now *time.Time) *Repository {
+	r := NewRepository(zap.NewNop(), prometheus.NewRegistry(), "test", repo, Config{
+		FailureThreshold: 2,
+		OpenTimeout:      10 * time.Second,
+		HalfOpenMaxCalls: 1,
+		SuccessThreshold: 1,
+	})
+	r.now = func() time.Time { return *now }
+	return r
+}
+
+func Test_OK_ShouldOpenCircuitAfterConsecutiveFailures(t *testing.T) {
+	// given
+	now := time.Unix(1000, 0)
+	fake := &fakeRepository{err: errors.New("connection refused")}
+	repo := newTestRepository(fake, &now)
+
+	// when
+	repo.OK(context.Background())
+	repo.OK(context.Background())
+	ok, err := repo.OK(context.Background())
+
+	// then
+	assert.False(t, ok)
+	assert.True(t, IsOpen(err), "should fail fast with OpenError")
+	assert.Equal(t, 2, fake.calls, "repository shouldn't be called while the circuit is open")
+	assert.Equal(t, Open, repo.State())
+	assert.Equal(t, float64(Open), testutil.ToFloat64(repo.stateGauge.WithLabelValues("test")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(repo.rejected.WithLabelValues("test")))
+}
+
+func Test_OK_ShouldCloseCircuitAfterSuccessfulTrial(t *testing.T) {
+	// given
+	now := time.Unix(1000, 0)
+	fake := &fakeRepository{err: errors.New("connection refused")}
+	repo := newTestRepository(fake, &now)
+	repo.OK(context.Background())
+	repo.OK(context.Background())
+
+	// when
+	now = now.Add(10 * time.Second)
+	fake.err = nil
+	ok, err := repo.OK(context.Background())
+
+	// then
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, Closed, repo.State())
+}
+
+func Test_OK_ShouldReopenCircuitAfterFailedTrial(t *testing.T) {
+	// given
+	now := time.Unix(1000, 0)
+	fake := &fakeRepository{err: errors.New("connection refused")}
+	repo := newTestRepository(fake, &now)
+	repo.OK(context.Background())
+	repo.OK(context.Background())
+
+	// when
+	now = now.Add(10 * time.Second)
+	assert.Equal(t, HalfOpen, repo.State())
+	repo.OK(context.Background())
+	_, err := repo.OK(context.Background())
+
+	// then
+	assert.True(t, IsOpen(err))
+	assert.Equal(t, 3, fake.calls)
+	assert.Equal(t, "open", repo.CircuitState())
+}
+
+func Test_OK_ShouldIgnoreCanceledCalls(t *testing.T) {
+	// given
+	now := time.Unix(1000, 0)
+	fake := &fakeRepository{err: context.Canceled}
+	repo := newTestRepository(fake, &now)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// when
+	repo.OK(ctx)
+	repo.OK(ctx)
+
+	// then
+	assert.Equal(t, Closed, repo.State())
+}
@@ -0,0 +1,146 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/mateuszdyminski/go-template/app"
+)
+
+const apiKeyColumns = `prefix, hash, owner, scopes, created_at, expires_at, last_used_at, revoked_at`
+
+type pgAPIKeyStore struct {
+	db *sql.DB
+}
+
+// NewAPIKeyStore - returns API key store on top of postgres DB.
+// It implements app.APIKeyStore interface.
+func NewAPIKeyStore(db *sql.DB) app.APIKeyStore {
+	return &pgAPIKeyStore{db: db}
+}
+
+// Create - stores new key.
+func (s *pgAPIKeyStore) Create(ctx context.Context, key app.APIKey) error {
+	err := withDeadline(ctx, s.db, func(q querier) error { return insertAPIKey(ctx, q, key) })
+	if err != nil {
+		return errors.Wrap(err, "can't create api key")
+	}
+	return nil
+}
+
+// Get - returns key with the prefix.
+func (s *pgAPIKeyStore) Get(ctx context.Context, prefix string) (*app.APIKey, error) {
+	var key *app.APIKey
+	err := withDeadline(ctx, s.db, func(q querier) (err error) {
+		key, err = scanAPIKey(q.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE prefix = $1`, prefix))
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return nil, app.ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "can't get api key")
+	}
+
+	return key, nil
+}
+
+// List - returns keys of the owner or all keys, newest first.
+func (s *pgAPIKeyStore) List(ctx context.Context, owner string) ([]app.APIKey, error) {
+	keys := []app.APIKey{}
+	err := withDeadline(ctx, s.db, func(q querier) error {
+		rows, err := q.QueryContext(ctx,
+			`SELECT `+apiKeyColumns+` FROM api_keys WHERE $1 = '' OR owner = $1 ORDER BY created_at DESC`, owner)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			key, err := scanAPIKey(rows)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, *key)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "can't list api keys")
+	}
+
+	return keys, nil
+}
+
+// Rotate - stores the replacement key and shortens expiry of the old one in one transaction.
+func (s *pgAPIKeyStore) Rotate(ctx context.Context, prefix string, replacement app.APIKey, oldExpiresAt time.Time) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `UPDATE api_keys SET expires_at = LEAST(expires_at, $2)
+			WHERE prefix = $1 AND revoked_at IS NULL`, prefix, oldExpiresAt)
+		if err != nil {
+			return errors.Wrap(err, "can't expire rotated api key")
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return app.ErrAPIKeyNotFound
+		}
+
+		return errors.Wrap(insertAPIKey(ctx, tx, replacement), "can't create replacement api key")
+	})
+}
+
+// Revoke - marks the key as revoked, revoking twice keeps the original revocation time.
+func (s *pgAPIKeyStore) Revoke(ctx context.Context, prefix string) error {
+	return withDeadline(ctx, s.db, func(q querier) error {
+		res, err := q.ExecContext(ctx,
+			`UPDATE api_keys SET revoked_at = COALESCE(revoked_at, now()) WHERE prefix = $1`, prefix)
+		if err != nil {
+			return errors.Wrap(err, "can't revoke api key")
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return app.ErrAPIKeyNotFound
+		}
+
+		return nil
+	})
+}
+
+// Touch - updates last-used timestamp of the key.
+func (s *pgAPIKeyStore) Touch(ctx context.Context, prefix string, t time.Time) error {
+	err := withDeadline(ctx, s.db, func(q querier) error {
+		_, err := q.ExecContext(ctx, `UPDATE api_keys SET last_used_at = $2 WHERE prefix = $1`, prefix, t)
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "can't update api key last used time")
+	}
+	return nil
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertAPIKey(ctx context.Context, db execer, key app.APIKey) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO api_keys (prefix, hash, owner, scopes, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		key.Prefix, key.Hash, key.Owner, pq.Array(key.Scopes), key.CreatedAt, key.ExpiresAt)
+	return err
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row scanner) (*app.APIKey, error) {
+	var key app.APIKey
+	err := row.Scan(&key.Prefix, &key.Hash, &key.Owner, pq.Array(&key.Scopes),
+		&key.CreatedAt, &key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
@@ -0,0 +1,83 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mateuszdyminski/go-template/app"
+)
+
+func Test_APIKeyStore_Get_ShouldReturnNotFound(t *testing.T) {
+	// given
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	store := NewAPIKeyStore(db)
+	mock.ExpectQuery("SELECT (.+) FROM api_keys WHERE prefix").
+		WithArgs("abc").
+		WillReturnRows(sqlmock.NewRows([]string{"prefix"}))
+
+	// when
+	key, err := store.Get(context.Background(), "abc")
+
+	// then
+	assert.Nil(t, key)
+	assert.Equal(t, app.ErrAPIKeyNotFound, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_APIKeyStore_Get_ShouldScanKey(t *testing.T) {
+	// given
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	created := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewAPIKeyStore(db)
+	mock.ExpectQuery("SELECT (.+) FROM api_keys WHERE prefix").
+		WithArgs("abc").
+		WillReturnRows(sqlmock.NewRows([]string{"prefix", "hash", "owner", "scopes", "created_at", "expires_at", "last_used_at", "revoked_at"}).
+			AddRow("abc", []byte{1, 2}, "billing", "{invoices:read,invoices:write}", created, nil, created, nil))
+
+	// when
+	key, err := store.Get(context.Background(), "abc")
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "billing", key.Owner)
+	assert.Equal(t, []string{"invoices:read", "invoices:write"}, key.Scopes)
+	assert.Nil(t, key.ExpiresAt)
+	assert.Equal(t, created, *key.LastUsedAt)
+	assert.True(t, key.Active(created))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_APIKeyStore_Rotate_ShouldFailForUnknownKey(t *testing.T) {
+	// given
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	store := NewAPIKeyStore(db)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE api_keys SET expires_at").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	// when
+	err = store.Rotate(context.Background(), "abc", app.APIKey{Prefix: "def"}, time.Now())
+
+	// then
+	assert.Equal(t, app.ErrAPIKeyNotFound, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,116 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mateuszdyminski/go-template/app"
+)
+
+const auditColumns = `id, time, principal_id, principal_type, action, route, target, request_id, client_ip, status, outcome, summary`
+
+// auditPageColumns - columns of the audit events fields clients can sort and filter by.
+var auditPageColumns = map[string]string{
+	"id":          "id",
+	"time":        "time",
+	"principalId": "principal_id",
+	"action":      "action",
+	"route":       "route",
+	"target":      "target",
+	"outcome":     "outcome",
+	"requestId":   "request_id",
+}
+
+// auditInsertColumns - number of columns set by Append, id is generated.
+const auditInsertColumns = 11
+
+// MaxAuditBatch - max number of events appended at once, Postgres accepts up to 65535 parameters per statement.
+const MaxAuditBatch = 65535 / auditInsertColumns
+
+type pgAuditStore struct {
+	db *sql.DB
+}
+
+// NewAuditStore - returns audit store on top of postgres DB.
+// It implements app.AuditStore interface.
+func NewAuditStore(db *sql.DB) app.AuditStore {
+	return &pgAuditStore{db: db}
+}
+
+// Append - inserts the events with a single statement, the table rejects updates and deletes.
+func (s *pgAuditStore) Append(ctx context.Context, events []app.AuditEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	if len(events) > MaxAuditBatch {
+		return errors.Errorf("can't append more than %d audit events at once, got: %d", MaxAuditBatch, len(events))
+	}
+
+	values := make([]string, 0, len(events))
+	args := make([]interface{}, 0, len(events)*auditInsertColumns)
+	for i, e := range events {
+		placeholders := make([]string, auditInsertColumns)
+		for j := range placeholders {
+			placeholders[j] = fmt.Sprintf("$%d", i*auditInsertColumns+j+1)
+		}
+		values = append(values, "("+strings.Join(placeholders, ", ")+")")
+
+		var summary interface{}
+		if len(e.Summary) > 0 {
+			summary = []byte(e.Summary)
+		}
+		args = append(args, e.Time, e.PrincipalID, e.PrincipalType, e.Action, e.Route, e.Target,
+			e.RequestID, e.ClientIP, e.Status, e.Outcome, summary)
+	}
+
+	err := withDeadline(ctx, s.db, func(q querier) error {
+		_, err := q.ExecContext(ctx, `INSERT INTO audit_log
+			(time, principal_id, principal_type, action, route, target, request_id, client_ip, status, outcome, summary)
+			VALUES `+strings.Join(values, ", "), args...)
+		return err
+	})
+	return errors.Wrap(err, "can't append audit events")
+}
+
+// List - returns page of the events, one event more than the limit.
+func (s *pgAuditStore) List(ctx context.Context, req app.PageRequest) ([]app.AuditEvent, error) {
+	query, args, err := PageQuery(`SELECT `+auditColumns+` FROM audit_log`, req, auditPageColumns)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't list audit events")
+	}
+
+	events := []app.AuditEvent{}
+	err = withDeadline(ctx, s.db, func(q querier) error {
+		rows, err := q.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var (
+				e       app.AuditEvent
+				summary []byte
+			)
+			if err := rows.Scan(&e.ID, &e.Time, &e.PrincipalID, &e.PrincipalType, &e.Action, &e.Route, &e.Target,
+				&e.RequestID, &e.ClientIP, &e.Status, &e.Outcome, &summary); err != nil {
+				return err
+			}
+			if len(summary) > 0 {
+				e.Summary = json.RawMessage(summary)
+			}
+			events = append(events, e)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "can't list audit events")
+	}
+
+	return events, nil
+}
@@ -0,0 +1,82 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mateuszdyminski/go-template/app"
+)
+
+func Test_AuditStore_Append_ShouldInsertEventsWithSingleStatement(t *testing.T) {
+	// given
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewAuditStore(db)
+	mock.ExpectExec(`INSERT INTO audit_log (.+) VALUES \(\$1, (.+), \$11\), \(\$12, (.+), \$22\)`).
+		WithArgs(now, "billing", "apikey", "DELETE /admin/apikeys/{prefix}", "/admin/apikeys/{prefix}", "/admin/apikeys/abc",
+			"req-1", "10.0.0.1", 204, app.AuditSuccess, []byte(`{"prefix":"abc"}`),
+			now, "", "", "POST /drain", "/drain", "/drain", "req-2", "10.0.0.2", 200, app.AuditSuccess, nil).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	// when
+	err = store.Append(context.Background(), []app.AuditEvent{
+		{Time: now, PrincipalID: "billing", PrincipalType: "apikey", Action: "DELETE /admin/apikeys/{prefix}", Route: "/admin/apikeys/{prefix}",
+			Target: "/admin/apikeys/abc", RequestID: "req-1", ClientIP: "10.0.0.1", Status: 204, Outcome: app.AuditSuccess, Summary: json.RawMessage(`{"prefix":"abc"}`)},
+		{Time: now, Action: "POST /drain", Route: "/drain", Target: "/drain", RequestID: "req-2", ClientIP: "10.0.0.2", Status: 200, Outcome: app.AuditSuccess},
+	})
+
+	// then
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_AuditStore_List_ShouldFilterEvents(t *testing.T) {
+	// given
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewAuditStore(db)
+	mock.ExpectQuery(`SELECT (.+) FROM audit_log WHERE principal_id = \$1 AND outcome = \$2 AND time >= \$3 AND \(\(id < \$4\)\) ORDER BY id DESC LIMIT \$5`).
+		WithArgs("billing", app.AuditDenied, "2020-01-01T12:00:00Z", "10", 4).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "time", "principal_id", "principal_type", "action", "route", "target",
+			"request_id", "client_ip", "status", "outcome", "summary"}).
+			AddRow(9, now, "billing", "apikey", "DELETE /api/items/{id}", "/api/items/{id}", "/api/items/1",
+				"req-1", "10.0.0.1", 403, app.AuditDenied, nil).
+			AddRow(7, now, "billing", "apikey", "PUT /api/items/{id}", "/api/items/{id}", "/api/items/2",
+				"req-2", "10.0.0.1", 403, app.AuditDenied, []byte(`{"name":"new"}`)))
+
+	// when
+	events, err := store.List(context.Background(), app.PageRequest{
+		Limit: 3,
+		Sort:  []app.Sort{{Field: "id", Desc: true}},
+		Filters: []app.Filter{
+			{Field: "principalId", Op: app.FilterEq, Values: []string{"billing"}},
+			{Field: "outcome", Op: app.FilterEq, Values: []string{app.AuditDenied}},
+			{Field: "time", Op: app.FilterGte, Values: []string{"2020-01-01T12:00:00Z"}},
+		},
+		Cursor: &app.Cursor{Values: []string{"10"}},
+	})
+
+	// then
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, int64(9), events[0].ID)
+	assert.Nil(t, events[0].Summary)
+	assert.JSONEq(t, `{"name":"new"}`, string(events[1].Summary))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
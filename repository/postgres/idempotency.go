@@ -0,0 +1,138 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mateuszdyminski/go-template/app"
+)
+
+// beginQuery inserts in-progress record, replacing the expired or stale one. No row is returned
+// when the key has a live record.
+const beginQuery = `INSERT INTO idempotency_keys (key, fingerprint, created_at, expires_at) VALUES ($1, $2, $3, $4)
+ON CONFLICT (key) DO UPDATE SET
+	fingerprint = EXCLUDED.fingerprint,
+	completed = false,
+	status = 0,
+	header = NULL,
+	body = NULL,
+	body_omitted = false,
+	created_at = EXCLUDED.created_at,
+	expires_at = EXCLUDED.expires_at
+WHERE idempotency_keys.expires_at <= $3
+	OR (NOT idempotency_keys.completed AND idempotency_keys.created_at < $5)
+RETURNING key`
+
+type pgIdempotencyStore struct {
+	db *sql.DB
+}
+
+// NewIdempotencyStore - returns idempotency key store shared by all replicas.
+// It implements app.IdempotencyStore interface.
+func NewIdempotencyStore(db *sql.DB) app.IdempotencyStore {
+	return &pgIdempotencyStore{db: db}
+}
+
+// Begin - saves in-progress record or returns the live one of the key.
+func (s *pgIdempotencyStore) Begin(ctx context.Context, rec app.IdempotencyRecord, staleBefore time.Time) (bool, *app.IdempotencyRecord, error) {
+	var (
+		began    bool
+		existing *app.IdempotencyRecord
+	)
+	err := withDeadline(ctx, s.db, func(q querier) error {
+		// the live record can expire between the queries - try again then
+		for attempt := 0; attempt < 2; attempt++ {
+			var key string
+			err := q.QueryRowContext(ctx, beginQuery, rec.Key, rec.Fingerprint, rec.CreatedAt, rec.ExpiresAt, staleBefore).Scan(&key)
+			if err == nil {
+				began = true
+				return nil
+			}
+			if err != sql.ErrNoRows {
+				return errors.Wrap(err, "can't begin idempotent request")
+			}
+
+			if existing, err = s.get(ctx, q, rec.Key); err != nil || existing != nil {
+				return err
+			}
+		}
+
+		return errors.New("can't begin idempotent request: record of the key keeps changing")
+	})
+	if err != nil {
+		return false, nil, err
+	}
+
+	return began, existing, nil
+}
+
+func (s *pgIdempotencyStore) get(ctx context.Context, q querier, key string) (*app.IdempotencyRecord, error) {
+	var (
+		rec    app.IdempotencyRecord
+		header []byte
+	)
+	err := q.QueryRowContext(ctx, `SELECT key, fingerprint, completed, status, header, body, body_omitted, created_at, expires_at
+		FROM idempotency_keys WHERE key = $1`, key).
+		Scan(&rec.Key, &rec.Fingerprint, &rec.Completed, &rec.Status, &header, &rec.Body, &rec.BodyOmitted, &rec.CreatedAt, &rec.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "can't get idempotency key")
+	}
+	if len(header) > 0 {
+		if err := json.Unmarshal(header, &rec.Header); err != nil {
+			return nil, errors.Wrap(err, "can't decode stored response headers")
+		}
+	}
+
+	return &rec, nil
+}
+
+// Complete - stores response of the in-progress record.
+func (s *pgIdempotencyStore) Complete(ctx context.Context, rec app.IdempotencyRecord) error {
+	header, err := json.Marshal(rec.Header)
+	if err != nil {
+		return errors.Wrap(err, "can't encode response headers")
+	}
+
+	return withDeadline(ctx, s.db, func(q querier) error {
+		res, err := q.ExecContext(ctx, `UPDATE idempotency_keys SET completed = true, status = $3, header = $4, body = $5, body_omitted = $6
+			WHERE key = $1 AND fingerprint = $2 AND NOT completed`, rec.Key, rec.Fingerprint, rec.Status, header, rec.Body, rec.BodyOmitted)
+		if err != nil {
+			return errors.Wrap(err, "can't complete idempotent request")
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return errors.Errorf("idempotency key %s isn't in progress anymore", rec.Key)
+		}
+
+		return nil
+	})
+}
+
+// Release - deletes in-progress record of the key.
+func (s *pgIdempotencyStore) Release(ctx context.Context, key string) error {
+	err := withDeadline(ctx, s.db, func(q querier) error {
+		_, err := q.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = $1 AND NOT completed`, key)
+		return err
+	})
+	return errors.Wrap(err, "can't release idempotency key")
+}
+
+// DeleteExpired - deletes records expired before now.
+func (s *pgIdempotencyStore) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	var deleted int64
+	err := withDeadline(ctx, s.db, func(q querier) error {
+		res, err := q.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= $1`, now)
+		if err != nil {
+			return err
+		}
+		deleted, err = res.RowsAffected()
+		return err
+	})
+	return deleted, errors.Wrap(err, "can't delete expired idempotency keys")
+}
@@ -0,0 +1,69 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mateuszdyminski/go-template/app"
+)
+
+func Test_IdempotencyStore_Begin_ShouldSaveNewRecord(t *testing.T) {
+	// given
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewIdempotencyStore(db)
+	mock.ExpectQuery("INSERT INTO idempotency_keys (.+) ON CONFLICT").
+		WithArgs("k", "fp", now, now.Add(time.Hour), now.Add(-time.Minute)).
+		WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("k"))
+
+	// when
+	began, existing, err := store.Begin(context.Background(),
+		app.IdempotencyRecord{Key: "k", Fingerprint: "fp", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}, now.Add(-time.Minute))
+
+	// then
+	assert.NoError(t, err)
+	assert.True(t, began)
+	assert.Nil(t, existing)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_IdempotencyStore_Begin_ShouldReturnLiveRecord(t *testing.T) {
+	// given
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewIdempotencyStore(db)
+	mock.ExpectQuery("INSERT INTO idempotency_keys (.+) ON CONFLICT").
+		WillReturnRows(sqlmock.NewRows([]string{"key"}))
+	mock.ExpectQuery("SELECT (.+) FROM idempotency_keys WHERE key").
+		WithArgs("k").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "fingerprint", "completed", "status", "header", "body", "body_omitted", "created_at", "expires_at"}).
+			AddRow("k", "fp", true, 201, []byte(`{"Location":["/api/orders/1"]}`), []byte(`{"id":1}`), false, now, now.Add(time.Hour)))
+
+	// when
+	began, existing, err := store.Begin(context.Background(),
+		app.IdempotencyRecord{Key: "k", Fingerprint: "fp", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}, now.Add(-time.Minute))
+
+	// then
+	require.NoError(t, err)
+	assert.False(t, began)
+	assert.True(t, existing.Completed)
+	assert.Equal(t, 201, existing.Status)
+	assert.Equal(t, []string{"/api/orders/1"}, existing.Header["Location"])
+	assert.Equal(t, `{"id":1}`, string(existing.Body))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,122 @@
+package postgres
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/mateuszdyminski/go-template/app"
+)
+
+var filterOperators = map[string]string{
+	app.FilterEq:  "=",
+	app.FilterNe:  "<>",
+	app.FilterLt:  "<",
+	app.FilterLte: "<=",
+	app.FilterGt:  ">",
+	app.FilterGte: ">=",
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// PageQuery appends filters, keyset condition, order and limit of the page to the query selecting
+// from a table, e.g. "SELECT id, name FROM users". Columns maps fields of the request to the column
+// names, other fields are rejected. Sort columns must not be NULL.
+// The query fetches one row more than the limit, so the caller knows whether there's another page.
+// Rows of backward pages are returned in reverse order.
+func PageQuery(selectFrom string, req app.PageRequest, columns map[string]string) (string, []interface{}, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	for _, f := range req.Filters {
+		col, ok := columns[f.Field]
+		if !ok {
+			return "", nil, errors.Errorf("unknown filter field %q", f.Field)
+		}
+		if len(f.Values) == 0 {
+			return "", nil, errors.Errorf("filter of %q has no value", f.Field)
+		}
+
+		switch f.Op {
+		case app.FilterIn:
+			where = append(where, col+" = ANY("+arg(pq.Array(f.Values))+")")
+		case app.FilterPrefix:
+			where = append(where, col+" LIKE "+arg(likeEscaper.Replace(f.Values[0])+"%"))
+		default:
+			op, ok := filterOperators[f.Op]
+			if !ok {
+				return "", nil, errors.Errorf("unknown filter operator %q", f.Op)
+			}
+			where = append(where, col+" "+op+" "+arg(f.Values[0]))
+		}
+	}
+
+	sortCols := make([]string, len(req.Sort))
+	for i, s := range req.Sort {
+		col, ok := columns[s.Field]
+		if !ok {
+			return "", nil, errors.Errorf("unknown sort field %q", s.Field)
+		}
+		sortCols[i] = col
+	}
+
+	backward := req.Cursor != nil && req.Cursor.Backward
+	// ascending order unless the field is sorted descending, backward pages flip the order
+	ascending := func(s app.Sort) bool {
+		return s.Desc == backward
+	}
+
+	if req.Cursor != nil {
+		if len(req.Cursor.Values) != len(req.Sort) {
+			return "", nil, errors.Errorf("cursor has %d values, expected %d", len(req.Cursor.Values), len(req.Sort))
+		}
+
+		// (a > $1) OR (a = $1 AND b > $2) OR ...
+		values := make([]string, len(req.Cursor.Values))
+		for i, v := range req.Cursor.Values {
+			values[i] = arg(v)
+		}
+		var or []string
+		for i, s := range req.Sort {
+			var and []string
+			for j := 0; j < i; j++ {
+				and = append(and, sortCols[j]+" = "+values[j])
+			}
+			op := "<"
+			if ascending(s) {
+				op = ">"
+			}
+			and = append(and, sortCols[i]+" "+op+" "+values[i])
+			or = append(or, "("+strings.Join(and, " AND ")+")")
+		}
+		where = append(where, "("+strings.Join(or, " OR ")+")")
+	}
+
+	query := selectFrom
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	if len(req.Sort) > 0 {
+		order := make([]string, len(req.Sort))
+		for i, s := range req.Sort {
+			order[i] = sortCols[i] + " DESC"
+			if ascending(s) {
+				order[i] = sortCols[i] + " ASC"
+			}
+		}
+		query += " ORDER BY " + strings.Join(order, ", ")
+	}
+
+	query += " LIMIT " + arg(req.Limit+1)
+
+	return query, args, nil
+}
@@ -0,0 +1,95 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mateuszdyminski/go-template/app"
+)
+
+var pageColumns = map[string]string{"id": "id", "name": "name", "createdAt": "created_at"}
+
+func Test_PageQuery_ShouldBuildFirstPage(t *testing.T) {
+	// given
+	req := app.PageRequest{
+		Limit: 20,
+		Sort:  []app.Sort{{Field: "createdAt", Desc: true}, {Field: "id", Desc: true}},
+		Filters: []app.Filter{
+			{Field: "name", Op: app.FilterPrefix, Values: []string{"50%_off"}},
+			{Field: "id", Op: app.FilterIn, Values: []string{"1", "2"}},
+			{Field: "createdAt", Op: app.FilterGte, Values: []string{"2020-01-01T00:00:00Z"}},
+		},
+	}
+
+	// when
+	query, args, err := PageQuery("SELECT id, name, created_at FROM items", req, pageColumns)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT id, name, created_at FROM items WHERE name LIKE $1 AND id = ANY($2) AND created_at >= $3 "+
+		"ORDER BY created_at DESC, id DESC LIMIT $4", query)
+	assert.Equal(t, []interface{}{`50\%\_off%`, pq.Array([]string{"1", "2"}), "2020-01-01T00:00:00Z", 21}, args)
+}
+
+func Test_PageQuery_ShouldBuildKeysetCondition(t *testing.T) {
+	tests := []struct {
+		name     string
+		backward bool
+		query    string
+	}{
+		{
+			name: "forward",
+			query: "SELECT * FROM items WHERE ((name > $1) OR (name = $1 AND created_at < $2) OR (name = $1 AND created_at = $2 AND id < $3)) " +
+				"ORDER BY name ASC, created_at DESC, id DESC LIMIT $4",
+		},
+		{
+			name:     "backward",
+			backward: true,
+			query: "SELECT * FROM items WHERE ((name < $1) OR (name = $1 AND created_at > $2) OR (name = $1 AND created_at = $2 AND id > $3)) " +
+				"ORDER BY name DESC, created_at ASC, id ASC LIMIT $4",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := app.PageRequest{
+				Limit:  10,
+				Sort:   []app.Sort{{Field: "name"}, {Field: "createdAt", Desc: true}, {Field: "id", Desc: true}},
+				Cursor: &app.Cursor{Values: []string{"bob", "2020-01-01T00:00:00Z", "7"}, Backward: tt.backward},
+			}
+
+			// when
+			query, args, err := PageQuery("SELECT * FROM items", req, pageColumns)
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, tt.query, query)
+			assert.Equal(t, []interface{}{"bob", "2020-01-01T00:00:00Z", "7", 11}, args)
+		})
+	}
+}
+
+func Test_PageQuery_ShouldRejectUnknownFields(t *testing.T) {
+	tests := []struct {
+		name string
+		req  app.PageRequest
+	}{
+		{name: "sort", req: app.PageRequest{Sort: []app.Sort{{Field: "secret"}}}},
+		{name: "filter", req: app.PageRequest{Filters: []app.Filter{{Field: "secret", Op: app.FilterEq, Values: []string{"x"}}}}},
+		{name: "operator", req: app.PageRequest{Filters: []app.Filter{{Field: "id", Op: "like", Values: []string{"x"}}}}},
+		{name: "cursor", req: app.PageRequest{Sort: []app.Sort{{Field: "id"}}, Cursor: &app.Cursor{}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// when
+			_, _, err := PageQuery("SELECT * FROM items", tt.req, pageColumns)
+
+			// then
+			assert.Error(t, err)
+		})
+	}
+}
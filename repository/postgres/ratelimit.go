@@ -0,0 +1,92 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mateuszdyminski/go-template/app"
+)
+
+// takeQuery increments counter of the current window. Counter of the previous window
+// is kept to estimate the number of requests in the sliding window.
+const takeQuery = `INSERT INTO rate_limits (key, window_start, count, prev_count) VALUES ($1, $2, 1, 0)
+ON CONFLICT (key) DO UPDATE SET
+	prev_count = CASE
+		WHEN rate_limits.window_start = $2 THEN rate_limits.prev_count
+		WHEN rate_limits.window_start = $3 THEN rate_limits.count
+		ELSE 0 END,
+	count = CASE WHEN rate_limits.window_start = $2 THEN rate_limits.count + 1 ELSE 1 END,
+	window_start = $2
+RETURNING count, prev_count`
+
+type pgRateLimitStore struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// NewRateLimitStore - returns sliding window rate limit store shared by all replicas.
+// It implements app.RateLimitStore interface.
+func NewRateLimitStore(db *sql.DB) app.RateLimitStore {
+	return &pgRateLimitStore{db: db, now: time.Now}
+}
+
+// Take - counts request of the key in the current window.
+func (s *pgRateLimitStore) Take(ctx context.Context, key string, limit app.RateLimit) (app.RateLimitResult, error) {
+	now := s.now().UTC()
+	windowStart := now.Truncate(limit.Window)
+
+	var count, prevCount int
+	err := withDeadline(ctx, s.db, func(q querier) error {
+		return q.QueryRowContext(ctx, takeQuery, key, windowStart, windowStart.Add(-limit.Window)).Scan(&count, &prevCount)
+	})
+	if err != nil {
+		return app.RateLimitResult{}, errors.Wrap(err, "can't take from rate limit")
+	}
+
+	elapsed := now.Sub(windowStart)
+	return slidingWindow(limit, count, prevCount, elapsed), nil
+}
+
+// DeleteExpired - deletes counters of the windows started before, they don't affect the sliding window anymore.
+func (s *pgRateLimitStore) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	var deleted int64
+	err := withDeadline(ctx, s.db, func(q querier) error {
+		res, err := q.ExecContext(ctx, `DELETE FROM rate_limits WHERE window_start < $1`, before)
+		if err != nil {
+			return err
+		}
+		deleted, err = res.RowsAffected()
+		return err
+	})
+	return deleted, errors.Wrap(err, "can't delete expired rate limits")
+}
+
+// slidingWindow estimates number of requests in the last window assuming the requests
+// of the previous window were evenly distributed.
+func slidingWindow(limit app.RateLimit, count, prevCount int, elapsed time.Duration) app.RateLimitResult {
+	prevWeight := 1 - float64(elapsed)/float64(limit.Window)
+	estimate := float64(prevCount)*prevWeight + float64(count)
+
+	res := app.RateLimitResult{
+		Allowed:   estimate <= float64(limit.Requests),
+		Remaining: int(math.Max(0, float64(limit.Requests)-math.Ceil(estimate))),
+		Reset:     limit.Window - elapsed,
+	}
+
+	if !res.Allowed {
+		if count > limit.Requests || prevCount == 0 {
+			// current window alone is over the limit - wait for the next one
+			res.RetryAfter = limit.Window - elapsed
+		} else {
+			// wait until the previous window weight drops enough
+			until := time.Duration((1 - float64(limit.Requests-count)/float64(prevCount)) * float64(limit.Window))
+			res.RetryAfter = until - elapsed
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,70 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mateuszdyminski/go-template/app"
+)
+
+func Test_Take_ShouldAllowRequestsBelowLimit(t *testing.T) {
+	// given
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	now := time.Date(2020, 1, 1, 12, 0, 15, 0, time.UTC)
+	store := &pgRateLimitStore{db: db, now: func() time.Time { return now }}
+	mock.ExpectQuery("INSERT INTO rate_limits").
+		WithArgs("ip:1.2.3.4", time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC), time.Date(2020, 1, 1, 11, 59, 0, 0, time.UTC)).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "prev_count"}).AddRow(3, 4))
+
+	// when
+	res, err := store.Take(context.Background(), "ip:1.2.3.4", app.RateLimit{Requests: 10, Window: time.Minute})
+
+	// then
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 4, res.Remaining, "estimate should be 4*0.75+3=6")
+	assert.Equal(t, 45*time.Second, res.Reset)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_DeleteExpired_ShouldDeleteOldWindows(t *testing.T) {
+	// given
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	before := time.Date(2020, 1, 1, 11, 58, 0, 0, time.UTC)
+	store := &pgRateLimitStore{db: db, now: time.Now}
+	mock.ExpectExec("DELETE FROM rate_limits WHERE window_start < ").
+		WithArgs(before).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	// when
+	n, err := store.DeleteExpired(context.Background(), before)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_slidingWindow_ShouldRejectWhenPreviousWindowWeightIsTooHigh(t *testing.T) {
+	// when
+	res := slidingWindow(app.RateLimit{Requests: 10, Window: time.Minute}, 5, 10, 15*time.Second)
+
+	// then
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	assert.Equal(t, 15*time.Second, res.RetryAfter, "estimate should drop to the limit at 30s")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/pkg/errors"
@@ -15,9 +16,8 @@ type pgRepository struct {
 	db *sql.DB
 }
 
-// NewPostgresRepository - returns new repository which connects to postgres DB.
-// It implements app.Repository interface.
-func NewPostgresRepository(host string, port int, user, password, dbname string) (app.Repository, error) {
+// NewDB - returns connection pool to postgres DB shared by the repository and the stores.
+func NewDB(host string, port int, user, password, dbname string) (*sql.DB, error) {
 	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
 		host, port, user, password, dbname)
 	db, err := sql.Open("postgres", psqlInfo)
@@ -25,7 +25,13 @@ func NewPostgresRepository(host string, port int, user, password, dbname string)
 		return nil, errors.Wrap(err, "can't create postgres repo")
 	}
 
-	return &pgRepository{db: db}, nil
+	return db, nil
+}
+
+// NewPostgresRepository - returns new repository on top of postgres DB.
+// It implements app.Repository interface.
+func NewPostgresRepository(db *sql.DB) app.Repository {
+	return &pgRepository{db: db}
 }
 
 // OK - returns information whether connection to DB is up and running.
@@ -36,3 +42,63 @@ func (r *pgRepository) OK(ctx context.Context) (bool, error) {
 
 	return true, nil
 }
+
+// querier - statements shared by *sql.DB and *sql.Tx.
+type querier interface {
+	execer
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// withDeadline runs fn with db. When ctx has a deadline, e.g. of the route timeout, fn runs
+// in a transaction with the remaining time set as statement_timeout, see withTx. Rows must be
+// read before fn returns.
+func withDeadline(ctx context.Context, db *sql.DB, fn func(querier) error) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return fn(db)
+	}
+	return withTx(ctx, db, func(tx *sql.Tx) error { return fn(tx) })
+}
+
+// withTx runs fn in a transaction. When ctx has a deadline, the remaining time is set as
+// statement_timeout, so Postgres cancels the queries itself instead of running them
+// after the client gave up.
+func withTx(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "can't begin transaction")
+	}
+
+	if timeout, ok := statementTimeout(ctx); ok {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout.Milliseconds())); err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "can't set statement timeout")
+		}
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "can't commit transaction")
+	}
+
+	return nil
+}
+
+// statementTimeout returns time left to the ctx deadline, at least 1ms as 0 disables the timeout.
+func statementTimeout(ctx context.Context) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+
+	left := time.Until(deadline)
+	if left < time.Millisecond {
+		left = time.Millisecond
+	}
+
+	return left, true
+}
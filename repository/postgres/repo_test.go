@@ -2,7 +2,9 @@ package postgres
 
 import (
 	"context"
+	"database/sql"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
@@ -43,3 +45,91 @@ func Test_OK_ShouldReturnFalseAndError(t *testing.T) {
 	assert.Error(t, err, "DB should be closed and return error")
 	assert.Falsef(t, ok, "status should return false")
 }
+
+func Test_withTx_ShouldSetStatementTimeoutFromDeadline(t *testing.T) {
+	// given
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SET LOCAL statement_timeout = \d+`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// when
+	err = withTx(ctx, db, func(*sql.Tx) error { return nil })
+
+	// then
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_withTx_ShouldNotSetStatementTimeoutWithoutDeadline(t *testing.T) {
+	// given
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	// when
+	err = withTx(context.Background(), db, func(*sql.Tx) error { return sql.ErrNoRows })
+
+	// then
+	assert.Equal(t, sql.ErrNoRows, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_withDeadline_ShouldRunInTransactionWithDeadline(t *testing.T) {
+	// given
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SET LOCAL statement_timeout = \d+`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT role, permission FROM role_permissions`).
+		WillReturnRows(sqlmock.NewRows([]string{"role", "permission"}).AddRow("admin", "apikeys:write"))
+	mock.ExpectCommit()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// when
+	roles, err := NewRoleStore(db).RolePermissions(ctx)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"admin": {"apikeys:write"}}, roles)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_withDeadline_ShouldRunWithoutTransactionWithoutDeadline(t *testing.T) {
+	// given
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT role, permission FROM role_permissions`).
+		WillReturnRows(sqlmock.NewRows([]string{"role", "permission"}))
+
+	// when
+	roles, err := NewRoleStore(db).RolePermissions(context.Background())
+
+	// then
+	assert.NoError(t, err)
+	assert.Empty(t, roles)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+
+	"github.com/mateuszdyminski/go-template/app"
+)
+
+type pgRoleStore struct {
+	db *sql.DB
+}
+
+// NewRoleStore - returns store of the role permissions kept in role_permissions table.
+// It implements app.RoleStore interface.
+func NewRoleStore(db *sql.DB) app.RoleStore {
+	return &pgRoleStore{db: db}
+}
+
+// RolePermissions - returns permissions of all roles.
+func (s *pgRoleStore) RolePermissions(ctx context.Context) (map[string][]string, error) {
+	roles := make(map[string][]string)
+	err := withDeadline(ctx, s.db, func(q querier) error {
+		rows, err := q.QueryContext(ctx, `SELECT role, permission FROM role_permissions ORDER BY role, permission`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var role, permission string
+			if err := rows.Scan(&role, &permission); err != nil {
+				return err
+			}
+			roles[role] = append(roles[role], permission)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "can't load role permissions")
+	}
+
+	return roles, nil
+}
@@ -0,0 +1,89 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// migrations - idempotent DDL statements executed in order on startup.
+// Append new statements at the end, never modify the existing ones.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS rate_limits (
+		key          TEXT PRIMARY KEY,
+		window_start TIMESTAMPTZ NOT NULL,
+		count        INTEGER NOT NULL,
+		prev_count   INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		prefix       TEXT PRIMARY KEY,
+		hash         BYTEA NOT NULL,
+		owner        TEXT NOT NULL,
+		scopes       TEXT[] NOT NULL DEFAULT '{}',
+		created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+		expires_at   TIMESTAMPTZ,
+		last_used_at TIMESTAMPTZ,
+		revoked_at   TIMESTAMPTZ
+	)`,
+	`CREATE INDEX IF NOT EXISTS api_keys_owner_idx ON api_keys (owner)`,
+	`CREATE TABLE IF NOT EXISTS role_permissions (
+		role       TEXT NOT NULL,
+		permission TEXT NOT NULL,
+		PRIMARY KEY (role, permission)
+	)`,
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id             BIGSERIAL PRIMARY KEY,
+		time           TIMESTAMPTZ NOT NULL,
+		principal_id   TEXT NOT NULL,
+		principal_type TEXT NOT NULL,
+		action         TEXT NOT NULL,
+		route          TEXT NOT NULL,
+		target         TEXT NOT NULL,
+		request_id     TEXT NOT NULL,
+		client_ip      TEXT NOT NULL,
+		status         INTEGER NOT NULL,
+		outcome        TEXT NOT NULL,
+		summary        JSONB
+	)`,
+	`CREATE INDEX IF NOT EXISTS audit_log_time_idx ON audit_log (time)`,
+	`CREATE INDEX IF NOT EXISTS audit_log_principal_idx ON audit_log (principal_id, id)`,
+	// audit log is append-only - updates, deletes and truncation are rejected
+	`CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+	BEGIN
+		RAISE EXCEPTION 'audit_log is append-only';
+	END
+	$$ LANGUAGE plpgsql`,
+	`DO $$
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'audit_log_append_only') THEN
+			CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_log
+				FOR EACH STATEMENT EXECUTE PROCEDURE audit_log_append_only();
+		END IF;
+	END
+	$$`,
+	`CREATE TABLE IF NOT EXISTS idempotency_keys (
+		key         TEXT PRIMARY KEY,
+		fingerprint TEXT NOT NULL,
+		completed   BOOLEAN NOT NULL DEFAULT false,
+		status      INTEGER NOT NULL DEFAULT 0,
+		header      JSONB,
+		body        BYTEA,
+		created_at  TIMESTAMPTZ NOT NULL,
+		expires_at  TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idempotency_keys_expires_idx ON idempotency_keys (expires_at)`,
+	// responses over the size limit are stored without the body
+	`ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS body_omitted BOOLEAN NOT NULL DEFAULT false`,
+}
+
+// Migrate - creates tables used by the stores.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	for i, m := range migrations {
+		if _, err := db.ExecContext(ctx, m); err != nil {
+			return errors.Wrapf(err, "migration %d failed", i)
+		}
+	}
+
+	return nil
+}
@@ -4,54 +4,190 @@ import (
 	"context"
 	"net/http"
 	"net/http/pprof"
+	"time"
 
 	"github.com/mateuszdyminski/go-template/api"
-	"github.com/mateuszdyminski/go-template/app"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	httpSwagger "github.com/swaggo/http-swagger"
 	"go.uber.org/zap"
 )
 
-func newRouter(ctx context.Context, l *zap.Logger, repo app.Repository) *mux.Router {
+// newRouter returns router of the API, its metrics are registered with reg.
+func newRouter(ctx context.Context, l *zap.Logger, reg prometheus.Registerer, cfg *config, s *services) *mux.Router {
 	r := mux.NewRouter()
 
 	// register Prometheus/Metrics middleware
-	prom := api.NewMetricsMiddleware()
+	prom := api.NewMetricsMiddleware(reg)
 	r.Use(prom.Handler)
 
+	// register route options middleware, the middlewares below read options declared with s.routes.Route
+	r.Use(s.routes.Handler)
+
 	// register request ID middleware
 	r.Use(api.RequestIDMiddleware)
 
-	// register logging middleware
-	httpLogger := api.NewLoggingMiddleware(l)
-	r.Use(httpLogger.Handler)
+	// register client IP middleware, it must run before the middlewares using client address
+	r.Use(s.clientIP.Handler)
+
+	// register context logger middleware, handlers log with api.LoggerFrom(ctx)
+	logger := api.NewContextLogger(l, s.redactor)
+	r.Use(logger.Handler)
+
+	// register access log middleware, it sees principal set by the authentication middlewares
+	r.Use(s.accessLog.Handler)
+
+	// register body capture middleware, it attaches bodies to the access log
+	if s.capture != nil {
+		r.Use(s.capture.Handler)
+	}
 
 	// register version middleware
 	r.Use(api.VersionMiddleware)
 
-	apiHandler := api.NewAPIHandler(ctx, l, repo)
-
-	r.HandleFunc("/api/version", apiHandler.Versionz).Methods(http.MethodGet)
-	r.HandleFunc("/api/health", apiHandler.Healthz).Methods(http.MethodGet)
-	r.HandleFunc("/api/ready", apiHandler.Readyz).Methods(http.MethodGet)
+	// register security headers middleware, routes can override the headers
+	r.Use(s.security.Handler)
+
+	// register Cache-Control middleware, routes declare their cache policy
+	r.Use(s.cache.Handler)
+
+	// register CORS middleware of the API group before authentication, so errors carry CORS headers too
+	if s.cors != nil {
+		r.Use(s.cors.Handler)
+	}
+
+	// register rate limiting middleware before authentication, so requests with invalid credentials
+	// are limited too - their failures are counted against the client IP
+	if s.rateLimiter != nil {
+		r.Use(s.rateLimiter.Handler)
+	}
+
+	// register load shedding middleware before authentication, verifying credentials is a load too,
+	// the limit adapts to latency seen by metrics middleware
+	if s.concurrency != nil {
+		prom.AddObserver(s.concurrency)
+		r.Use(s.concurrency.Handler)
+	}
+
+	// register authentication middleware, anonymous requests pass - routes declare required permissions
+	if s.jwtAuth != nil {
+		r.Use(s.jwtAuth.Handler)
+	}
+	if s.apiKeyAuth != nil {
+		r.Use(s.apiKeyAuth.Handler)
+	}
+
+	// register audit middleware after authentication, so events carry the principal,
+	// and before authorization, so denied requests are recorded too
+	if s.auditor != nil {
+		r.Use(s.auditor.Handler)
+	}
+
+	// register authorization middleware enforcing permissions declared with api.WithPermissions,
+	// it's always registered, so protected routes reject anonymous requests when no authentication is configured
+	r.Use(s.authorizer.Handler)
+
+	// register idempotency middleware, so requests rejected by the others don't take the key
+	if s.idempotency != nil {
+		r.Use(s.idempotency.Handler)
+	}
+
+	// register timeout middleware last, it applies deadlines declared with api.WithTimeout to the handlers
+	r.Use(api.RouteTimeout(l))
+
+	apiHandler := api.NewAPIHandler(ctx, l, s.repo, s.drainer)
+
+	s.routes.Route(r.HandleFunc("/api/version", apiHandler.Versionz).Methods(http.MethodGet),
+		api.WithCachePolicy(api.PublicCache(time.Minute)))
+	s.routes.Route(r.HandleFunc("/api/health", apiHandler.Healthz).Methods(http.MethodGet),
+		api.WithPriority(api.PriorityCritical), api.WithoutRateLimit(), api.WithTimeout(5*time.Second))
+	s.routes.Route(r.HandleFunc("/api/ready", apiHandler.Readyz).Methods(http.MethodGet),
+		api.WithPriority(api.PriorityCritical), api.WithoutRateLimit())
+
+	// versioned routes, requests without version in the path are served by the negotiated version
+	v1 := s.versioning.Subrouter(r, "v1")
+	s.routes.Route(v1.HandleFunc("/whoami", apiHandler.Whoami).Methods(http.MethodGet), api.WithPermissions())
+
+	// unmatched requests get JSON 404 and 405 responses, they skip the router middlewares,
+	// so the fallback handlers are wrapped with the ones which must see every request
+	fallback := api.NewFallback(l, r)
+	fallbackMiddlewares := []mux.MiddlewareFunc{prom.Handler, api.RequestIDMiddleware, s.clientIP.Handler, logger.Handler,
+		s.accessLog.Handler, api.VersionMiddleware, s.security.Handler, s.cache.Handler}
+	if s.cors != nil {
+		fallbackMiddlewares = append(fallbackMiddlewares, s.cors.Handler)
+	}
+	fallback.Register(fallbackMiddlewares...)
+
+	// preflight requests must match a route to pass through the middlewares,
+	// other OPTIONS requests are answered with methods allowed on the path
+	if s.cors != nil {
+		r.PathPrefix("/api/").Methods(http.MethodOptions).HandlerFunc(fallback.Options)
+	}
 
 	// Swagger configuration
-	r.PathPrefix("/swagger/").Handler(httpSwagger.Handler(
+	// Swagger UI uses inline scripts and styles - they are allowed with CSP nonce
+	swagger := r.PathPrefix("/swagger/").Handler(api.NonceHTML(httpSwagger.Handler(
 		httpSwagger.URL("/swagger/doc.json"),
-	))
+	)))
+	s.routes.Route(swagger, api.WithSecurityHeaders(func(c *api.SecurityHeadersConfig) {
+		c.ContentSecurityPolicy = cfg.swaggerCSP
+	}))
 	r.HandleFunc("/swagger.json", api.SwaggerHandler(l.Sugar()))
 
 	// Prometheus configuration
-	r.Handle("/metrics", promhttp.Handler())
+	s.routes.Route(r.Handle("/metrics", promhttp.Handler()), api.WithPriority(api.PriorityCritical), api.WithoutRateLimit())
 
 	return r
 }
 
-func newPprofRouter() *mux.Router {
+func newAdminRouter(l *zap.Logger, s *services, apiRouter *mux.Router) *mux.Router {
 	r := mux.NewRouter()
 
+	// register route options, request ID and context logger middlewares
+	logger := api.NewContextLogger(l, s.redactor)
+	r.Use(s.routes.Handler)
+	r.Use(api.RequestIDMiddleware)
+	r.Use(logger.Handler)
+
+	// unmatched requests get JSON 404 and 405 responses with request ID
+	api.NewFallback(l, r).Register(api.RequestIDMiddleware, logger.Handler)
+
+	// register admin token authentication of /admin/ endpoints
+	r.Use(s.adminAuth.Handler)
+
+	// register audit middleware after authentication, admin changes are recorded with the principal
+	if s.auditor != nil {
+		r.Use(s.auditor.Handler)
+	}
+
+	// drain endpoint for Kubernetes preStop hook, changes state so it requires the admin token
+	r.HandleFunc("/admin/drain", s.drainer.DrainHandler).Methods(http.MethodPost)
+
+	// API keys management
+	if s.apiKeyAuth != nil {
+		keys := api.NewAPIKeyHandler(l, s.apiKeys, s.apiKeyAuth)
+		r.HandleFunc("/admin/apikeys", keys.Create).Methods(http.MethodPost)
+		r.HandleFunc("/admin/apikeys", keys.List).Methods(http.MethodGet)
+		r.HandleFunc("/admin/apikeys/{prefix}/rotate", keys.Rotate).Methods(http.MethodPost)
+		r.HandleFunc("/admin/apikeys/{prefix}", keys.Revoke).Methods(http.MethodDelete)
+	}
+
+	// explains authorization decisions of the API router
+	s.routes.Route(r.HandleFunc("/admin/authz/explain", s.authorizer.ExplainHandler(apiRouter, s.routes)).Methods(http.MethodPost),
+		api.WithoutAudit())
+
+	// bodies captured by the API router
+	if s.capture != nil {
+		r.HandleFunc("/admin/captures", s.capture.List).Methods(http.MethodGet)
+	}
+
+	// audit events of mutating requests
+	if s.auditor != nil {
+		r.HandleFunc("/admin/audit", api.NewAuditHandler(l, s.auditStore, s.pageSecret).List).Methods(http.MethodGet)
+	}
+
 	// pprof endpoints configuration
 	r.HandleFunc("/debug/pprof/", pprof.Index)
 	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mateuszdyminski/go-template/api"
+	"github.com/mateuszdyminski/go-template/app"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type recordingKeyStore struct {
+	app.APIKeyStore
+	created []app.APIKey
+}
+
+func (s *recordingKeyStore) Create(ctx context.Context, key app.APIKey) error {
+	s.created = append(s.created, key)
+	return nil
+}
+
+func newTestAdminRouter(token string, store app.APIKeyStore) *mux.Router {
+	l := zap.NewNop()
+	reg := prometheus.NewRegistry()
+	authMetrics := api.NewAuthMetrics(reg)
+	s := &services{
+		routes:     api.NewRoutes(),
+		drainer:    api.NewDrainer(l, reg, 0, time.Second),
+		apiKeys:    store,
+		apiKeyAuth: api.NewAPIKeyAuthenticator(l, store, time.Minute, authMetrics),
+		adminAuth:  api.NewAdminAuthenticator(l, "/admin/", token, authMetrics),
+		authorizer: api.NewAuthorizer(l, reg, api.StaticRoles{}, 0),
+	}
+	return newAdminRouter(l, s, mux.NewRouter())
+}
+
+func createKeyRequest(authorization string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/admin/apikeys", strings.NewReader(`{"owner":"ci"}`))
+	r.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	return r
+}
+
+func Test_newAdminRouter_ShouldRejectUnauthenticatedKeyCreation(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+	}{
+		{name: "without token", token: "s3cr3t"},
+		{name: "wrong token", token: "s3cr3t", authorization: "Bearer guess"},
+		{name: "wrong scheme", token: "s3cr3t", authorization: "Basic s3cr3t"},
+		{name: "token not configured", authorization: "Bearer "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			store := &recordingKeyStore{}
+			r := newTestAdminRouter(tt.token, store)
+			w := httptest.NewRecorder()
+
+			// when
+			r.ServeHTTP(w, createKeyRequest(tt.authorization))
+
+			// then
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.Empty(t, store.created)
+		})
+	}
+}
+
+func Test_newAdminRouter_ShouldCreateKeyWithAdminToken(t *testing.T) {
+	// given
+	store := &recordingKeyStore{}
+	r := newTestAdminRouter("s3cr3t", store)
+	w := httptest.NewRecorder()
+
+	// when
+	r.ServeHTTP(w, createKeyRequest("Bearer s3cr3t"))
+
+	// then
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Len(t, store.created, 1)
+}
+
+func Test_newAdminRouter_ShouldRequireAdminTokenToDrain(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{name: "without token", status: http.StatusUnauthorized},
+		{name: "wrong token", authorization: "Bearer guess", status: http.StatusUnauthorized},
+		{name: "admin token", authorization: "Bearer s3cr3t", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			r := newTestAdminRouter("s3cr3t", &recordingKeyStore{})
+			req := httptest.NewRequest(http.MethodPost, "/admin/drain", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+
+			// when
+			r.ServeHTTP(w, req)
+
+			// then
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
+
+// newTestRouter returns API router with the services required by newRouter and the CORS middleware.
+func newTestRouter(t *testing.T) *mux.Router {
+	l := zap.NewNop()
+	reg := prometheus.NewRegistry()
+	accessLog, err := api.NewAccessLogger(l, api.AccessLogConfig{Format: api.AccessLogJSON})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s := &services{
+		routes:     api.NewRoutes(),
+		clientIP:   api.NewClientIPMiddleware(nil),
+		accessLog:  accessLog,
+		security:   api.NewSecurityHeaders(l, api.SecurityHeadersConfig{}),
+		cache:      api.NewCacheControl(api.Revalidate()),
+		cors:       api.NewCORS(l, "/api/", api.CORSConfig{AllowedOrigins: []string{"https://app.example.com"}}),
+		versioning: api.NewVersioning(l, reg, "/api/", api.VersionSpec{Name: "v1"}),
+		drainer:    api.NewDrainer(l, reg, 0, time.Second),
+		authorizer: api.NewAuthorizer(l, reg, api.StaticRoles{}, 0),
+	}
+	return newRouter(ctx, l, reg, &config{}, s)
+}
+
+func Test_newRouter_ShouldRejectAnonymousRequestsToProtectedRoutesWithoutAuthentication(t *testing.T) {
+	// given
+	r := newTestRouter(t)
+	w := httptest.NewRecorder()
+
+	// when
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/whoami", nil))
+
+	// then
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func Test_newRouter_ShouldAddCORSAndCacheHeadersToFallbackResponses(t *testing.T) {
+	// given
+	r := newTestRouter(t)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{name: "not found", method: http.MethodGet, path: "/api/v1/missing", status: http.StatusNotFound},
+		{name: "method not allowed", method: http.MethodDelete, path: "/api/version", status: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Origin", "https://app.example.com")
+			w := httptest.NewRecorder()
+
+			// when
+			r.ServeHTTP(w, req)
+
+			// then
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	rdebug "runtime/debug"
+
+	"github.com/mateuszdyminski/go-template/cgroup"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// runtimeMetrics - effective runtime settings and container limits they were derived from.
+type runtimeMetrics struct {
+	maxProcs    prometheus.Gauge
+	memLimit    prometheus.Gauge
+	cpuQuota    prometheus.Gauge
+	memoryLimit prometheus.Gauge
+}
+
+func newRuntimeMetrics(reg prometheus.Registerer) *runtimeMetrics {
+	m := &runtimeMetrics{
+		maxProcs: prometheus.NewGauge(prometheus.GaugeOpts{
+			Subsystem: "runtime",
+			Name:      "gomaxprocs",
+			Help:      "Effective GOMAXPROCS value.",
+		}),
+		memLimit: prometheus.NewGauge(prometheus.GaugeOpts{
+			Subsystem: "runtime",
+			Name:      "gomemlimit_bytes",
+			Help:      "Effective GOMEMLIMIT value in bytes, math.MaxInt64 when unlimited.",
+		}),
+		cpuQuota: prometheus.NewGauge(prometheus.GaugeOpts{
+			Subsystem: "runtime",
+			Name:      "cgroup_cpu_quota",
+			Help:      "Number of CPUs available according to cgroup quota, 0 when unlimited.",
+		}),
+		memoryLimit: prometheus.NewGauge(prometheus.GaugeOpts{
+			Subsystem: "runtime",
+			Name:      "cgroup_memory_limit_bytes",
+			Help:      "Memory limit in bytes according to cgroup, 0 when unlimited.",
+		}),
+	}
+	reg.MustRegister(m.maxProcs, m.memLimit, m.cpuQuota, m.memoryLimit)
+
+	return m
+}
+
+// tuneRuntime adapts GOMAXPROCS and GOMEMLIMIT to the container limits read from root.
+// Values from config take precedence, then GOMAXPROCS/GOMEMLIMIT env variables, then cgroup limits.
+func tuneRuntime(l *zap.SugaredLogger, cfg *config, root string, m *runtimeMetrics) {
+	limits, err := cgroup.NewReader(root).Read()
+	if err != nil {
+		l.Warnw("can't read cgroup limits, runtime settings left untouched", "err", err)
+	}
+
+	procs, procsSource := runtime.GOMAXPROCS(0), "default"
+	switch {
+	case cfg.runtimeMaxProcs > 0:
+		procs, procsSource = cfg.runtimeMaxProcs, "config"
+	case os.Getenv("GOMAXPROCS") != "":
+		procsSource = "env"
+	case limits.CPUQuota > 0:
+		procs, procsSource = limits.MaxProcs(procs), "cgroup"
+	}
+	runtime.GOMAXPROCS(procs)
+
+	memLimit, memSource := rdebug.SetMemoryLimit(-1), "default"
+	switch {
+	case cfg.runtimeMemLimit > 0:
+		memLimit, memSource = cfg.runtimeMemLimit, "config"
+	case os.Getenv("GOMEMLIMIT") != "":
+		memSource = "env"
+	case limits.MemoryLimit > 0:
+		memLimit, memSource = limits.MemLimit(cfg.runtimeMemLimitRatio), "cgroup"
+	}
+	rdebug.SetMemoryLimit(memLimit)
+
+	l.Infow("runtime tuned",
+		"cgroup_version", limits.Version,
+		"cgroup_cpu_quota", limits.CPUQuota,
+		"cgroup_memory_limit", limits.MemoryLimit,
+		"gomaxprocs", procs,
+		"gomaxprocs_source", procsSource,
+		"gomemlimit", memLimit,
+		"gomemlimit_source", memSource,
+	)
+
+	m.maxProcs.Set(float64(procs))
+	m.memLimit.Set(float64(memLimit))
+	m.cpuQuota.Set(limits.CPUQuota)
+	m.memoryLimit.Set(float64(limits.MemoryLimit))
+}
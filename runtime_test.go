@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	rdebug "runtime/debug"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeCgroupV2 creates fake root filesystem with cgroup v2 limits.
+func fakeCgroupV2(t *testing.T, cpuMax, memoryMax string) string {
+	root := t.TempDir()
+	files := map[string]string{
+		"proc/self/cgroup":                 "0::/\n",
+		"sys/fs/cgroup/cgroup.controllers": "cpu memory\n",
+		"sys/fs/cgroup/cpu.max":            cpuMax,
+		"sys/fs/cgroup/memory.max":         memoryMax,
+	}
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	return root
+}
+
+// restoreRuntime restores GOMAXPROCS and GOMEMLIMIT changed by the test.
+func restoreRuntime(t *testing.T) {
+	procs, memLimit := runtime.GOMAXPROCS(0), rdebug.SetMemoryLimit(-1)
+	t.Cleanup(func() {
+		runtime.GOMAXPROCS(procs)
+		rdebug.SetMemoryLimit(memLimit)
+	})
+	t.Setenv("GOMAXPROCS", "")
+	t.Setenv("GOMEMLIMIT", "")
+}
+
+func Test_tuneRuntime_ShouldApplyCgroupLimits(t *testing.T) {
+	// given
+	restoreRuntime(t)
+	root := fakeCgroupV2(t, "250000 100000\n", "1073741824\n")
+	m := newRuntimeMetrics(prometheus.NewRegistry())
+
+	// when
+	tuneRuntime(zap.NewNop().Sugar(), &config{runtimeMemLimitRatio: 0.5}, root, m)
+
+	// then
+	assert.Equal(t, 2, runtime.GOMAXPROCS(0))
+	assert.Equal(t, int64(536870912), rdebug.SetMemoryLimit(-1))
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.maxProcs))
+	assert.Equal(t, float64(536870912), testutil.ToFloat64(m.memLimit))
+	assert.Equal(t, 2.5, testutil.ToFloat64(m.cpuQuota))
+	assert.Equal(t, float64(1073741824), testutil.ToFloat64(m.memoryLimit))
+}
+
+func Test_tuneRuntime_ShouldPreferConfig(t *testing.T) {
+	// given
+	restoreRuntime(t)
+	root := fakeCgroupV2(t, "250000 100000\n", "1073741824\n")
+	m := newRuntimeMetrics(prometheus.NewRegistry())
+	cfg := &config{runtimeMaxProcs: 3, runtimeMemLimit: 1 << 20, runtimeMemLimitRatio: 0.5}
+
+	// when
+	tuneRuntime(zap.NewNop().Sugar(), cfg, root, m)
+
+	// then
+	assert.Equal(t, 3, runtime.GOMAXPROCS(0))
+	assert.Equal(t, int64(1<<20), rdebug.SetMemoryLimit(-1))
+	assert.Equal(t, float64(3), testutil.ToFloat64(m.maxProcs))
+}
+
+func Test_tuneRuntime_ShouldKeepDefaultsWithoutCgroup(t *testing.T) {
+	// given
+	restoreRuntime(t)
+	procs, memLimit := runtime.GOMAXPROCS(0), rdebug.SetMemoryLimit(-1)
+	m := newRuntimeMetrics(prometheus.NewRegistry())
+
+	// when
+	tuneRuntime(zap.NewNop().Sugar(), &config{runtimeMemLimitRatio: 0.5}, t.TempDir(), m)
+
+	// then
+	assert.Equal(t, procs, runtime.GOMAXPROCS(0))
+	assert.Equal(t, memLimit, rdebug.SetMemoryLimit(-1))
+	assert.Equal(t, float64(0), testutil.ToFloat64(m.cpuQuota))
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"time"
+
+	"github.com/mateuszdyminski/go-template/api"
+	"github.com/mateuszdyminski/go-template/app"
+	"github.com/mateuszdyminski/go-template/audit"
+	"github.com/mateuszdyminski/go-template/auth"
+	"github.com/mateuszdyminski/go-template/redact"
+	"github.com/mateuszdyminski/go-template/repository/breaker"
+	"github.com/mateuszdyminski/go-template/repository/postgres"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// services - dependencies of the handlers and middlewares registered on the routers.
+type services struct {
+	repo        app.Repository
+	routes      *api.Routes
+	proxies     api.TrustedProxies
+	clientIP    *api.ClientIPMiddleware
+	accessLog   *api.AccessLogger
+	redactor    *redact.Redactor
+	capture     *api.BodyCapture // nil when body capture is disabled
+	auditStore  app.AuditStore
+	audit       *audit.Writer    // nil when audit is disabled
+	auditor     *api.Auditor     // nil when audit is disabled
+	pageSecret  []byte           // HMAC key of the list cursors
+	idempotency *api.Idempotency // nil when idempotency keys are disabled
+	drainer     *api.Drainer
+	rateLimiter *api.RateLimiter         // nil when rate limiting is disabled
+	concurrency *api.ConcurrencyLimiter  // nil when load shedding is disabled
+	jwtAuth     *api.JWTAuthenticator    // nil when JWT authentication is disabled
+	apiKeyAuth  *api.APIKeyAuthenticator // nil when API key authentication is disabled
+	apiKeys     app.APIKeyStore
+	adminAuth   *api.AdminAuthenticator
+	authorizer  *api.Authorizer
+	cors        *api.CORS // nil when no origins are allowed
+	security    *api.SecurityHeaders
+	cache       *api.CacheControl
+	versioning  *api.Versioning
+}
+
+// newServices creates the services, their metrics are registered with reg.
+func newServices(l *zap.Logger, reg prometheus.Registerer, cfg *config, db *sql.DB) (*services, error) {
+	s := &services{
+		repo:   postgres.NewPostgresRepository(db),
+		routes: api.NewRoutes(),
+		// drainer wraps the whole router to see every request, including unmatched ones,
+		// probes and metrics scrapes don't keep it from draining
+		drainer: api.NewDrainer(l, reg,
+			time.Duration(cfg.httpDrainQuietPeriod)*time.Second,
+			time.Duration(cfg.httpDrainTimeout)*time.Second,
+			"/api/health", "/api/ready", "/metrics",
+		),
+	}
+
+	// cursors signed with a random key are valid only on this instance
+	s.pageSecret = []byte(cfg.paginationSecret)
+	if len(s.pageSecret) == 0 {
+		s.pageSecret = make([]byte, 32)
+		if _, err := rand.Read(s.pageSecret); err != nil {
+			return nil, errors.Wrap(err, "can't generate pagination secret")
+		}
+		l.Sugar().Warnw("pagination_secret isn't set, list cursors are valid only on this instance")
+	}
+
+	proxies, err := api.ParseTrustedProxies(cfg.httpTrustedProxies)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid http_trusted_proxies")
+	}
+	s.proxies = proxies
+	s.clientIP = api.NewClientIPMiddleware(proxies)
+
+	s.redactor = cfg.redactor()
+	s.accessLog, err = api.NewAccessLogger(l, api.AccessLogConfig{
+		Format:        cfg.accessLogFormat,
+		SampleRate:    cfg.accessLogSampleRate,
+		SlowThreshold: time.Duration(cfg.accessLogSlowMs) * time.Millisecond,
+		Output:        cfg.accessLogOutput,
+		Redactor:      s.redactor,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid access log config")
+	}
+
+	if cfg.captureEnabled() {
+		s.capture = api.NewBodyCapture(l, reg, api.CaptureConfig{
+			MaxBytes:   cfg.captureMaxBytes,
+			BufferSize: cfg.captureBufferSize,
+			Secret:     []byte(cfg.captureSecret),
+			Routes:     cfg.captureRoutes,
+			AccessLog:  cfg.captureAccessLog,
+		}, s.redactor)
+	}
+
+	if cfg.auditEnabled {
+		s.auditStore = postgres.NewAuditStore(db)
+		s.audit = audit.NewWriter(l, s.auditStore, audit.Config{
+			BufferSize:    cfg.auditBufferSize,
+			BatchSize:     cfg.auditBatchSize,
+			FlushInterval: time.Duration(cfg.auditFlushIntervalMs) * time.Millisecond,
+			FallbackFile:  cfg.auditFallbackFile,
+			StoreTimeout:  5 * time.Second,
+		}, audit.NewMetrics(reg))
+		s.auditor = api.NewAuditor(l, s.audit)
+	}
+
+	if cfg.idempotencyEnabled {
+		s.idempotency = api.NewIdempotency(l, reg, api.IdempotencyConfig{
+			TTL:          time.Duration(cfg.idempotencyTTL) * time.Second,
+			LockTimeout:  time.Duration(cfg.idempotencyLock) * time.Second,
+			MaxBodyBytes: cfg.idempotencyMaxBytes,
+		}, postgres.NewIdempotencyStore(db))
+	}
+
+	// fail fast while the DB is failing, 0 threshold disables the breaker
+	if cfg.breakerFailures > 0 {
+		s.repo = breaker.NewRepository(l, reg, "postgres", s.repo, breaker.Config{
+			FailureThreshold: cfg.breakerFailures,
+			OpenTimeout:      time.Duration(cfg.breakerOpenTimeout) * time.Second,
+			HalfOpenMaxCalls: cfg.breakerHalfOpenCalls,
+			SuccessThreshold: cfg.breakerSuccesses,
+		})
+	}
+
+	if cfg.rateLimitRequests > 0 {
+		rl, err := newRateLimiter(l, reg, cfg, db)
+		if err != nil {
+			return nil, err
+		}
+		s.rateLimiter = rl
+	}
+
+	if cfg.concurrencyInitial > 0 {
+		s.concurrency = api.NewConcurrencyLimiter(l, reg, api.ConcurrencyConfig{
+			InitialLimit:  cfg.concurrencyInitial,
+			MinLimit:      cfg.concurrencyMin,
+			MaxLimit:      cfg.concurrencyMax,
+			TargetLatency: time.Duration(cfg.concurrencyLatency) * time.Millisecond,
+			Backoff:       cfg.concurrencyBackoff,
+		})
+	}
+
+	s.security = api.NewSecurityHeaders(l, api.SecurityHeadersConfig{
+		HSTSMaxAge:            time.Duration(cfg.hstsMaxAge) * time.Second,
+		HSTSIncludeSubdomains: cfg.hstsIncludeSubdomains,
+		HSTSPreload:           cfg.hstsPreload,
+		FrameOptions:          cfg.frameOptions,
+		ReferrerPolicy:        cfg.referrerPolicy,
+		PermissionsPolicy:     cfg.permissionsPolicy,
+		ContentSecurityPolicy: cfg.csp,
+	})
+
+	// versions served under /api/{version}, deprecate a version by setting its Deprecation
+	s.versioning = api.NewVersioning(l, reg, "/api/",
+		api.VersionSpec{Name: "v1"},
+	)
+
+	// API responses aren't cached unless the route declares its policy
+	s.cache = api.NewCacheControl(api.NoStore())
+
+	if len(cfg.corsAllowedOrigins) > 0 {
+		s.cors = api.NewCORS(l, "/api/", api.CORSConfig{
+			AllowedOrigins:   cfg.corsAllowedOrigins,
+			AllowedMethods:   cfg.corsAllowedMethods,
+			AllowedHeaders:   cfg.corsAllowedHeaders,
+			ExposedHeaders:   cfg.corsExposedHeaders,
+			AllowCredentials: cfg.corsAllowCredentials,
+			MaxAge:           time.Duration(cfg.corsMaxAge) * time.Second,
+		})
+	}
+
+	authMetrics := api.NewAuthMetrics(reg)
+
+	// admin endpoints are disabled until the token is configured
+	s.adminAuth = api.NewAdminAuthenticator(l, "/admin/", cfg.httpAdminToken, authMetrics)
+	if cfg.httpAdminToken == "" {
+		l.Sugar().Warnw("http_admin_token isn't set, /admin/ endpoints reject all requests")
+	}
+
+	if source := cfg.jwtKeySource(); source != "" {
+		keys := auth.NewJWKS(source, time.Duration(cfg.jwtJWKSRefresh)*time.Second)
+		verifier := auth.NewVerifier(keys, cfg.jwtIssuer, cfg.jwtAudience, time.Duration(cfg.jwtClockSkew)*time.Second)
+		s.jwtAuth = api.NewJWTAuthenticator(l, verifier, authMetrics)
+	}
+
+	if cfg.apiKeyEnabled {
+		s.apiKeys = postgres.NewAPIKeyStore(db)
+		s.apiKeyAuth = api.NewAPIKeyAuthenticator(l, s.apiKeys, time.Duration(cfg.apiKeyCacheTTL)*time.Second, authMetrics)
+	}
+
+	// the authorizer is created even without authentication, protected routes must reject anonymous requests
+	authorizer, err := newAuthorizer(l, reg, cfg, db)
+	if err != nil {
+		return nil, err
+	}
+	s.authorizer = authorizer
+
+	return s, nil
+}
+
+// purge deletes expired idempotency keys and rate limits in the background until ctx is done.
+func (s *services) purge(ctx context.Context) {
+	if s.idempotency != nil {
+		go s.idempotency.Purge(ctx, 10*time.Minute)
+	}
+	if s.rateLimiter != nil {
+		go s.rateLimiter.Purge(ctx, 10*time.Minute)
+	}
+}
+
+func newRateLimiter(l *zap.Logger, reg prometheus.Registerer, cfg *config, db *sql.DB) (*api.RateLimiter, error) {
+	var store app.RateLimitStore
+	switch cfg.rateLimitStore {
+	case "memory":
+		store = api.NewMemoryRateLimitStore()
+	case "postgres":
+		store = postgres.NewRateLimitStore(db)
+	default:
+		return nil, errors.Errorf("unknown rate limit store: %q", cfg.rateLimitStore)
+	}
+
+	var keyFunc api.KeyFunc
+	switch cfg.rateLimitKey {
+	case "ip":
+		keyFunc = api.KeyByIP
+	case "apikey":
+		keyFunc = api.KeyByAPIKey
+	case "route":
+		keyFunc = api.KeyByRoute
+	default:
+		return nil, errors.Errorf("unknown rate limit key: %q", cfg.rateLimitKey)
+	}
+
+	limit := app.RateLimit{
+		Requests: cfg.rateLimitRequests,
+		Window:   time.Duration(cfg.rateLimitWindow) * time.Second,
+	}
+
+	return api.NewRateLimiter(l, "api", cfg.rateLimitKey, keyFunc, limit, store, api.NewRateLimitMetrics(reg)), nil
+}
+
+func newAuthorizer(l *zap.Logger, reg prometheus.Registerer, cfg *config, db *sql.DB) (*api.Authorizer, error) {
+	switch cfg.authzStore {
+	case "config":
+		roles, err := api.ParseRoles(cfg.authzRoles)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid authz_roles")
+		}
+		return api.NewAuthorizer(l, reg, roles, 0), nil
+	case "postgres":
+		return api.NewAuthorizer(l, reg, postgres.NewRoleStore(db), time.Duration(cfg.authzRefresh)*time.Second), nil
+	default:
+		return nil, errors.Errorf("unknown authz store: %q", cfg.authzStore)
+	}
+}
+
+// migrate creates DB tables, retrying until the DB is reachable, so the app can start before the DB.
+func migrate(ctx context.Context, l *zap.SugaredLogger, db *sql.DB) {
+	for {
+		err := postgres.Migrate(ctx, db)
+		if err == nil {
+			l.Infow("DB migrations applied")
+			return
+		}
+		l.Warnw("DB migrations failed, retrying", "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
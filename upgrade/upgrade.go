@@ -0,0 +1,359 @@
+// Package upgrade implements zero-downtime binary upgrades by handing listening sockets
+// over to a freshly started process. It understands systemd socket activation (LISTEN_FDS)
+// as well, as the upgrade reuses the same protocol to pass the sockets.
+package upgrade
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	envListenFDs     = "LISTEN_FDS"
+	envListenPID     = "LISTEN_PID"
+	envListenFDNames = "LISTEN_FDNAMES"
+	envReadyFD       = "APP_UPGRADE_READY_FD"
+
+	// first file descriptor passed by systemd and exec.Cmd.ExtraFiles
+	listenFDsStart = 3
+)
+
+// Upgrader keeps track of the listening sockets, so they can be passed to the new process.
+type Upgrader struct {
+	l            *zap.SugaredLogger
+	readyTimeout time.Duration
+
+	mu        sync.Mutex
+	inherited []inheritedListener
+	names     []string
+	listeners map[string]net.Listener
+	upgrading bool
+	upgraded  bool
+
+	readyFD int
+}
+
+type inheritedListener struct {
+	name string
+	ln   net.Listener
+}
+
+// New returns Upgrader with listeners inherited from the parent process or systemd.
+// readyTimeout bounds the time the new process has to report it's ready.
+func New(l *zap.Logger, readyTimeout time.Duration) (*Upgrader, error) {
+	u := &Upgrader{
+		l:            l.Sugar(),
+		readyTimeout: readyTimeout,
+		listeners:    make(map[string]net.Listener),
+	}
+
+	inherited, err := inheritListeners()
+	if err != nil {
+		return nil, err
+	}
+	u.inherited = inherited
+
+	if fd := os.Getenv(envReadyFD); fd != "" {
+		if u.readyFD, err = strconv.Atoi(fd); err != nil {
+			return nil, errors.Wrapf(err, "invalid %s value", envReadyFD)
+		}
+	}
+
+	// don't leak the variables to processes started by the app
+	for _, env := range []string{envListenFDs, envListenPID, envListenFDNames, envReadyFD} {
+		os.Unsetenv(env)
+	}
+
+	return u, nil
+}
+
+// Listen returns listener inherited from the parent process if there is one matching the name
+// or the address. Otherwise it opens a new TCP listener.
+func (u *Upgrader) Listen(name, addr string) (net.Listener, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if _, ok := u.listeners[name]; ok {
+		return nil, errors.Errorf("listener %q already exists", name)
+	}
+
+	ln := u.takeInherited(name, addr)
+	if ln != nil {
+		u.l.Infow("using inherited listener", "name", name, "addr", ln.Addr().String())
+	} else {
+		var err error
+		if ln, err = net.Listen("tcp", addr); err != nil {
+			return nil, errors.Wrapf(err, "can't listen on %s", addr)
+		}
+	}
+
+	u.names = append(u.names, name)
+	u.listeners[name] = ln
+
+	return ln, nil
+}
+
+// Ready notifies the parent process that this process is serving traffic, so the parent
+// can shut down. Inherited listeners which were not claimed by Listen are closed.
+func (u *Upgrader) Ready() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, il := range u.inherited {
+		u.l.Warnw("closing unused inherited listener", "name", il.name, "addr", il.ln.Addr().String())
+		il.ln.Close()
+	}
+	u.inherited = nil
+
+	if u.readyFD == 0 {
+		return nil
+	}
+
+	f := os.NewFile(uintptr(u.readyFD), "ready")
+	u.readyFD = 0
+	defer f.Close()
+
+	if _, err := f.Write([]byte{1}); err != nil {
+		return errors.Wrap(err, "can't notify parent process")
+	}
+
+	return nil
+}
+
+// Upgrade starts a new instance of the binary with all listeners passed to it and waits
+// until it reports it's ready. When it returns nil the caller should gracefully shut down.
+func (u *Upgrader) Upgrade() error {
+	u.mu.Lock()
+	if u.upgrading || u.upgraded {
+		u.mu.Unlock()
+		return errors.New("upgrade already in progress")
+	}
+	u.upgrading = true
+	files, names, err := u.files()
+	u.mu.Unlock()
+
+	defer func() {
+		u.mu.Lock()
+		u.upgrading = false
+		u.mu.Unlock()
+	}()
+
+	if err != nil {
+		return err
+	}
+	defer closeFiles(files)
+
+	if err := u.spawn(files, names); err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	u.upgraded = true
+	u.mu.Unlock()
+
+	return nil
+}
+
+// Upgraded returns true when the new process took over the listeners.
+func (u *Upgrader) Upgraded() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.upgraded
+}
+
+func (u *Upgrader) spawn(files []*os.File, names []string) error {
+	bin, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "can't find executable")
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return errors.Wrap(err, "can't create ready pipe")
+	}
+	defer readyR.Close()
+
+	cmd := exec.Command(bin, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(environ(),
+		envListenFDs+"="+strconv.Itoa(len(files)),
+		envListenFDNames+"="+strings.Join(names, ":"),
+		envReadyFD+"="+strconv.Itoa(listenFDsStart+len(files)),
+	)
+
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return errors.Wrap(err, "can't start new process")
+	}
+	u.l.Infow("new process started", "pid", cmd.Process.Pid, "bin", bin)
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	ready := make(chan error, 1)
+	go func() {
+		b := make([]byte, 1)
+		_, err := readyR.Read(b)
+		ready <- err
+	}()
+
+	select {
+	case err := <-ready:
+		if err == nil {
+			u.l.Infow("new process is ready", "pid", cmd.Process.Pid)
+			return nil
+		}
+		// pipe closed without notification - new process is going down
+		cmd.Process.Kill()
+		return errors.Wrap(err, "new process failed before it was ready")
+	case err := <-exited:
+		return errors.Errorf("new process exited before it was ready: %v", err)
+	case <-time.After(u.readyTimeout):
+		cmd.Process.Kill()
+		return errors.Errorf("new process not ready after %s", u.readyTimeout)
+	}
+}
+
+// files returns duplicated file descriptors of all listeners in the order they were created.
+func (u *Upgrader) files() ([]*os.File, []string, error) {
+	files := make([]*os.File, 0, len(u.names))
+	for _, name := range u.names {
+		ln, ok := u.listeners[name].(interface{ File() (*os.File, error) })
+		if !ok {
+			closeFiles(files)
+			return nil, nil, errors.Errorf("listener %q can't be passed to another process", name)
+		}
+		f, err := ln.File()
+		if err != nil {
+			closeFiles(files)
+			return nil, nil, errors.Wrapf(err, "can't get file of listener %q", name)
+		}
+		files = append(files, f)
+	}
+
+	return files, u.names, nil
+}
+
+// takeInherited removes and returns inherited listener with the name.
+// Unnamed listeners (e.g. from systemd without FileDescriptorName) are matched by the address.
+func (u *Upgrader) takeInherited(name, addr string) net.Listener {
+	for i, il := range u.inherited {
+		if il.name == name || (il.name == "" && sameAddr(il.ln.Addr(), addr)) {
+			u.inherited = append(u.inherited[:i], u.inherited[i+1:]...)
+			return il.ln
+		}
+	}
+
+	return nil
+}
+
+// inheritListeners returns listeners passed with LISTEN_FDS by the parent process or systemd.
+func inheritListeners() ([]inheritedListener, error) {
+	n, names, err := listenFDs(os.Getenv, os.Getpid())
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]*os.File, 0, n)
+	for i := 0; i < n; i++ {
+		files = append(files, os.NewFile(uintptr(listenFDsStart+i), "listener"))
+	}
+	// FileListener duplicates the descriptors
+	defer closeFiles(files)
+
+	return fileListeners(files, names)
+}
+
+// listenFDs parses LISTEN_FDS, LISTEN_PID and LISTEN_FDNAMES according to the sd_listen_fds(3)
+// protocol. It returns number of the passed descriptors and their names, empty when unnamed.
+func listenFDs(getenv func(string) string, pid int) (int, []string, error) {
+	count := getenv(envListenFDs)
+	if count == "" {
+		return 0, nil, nil
+	}
+
+	// LISTEN_PID is set by systemd only - upgrades can't know the pid of the child in advance
+	if p := getenv(envListenPID); p != "" && p != strconv.Itoa(pid) {
+		return 0, nil, nil
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return 0, nil, errors.Errorf("invalid %s value: %q", envListenFDs, count)
+	}
+
+	names := make([]string, n)
+	if v := getenv(envListenFDNames); v != "" {
+		for i, name := range strings.Split(v, ":") {
+			// systemd uses "unknown" when FileDescriptorName is not set
+			if i < n && name != "unknown" {
+				names[i] = name
+			}
+		}
+	}
+
+	return n, names, nil
+}
+
+// fileListeners returns listeners of the files named by names.
+func fileListeners(files []*os.File, names []string) ([]inheritedListener, error) {
+	listeners := make([]inheritedListener, 0, len(files))
+	for i, f := range files {
+		ln, err := net.FileListener(f)
+		if err != nil {
+			for _, il := range listeners {
+				il.ln.Close()
+			}
+			return nil, errors.Wrapf(err, "can't use inherited file descriptor %d", f.Fd())
+		}
+		listeners = append(listeners, inheritedListener{name: names[i], ln: ln})
+	}
+
+	return listeners, nil
+}
+
+// sameAddr compares listener address with the address passed to Listen, e.g. "[::]:8080" and ":8080".
+func sameAddr(a net.Addr, addr string) bool {
+	tcp, ok := a.(*net.TCPAddr)
+	if !ok {
+		return a.String() == addr
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || port != strconv.Itoa(tcp.Port) {
+		return false
+	}
+
+	return host == "" || net.ParseIP(host).Equal(tcp.IP)
+}
+
+// environ returns environment of the current process without socket passing variables.
+func environ() []string {
+	env := []string{}
+	for _, kv := range os.Environ() {
+		switch strings.SplitN(kv, "=", 2)[0] {
+		case envListenFDs, envListenPID, envListenFDNames, envReadyFD:
+			continue
+		}
+		env = append(env, kv)
+	}
+
+	return env
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
@@ -0,0 +1,155 @@
+package upgrade
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func Test_listenFDs_ShouldParseEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		n     int
+		names []string
+		err   bool
+	}{
+		{name: "no sockets", env: map[string]string{}},
+		{name: "named sockets", env: map[string]string{"LISTEN_FDS": "2", "LISTEN_FDNAMES": "http:admin"},
+			n: 2, names: []string{"http", "admin"}},
+		{name: "systemd unnamed sockets", env: map[string]string{"LISTEN_FDS": "2", "LISTEN_PID": "42", "LISTEN_FDNAMES": "unknown:admin"},
+			n: 2, names: []string{"", "admin"}},
+		{name: "without names", env: map[string]string{"LISTEN_FDS": "1"}, n: 1, names: []string{""}},
+		{name: "more names than sockets", env: map[string]string{"LISTEN_FDS": "1", "LISTEN_FDNAMES": "http:admin"},
+			n: 1, names: []string{"http"}},
+		{name: "sockets of other process", env: map[string]string{"LISTEN_FDS": "2", "LISTEN_PID": "7"}},
+		{name: "invalid count", env: map[string]string{"LISTEN_FDS": "two"}, err: true},
+		{name: "negative count", env: map[string]string{"LISTEN_FDS": "-1"}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getenv := func(key string) string { return tt.env[key] }
+
+			// when
+			n, names, err := listenFDs(getenv, 42)
+
+			// then
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.n, n)
+			if tt.n > 0 {
+				assert.Equal(t, tt.names, names)
+			}
+		})
+	}
+}
+
+func Test_fileListeners_ShouldUseFiles(t *testing.T) {
+	// given
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer f.Close()
+
+	// when
+	listeners, err := fileListeners([]*os.File{f}, []string{"http"})
+
+	// then
+	require.NoError(t, err)
+	require.Len(t, listeners, 1)
+	defer listeners[0].ln.Close()
+	assert.Equal(t, "http", listeners[0].name)
+	assert.Equal(t, ln.Addr().String(), listeners[0].ln.Addr().String())
+}
+
+func Test_fileListeners_ShouldRejectNotSocket(t *testing.T) {
+	// given
+	f, err := os.CreateTemp(t.TempDir(), "fd")
+	require.NoError(t, err)
+	defer f.Close()
+
+	// when
+	_, err = fileListeners([]*os.File{f}, []string{""})
+
+	// then
+	assert.Error(t, err)
+}
+
+func Test_sameAddr(t *testing.T) {
+	tests := []struct {
+		name string
+		addr net.Addr
+		to   string
+		same bool
+	}{
+		{name: "any host", addr: &net.TCPAddr{IP: net.IPv6zero, Port: 8080}, to: ":8080", same: true},
+		{name: "same host", addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8080}, to: "127.0.0.1:8080", same: true},
+		{name: "other host", addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8080}, to: "10.0.0.1:8080"},
+		{name: "other port", addr: &net.TCPAddr{IP: net.IPv6zero, Port: 8080}, to: ":8081"},
+		{name: "invalid address", addr: &net.TCPAddr{IP: net.IPv6zero, Port: 8080}, to: "8080"},
+		{name: "unix socket", addr: &net.UnixAddr{Name: "/run/app.sock", Net: "unix"}, to: "/run/app.sock", same: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.same, sameAddr(tt.addr, tt.to))
+		})
+	}
+}
+
+func Test_environ_ShouldDropSocketVariables(t *testing.T) {
+	// given
+	t.Setenv("LISTEN_FDS", "2")
+	t.Setenv("LISTEN_PID", "42")
+	t.Setenv("LISTEN_FDNAMES", "http:admin")
+	t.Setenv("APP_UPGRADE_READY_FD", "5")
+	t.Setenv("APP_HTTP_PORT", "8080")
+
+	// when
+	env := environ()
+
+	// then
+	assert.Contains(t, env, "APP_HTTP_PORT=8080")
+	for _, kv := range env {
+		assert.NotRegexp(t, "^(LISTEN_FDS|LISTEN_PID|LISTEN_FDNAMES|APP_UPGRADE_READY_FD)=", kv)
+	}
+}
+
+func Test_Upgrader_Listen_ShouldTakeInheritedListener(t *testing.T) {
+	// given
+	named, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unnamed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	u := &Upgrader{
+		l:         zap.NewNop().Sugar(),
+		listeners: make(map[string]net.Listener),
+		inherited: []inheritedListener{{name: "http", ln: named}, {ln: unnamed}},
+	}
+
+	// when
+	http, err := u.Listen("http", ":0")
+	require.NoError(t, err)
+	admin, err := u.Listen("admin", unnamed.Addr().String())
+	require.NoError(t, err)
+
+	// then
+	assert.Equal(t, named, http, "listener should be matched by the name")
+	assert.Equal(t, unnamed, admin, "unnamed listener should be matched by the address")
+	assert.Empty(t, u.inherited)
+	_, err = u.Listen("http", ":0")
+	assert.Error(t, err, "listener names should be unique")
+	require.NoError(t, u.Ready())
+	http.Close()
+	admin.Close()
+}